@@ -1,38 +1,169 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type APIServer struct {
 	listenAddr string
-	store      Storage
+	service    *SchoolService
+	// the broker the store publishes to, /api/events is unavailable without it
+	events *EventBroker
 }
 
 func NewAPIServer(listenAddr string, store Storage) *APIServer {
 	return &APIServer{
 		listenAddr: listenAddr,
-		store:      store,
+		service:    NewSchoolService(store),
 	}
 }
 
-func (s *APIServer) Run() {
-	router := mux.NewRouter()
+// how long a shutting down server waits for the requests in flight
+const shutdownTimeout = 10 * time.Second
 
-	router.HandleFunc("/api/register", makeHTTPHandlerFunc(s.registerStudentsToTeacher)).Methods("POST")
-	router.HandleFunc("/api/commonstudents", makeHTTPHandlerFunc(s.getCommonStudents)).Methods("GET")
-	router.HandleFunc("/api/suspend", makeHTTPHandlerFunc(s.suspendStudent)).Methods("POST")
-	router.HandleFunc("/api/retrievefornotifications", makeHTTPHandlerFunc(s.studentsToGetNotification)).Methods("POST")
+// serves the JSON API until ctx is done, then stops taking connections and
+// waits up to shutdownTimeout for the requests in flight. event streams are
+// ended so they do not hold the shutdown up
+func (s *APIServer) Run(ctx context.Context) error {
+	server := &http.Server{Addr: s.listenAddr, Handler: s.Router()}
+	if s.events != nil {
+		server.RegisterOnShutdown(s.events.Close)
+	}
 
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
 	log.Println("JSON API running on port: ", s.listenAddr)
 
-	http.ListenAndServe(s.listenAddr, router)
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// requests with a "X-Read-Your-Writes: true" header read from the primary
+// database, so they see the writes made just before them
+const readYourWritesHeader = "X-Read-Your-Writes"
+
+func withReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readYourWrites, _ := strconv.ParseBool(r.Header.Get(readYourWritesHeader)); readYourWrites {
+			r = r.WithContext(WithReadYourWrites(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// every route of the JSON API, each one must be described in openapi.json.
+// each version of the API has its own subrouter, so a v2 can change request
+// and response types without touching the v1 handlers
+func (s *APIServer) Router() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(withRequestInfo, withReadYourWrites)
+
+	v1 := router.PathPrefix("/api/v1").Subrouter()
+	v1.Use(s.idempotent)
+	s.registerV1Routes(v1)
+
+	// the unversioned paths clients used before /api/v1 existed
+	legacy := router.PathPrefix("/api").Subrouter()
+	legacy.Use(deprecatedRoute, s.idempotent)
+	s.registerV1Routes(legacy)
+
+	router.HandleFunc("/openapi.json", serveOpenAPISpec).Methods("GET")
+	router.HandleFunc("/docs", serveAPIDocs).Methods("GET")
+	router.Handle("/docs/{asset}", serveAPIDocsAssets()).Methods("GET")
+
+	return router
+}
+
+func (s *APIServer) registerV1Routes(router *mux.Router) {
+	router.HandleFunc("/register", makeHTTPHandlerFunc(s.registerStudentsToTeacher)).Methods("POST")
+	router.HandleFunc("/commonstudents", makeHTTPHandlerFunc(s.getCommonStudents)).Methods("GET")
+	router.HandleFunc("/deregister", makeHTTPHandlerFunc(s.deregisterStudentsFromTeacher)).Methods("POST")
+	router.HandleFunc("/suspend", makeHTTPHandlerFunc(s.suspendStudent)).Methods("POST")
+	router.HandleFunc("/unsuspend", makeHTTPHandlerFunc(s.unsuspendStudent)).Methods("POST")
+	router.HandleFunc("/retrievefornotifications", makeHTTPHandlerFunc(s.studentsToGetNotification)).Methods("POST")
+
+	router.HandleFunc("/import", makeHTTPHandlerFunc(s.importRoster)).Methods("POST")
+	router.HandleFunc("/export", makeHTTPHandlerFunc(s.exportRoster)).Methods("GET")
+
+	router.HandleFunc("/teachers", makeHTTPHandlerFunc(s.getTeachers)).Methods("GET")
+	router.HandleFunc("/teachers/{email}", makeHTTPHandlerFunc(s.getTeacher)).Methods("GET")
+	router.HandleFunc("/teachers/{email}", makeHTTPHandlerFunc(s.updateTeacher)).Methods("PATCH")
+	router.HandleFunc("/teachers/{email}", makeHTTPHandlerFunc(s.deleteTeacher)).Methods("DELETE")
+	router.HandleFunc("/teachers/{email}/restore", makeHTTPHandlerFunc(s.restoreTeacher)).Methods("POST")
+	router.HandleFunc("/teachers/{email}/rename", makeHTTPHandlerFunc(s.renameTeacher)).Methods("POST")
+	router.HandleFunc("/teachers/{email}/students", makeHTTPHandlerFunc(s.getStudentsOfTeacher)).Methods("GET")
+	router.HandleFunc("/students", makeHTTPHandlerFunc(s.getStudents)).Methods("GET")
+	router.HandleFunc("/students/{email}", makeHTTPHandlerFunc(s.getStudent)).Methods("GET")
+	router.HandleFunc("/students/{email}", makeHTTPHandlerFunc(s.updateStudent)).Methods("PATCH")
+	router.HandleFunc("/students/{email}", makeHTTPHandlerFunc(s.deleteStudent)).Methods("DELETE")
+	router.HandleFunc("/students/{email}/restore", makeHTTPHandlerFunc(s.restoreStudent)).Methods("POST")
+	router.HandleFunc("/students/{email}/rename", makeHTTPHandlerFunc(s.renameStudent)).Methods("POST")
+	router.HandleFunc("/students/{email}/data", makeHTTPHandlerFunc(s.exportStudentData)).Methods("GET")
+	router.HandleFunc("/students/{email}/erase", makeHTTPHandlerFunc(s.eraseStudent)).Methods("POST")
+	router.HandleFunc("/students/{email}/teachers", makeHTTPHandlerFunc(s.getTeachersOfStudent)).Methods("GET")
+
+	router.HandleFunc("/classes", makeHTTPHandlerFunc(s.createClass)).Methods("POST")
+	router.HandleFunc("/classes", makeHTTPHandlerFunc(s.getClasses)).Methods("GET")
+	router.HandleFunc("/classes/{name}", makeHTTPHandlerFunc(s.getClass)).Methods("GET")
+	router.HandleFunc("/classes/{name}", makeHTTPHandlerFunc(s.updateClass)).Methods("PUT")
+	router.HandleFunc("/classes/{name}", makeHTTPHandlerFunc(s.deleteClass)).Methods("DELETE")
+	router.HandleFunc("/classes/{name}/students", makeHTTPHandlerFunc(s.getClassStudents)).Methods("GET")
+	router.HandleFunc("/classes/{name}/students", makeHTTPHandlerFunc(s.addClassStudents)).Methods("POST")
+	router.HandleFunc("/classes/{name}/students/{email}", makeHTTPHandlerFunc(s.removeClassStudent)).Methods("DELETE")
+	router.HandleFunc("/classes/{name}/register", makeHTTPHandlerFunc(s.registerClassToTeacher)).Methods("POST")
+
+	router.HandleFunc("/audit", makeHTTPHandlerFunc(s.getAuditLog)).Methods("GET")
+
+	router.HandleFunc("/events", makeHTTPHandlerFunc(s.streamEvents)).Methods("GET")
+
+	router.HandleFunc("/webhooks", makeHTTPHandlerFunc(s.createWebhook)).Methods("POST")
+	router.HandleFunc("/webhooks", makeHTTPHandlerFunc(s.getWebhooks)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", makeHTTPHandlerFunc(s.getWebhook)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", makeHTTPHandlerFunc(s.deleteWebhook)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", makeHTTPHandlerFunc(s.getWebhookDeliveries)).Methods("GET")
+}
+
+// the dates the unversioned /api paths were deprecated and stop being
+// served
+var (
+	legacyDeprecation = time.Date(2026, time.June, 30, 0, 0, 0, 0, time.UTC)
+	legacySunset      = time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// marks responses of the unversioned /api paths as deprecated and points
+// clients at the /api/v1 path replacing them. Deprecation is the RFC 9745
+// structured date, e.g. @1782777600
+func deprecatedRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecation.Unix()))
+		w.Header().Set("Sunset", legacySunset.Format(http.TimeFormat))
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", v1Path(r.URL.Path)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the /api/v1 path of an unversioned /api path
+func v1Path(path string) string {
+	return "/api/v1" + strings.TrimPrefix(path, "/api")
 }
 
 // Assignment API functions
@@ -43,50 +174,30 @@ func (s *APIServer) registerStudentsToTeacher(w http.ResponseWriter, r *http.Req
 		return err
 	}
 
-	// check if the students exist in the database
-	exists, err := s.store.TeacherExists(registerStudentsToTeacherReq.TeacherEmail)
-	if err != nil {
+	if registerStudentsToTeacherReq.TeacherEmail == "" {
+		return fmt.Errorf("teacher email is required")
+	}
+
+	if err := s.service.RegisterStudents(r.Context(), registerStudentsToTeacherReq.TeacherEmail, registerStudentsToTeacherReq.StudentEmails); err != nil {
 		return err
 	}
-	// if entry does not exist, then i create a new entry
-	if !exists {
-		teacher := NewTeacher(registerStudentsToTeacherReq.TeacherEmail)
-		err := s.store.CreateTeacher(teacher)
-		if err != nil {
-			return err
-		}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) deregisterStudentsFromTeacher(w http.ResponseWriter, r *http.Request) error {
+	deregisterStudentsReq := new(DeregisterStudentsRequest)
+	if err := json.NewDecoder(r.Body).Decode(deregisterStudentsReq); err != nil {
+		return err
 	}
 
-	for _, studentEmail := range registerStudentsToTeacherReq.StudentEmails {
-		// check if the students exist in the database
-		exists, err := s.store.StudentExists(studentEmail)
-		if err != nil {
-			return err
-		}
-		// if entry does not exist, then i create a new entry
-		if !exists {
-			student := NewStudent(studentEmail)
-			err := s.store.CreateStudent(student)
-			if err != nil {
-				return err
-			}
-		}
+	if deregisterStudentsReq.TeacherEmail == "" {
+		return fmt.Errorf("teacher email is required")
 	}
 
-	for _, studentEmail := range registerStudentsToTeacherReq.StudentEmails {
-		// check if the students exist in the database
-		exists, err := s.store.TeacherStudentExists(registerStudentsToTeacherReq.TeacherEmail, studentEmail)
-		if err != nil {
-			return err
-		}
-		// if entry does not exist, then i create a new entry
-		if !exists {
-			teacherstudent := NewTeacherStudent(registerStudentsToTeacherReq.TeacherEmail, studentEmail)
-			err := s.store.CreateTeacherStudent(teacherstudent)
-			if err != nil {
-				return err
-			}
-		}
+	if err := s.service.DeregisterStudents(r.Context(), deregisterStudentsReq.TeacherEmail, deregisterStudentsReq.StudentEmails); err != nil {
+		return err
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -99,7 +210,7 @@ func (s *APIServer) getCommonStudents(w http.ResponseWriter, r *http.Request) er
 	// Get slice of teacher emails
 	teachers := query["teacher"]
 
-	commonStudents, err := s.store.GetCommonStudentsOfTeachers(teachers)
+	commonStudents, err := s.service.CommonStudents(r.Context(), teachers)
 	if err != nil {
 		return err
 	}
@@ -115,19 +226,21 @@ func (s *APIServer) suspendStudent(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
-	//check if student exists
-	exists, err := s.store.StudentExists(suspendStudentReq.StudentEmail)
-	if err != nil {
+	if err := s.service.SuspendStudent(r.Context(), suspendStudentReq.StudentEmail); err != nil {
 		return err
 	}
 
-	if !exists {
-		return fmt.Errorf("student does not exist")
-	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
 
-	err = s.store.UpdateStudentSuspendedState(suspendStudentReq.StudentEmail, true)
+func (s *APIServer) unsuspendStudent(w http.ResponseWriter, r *http.Request) error {
+	unsuspendStudentReq := new(SuspendStudentRequest)
+	if err := json.NewDecoder(r.Body).Decode(unsuspendStudentReq); err != nil {
+		return err
+	}
 
-	if err != nil {
+	if err := s.service.UnsuspendStudent(r.Context(), unsuspendStudentReq.StudentEmail); err != nil {
 		return err
 	}
 
@@ -141,67 +254,542 @@ func (s *APIServer) studentsToGetNotification(w http.ResponseWriter, r *http.Req
 		return err
 	}
 
-	notifiedStudentsResponse := NotifiedStudentsResponse{}
-	// get all students under teacher
-	students, err := s.store.GetStudentsAssignedToTeacher(studentsToGetNotificationReq.TeacherEmail)
+	recipients, err := s.service.SendNotification(r.Context(), studentsToGetNotificationReq.TeacherEmail, studentsToGetNotificationReq.NotificationString)
 	if err != nil {
 		return err
 	}
-	// check if student is suspended
-	for _, student := range students {
-		isSuspended, err := s.store.IsStudentSuspended(student)
+
+	return WriteJSON(w, http.StatusOK, NotifiedStudentsResponse{StudentEmails: recipients})
+}
+
+// Roster API functions
+
+// largest roster accepted by /api/import
+const maxRosterSize = 32 << 20
+
+// imports a teacher,student[,class] CSV roster sent as the request body.
+// with ?dry_run=true nothing is written and the report shows what would change
+func (s *APIServer) importRoster(w http.ResponseWriter, r *http.Request) error {
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		b, err := strconv.ParseBool(value)
 		if err != nil {
-			return err
+			return fmt.Errorf("dry_run must be true or false")
+		}
+		dryRun = b
+	}
+
+	report, err := s.service.ImportRoster(r.Context(), http.MaxBytesReader(w, r.Body, maxRosterSize), dryRun)
+	if err != nil {
+		// invalid rows are listed in the report
+		if report != nil && len(report.Errors) > 0 {
+			return WriteJSON(w, http.StatusBadRequest, report)
 		}
-		if !isSuspended {
-			//fmt.Println(students)
-			notifiedStudentsResponse.StudentEmails = append(notifiedStudentsResponse.StudentEmails, student)
+		// so are the batches imported before one failed
+		if report != nil {
+			report.Error = err.Error()
+			return WriteJSON(w, errorStatus(w, err), report)
 		}
+		return err
 	}
 
-	// get @mentioned students
-	// Define the regular expression pattern to match email addresses
-	emailPattern := `@([\w.%+-]+@[\w.-]+\.[a-zA-Z]{2,})` //`\b[\w.%+-]+@[\w.-]+\.[a-zA-Z]{2,}\b`
+	return WriteJSON(w, http.StatusOK, report)
+}
 
-	// Compile the regular expression pattern
-	re := regexp.MustCompile(emailPattern)
+// streams the roster as ?format=csv (the default) or ?format=jsonl
+func (s *APIServer) exportRoster(w http.ResponseWriter, r *http.Request) error {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+	if !IsValidExportFormat(format) {
+		return fmt.Errorf("format must be csv or jsonl")
+	}
 
-	// Find all matches of the pattern in the input string
-	mentionedStudents := re.FindAllString(studentsToGetNotificationReq.NotificationString, -1)
+	contentType := "text/csv"
+	if format == ExportFormatJSONL {
+		contentType = "application/x-ndjson"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="roster.%s"`, format))
+	w.WriteHeader(http.StatusOK)
 
-	// Print the extracted student emails
-	for _, studentEmail := range mentionedStudents {
-		// remove the first @ of the mention to get the email
-		studentEmail = studentEmail[1:]
+	// the status has already been sent, so a failure can only cut the response short
+	if err := s.service.ExportRoster(r.Context(), w, format); err != nil {
+		log.Println("roster export failed: ", err)
+	}
+	return nil
+}
 
-		// check if student exist in database
-		exists, err := s.store.StudentExists(studentEmail)
-		if err != nil {
-			return err
+// Teacher and Student API functions
+func (s *APIServer) getTeachers(w http.ResponseWriter, r *http.Request) error {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return err
+	}
+
+	teachers, cursor, err := s.service.ListTeachers(r.Context(), opts)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, TeachersResponse{Teachers: teachers, NextCursor: cursor})
+}
+
+func (s *APIServer) getTeacher(w http.ResponseWriter, r *http.Request) error {
+	teacher, err := s.service.GetTeacher(r.Context(), mux.Vars(r)["email"])
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, teacher)
+}
+
+func (s *APIServer) getStudentsOfTeacher(w http.ResponseWriter, r *http.Request) error {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return err
+	}
+
+	students, cursor, err := s.service.StudentsOfTeacher(r.Context(), mux.Vars(r)["email"], opts)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, StudentsResponse{Students: students, NextCursor: cursor})
+}
+
+func (s *APIServer) getStudents(w http.ResponseWriter, r *http.Request) error {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return err
+	}
+
+	students, cursor, err := s.service.ListStudents(r.Context(), opts)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, StudentsResponse{Students: students, NextCursor: cursor})
+}
+
+func (s *APIServer) getStudent(w http.ResponseWriter, r *http.Request) error {
+	student, err := s.service.GetStudent(r.Context(), mux.Vars(r)["email"])
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, student)
+}
+
+func (s *APIServer) getTeachersOfStudent(w http.ResponseWriter, r *http.Request) error {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return err
+	}
+
+	teachers, cursor, err := s.service.TeachersOfStudent(r.Context(), mux.Vars(r)["email"], opts)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, TeachersResponse{Teachers: teachers, NextCursor: cursor})
+}
+
+// Profile API functions
+func (s *APIServer) updateTeacher(w http.ResponseWriter, r *http.Request) error {
+	updateTeacherReq := new(UpdateTeacherRequest)
+	if err := json.NewDecoder(r.Body).Decode(updateTeacherReq); err != nil {
+		return err
+	}
+
+	teacher, err := s.service.UpdateTeacherProfile(r.Context(), mux.Vars(r)["email"], updateTeacherReq)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, teacher)
+}
+
+func (s *APIServer) updateStudent(w http.ResponseWriter, r *http.Request) error {
+	updateStudentReq := new(UpdateStudentRequest)
+	if err := json.NewDecoder(r.Body).Decode(updateStudentReq); err != nil {
+		return err
+	}
+
+	student, err := s.service.UpdateStudentProfile(r.Context(), mux.Vars(r)["email"], updateStudentReq)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, student)
+}
+
+func (s *APIServer) deleteTeacher(w http.ResponseWriter, r *http.Request) error {
+	if err := s.service.DeleteTeacher(r.Context(), mux.Vars(r)["email"]); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) restoreTeacher(w http.ResponseWriter, r *http.Request) error {
+	email := mux.Vars(r)["email"]
+	if err := s.service.RestoreTeacher(r.Context(), email); err != nil {
+		return err
+	}
+
+	teacher, err := s.service.GetTeacher(r.Context(), email)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, teacher)
+}
+
+func (s *APIServer) deleteStudent(w http.ResponseWriter, r *http.Request) error {
+	if err := s.service.DeleteStudent(r.Context(), mux.Vars(r)["email"]); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) restoreStudent(w http.ResponseWriter, r *http.Request) error {
+	email := mux.Vars(r)["email"]
+	if err := s.service.RestoreStudent(r.Context(), email); err != nil {
+		return err
+	}
+
+	student, err := s.service.GetStudent(r.Context(), email)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, student)
+}
+
+func (s *APIServer) renameTeacher(w http.ResponseWriter, r *http.Request) error {
+	renameReq := new(RenameRequest)
+	if err := json.NewDecoder(r.Body).Decode(renameReq); err != nil {
+		return err
+	}
+
+	teacher, err := s.service.RenameTeacher(r.Context(), mux.Vars(r)["email"], renameReq.Email)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, teacher)
+}
+
+func (s *APIServer) renameStudent(w http.ResponseWriter, r *http.Request) error {
+	renameReq := new(RenameRequest)
+	if err := json.NewDecoder(r.Body).Decode(renameReq); err != nil {
+		return err
+	}
+
+	student, err := s.service.RenameStudent(r.Context(), mux.Vars(r)["email"], renameReq.Email)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, student)
+}
+
+func (s *APIServer) exportStudentData(w http.ResponseWriter, r *http.Request) error {
+	export, err := s.service.ExportStudentData(r.Context(), mux.Vars(r)["email"])
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="student-data.json"`)
+	return WriteJSON(w, http.StatusOK, export)
+}
+
+func (s *APIServer) eraseStudent(w http.ResponseWriter, r *http.Request) error {
+	erased, err := s.service.EraseStudent(r.Context(), mux.Vars(r)["email"])
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, EraseStudentResponse{ErasedEmail: erased})
+}
+
+// Class API functions
+func (s *APIServer) createClass(w http.ResponseWriter, r *http.Request) error {
+	createClassReq := new(CreateClassRequest)
+	if err := json.NewDecoder(r.Body).Decode(createClassReq); err != nil {
+		return err
+	}
+
+	class, err := s.service.CreateClass(r.Context(), createClassReq.Name, createClassReq.Kind)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, class)
+}
+
+func (s *APIServer) getClasses(w http.ResponseWriter, r *http.Request) error {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return err
+	}
+
+	classes, cursor, err := s.service.ListClasses(r.Context(), opts)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, ClassesResponse{Classes: classes, NextCursor: cursor})
+}
+
+func (s *APIServer) getClass(w http.ResponseWriter, r *http.Request) error {
+	class, err := s.service.GetClass(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, class)
+}
+
+func (s *APIServer) updateClass(w http.ResponseWriter, r *http.Request) error {
+	updateClassReq := new(UpdateClassRequest)
+	if err := json.NewDecoder(r.Body).Decode(updateClassReq); err != nil {
+		return err
+	}
+
+	class, err := s.service.UpdateClass(r.Context(), mux.Vars(r)["name"], updateClassReq)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, class)
+}
+
+func (s *APIServer) deleteClass(w http.ResponseWriter, r *http.Request) error {
+	if err := s.service.DeleteClass(r.Context(), mux.Vars(r)["name"]); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) getClassStudents(w http.ResponseWriter, r *http.Request) error {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return err
+	}
+
+	students, cursor, err := s.service.StudentsInClass(r.Context(), mux.Vars(r)["name"], opts)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, ClassStudentsResponse{StudentEmails: students, NextCursor: cursor})
+}
+
+func (s *APIServer) addClassStudents(w http.ResponseWriter, r *http.Request) error {
+	classStudentsReq := new(ClassStudentsRequest)
+	if err := json.NewDecoder(r.Body).Decode(classStudentsReq); err != nil {
+		return err
+	}
+
+	if err := s.service.AddStudentsToClass(r.Context(), mux.Vars(r)["name"], classStudentsReq.StudentEmails); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) removeClassStudent(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	if err := s.service.RemoveStudentFromClass(r.Context(), vars["name"], vars["email"]); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) registerClassToTeacher(w http.ResponseWriter, r *http.Request) error {
+	registerClassReq := new(RegisterClassToTeacherRequest)
+	if err := json.NewDecoder(r.Body).Decode(registerClassReq); err != nil {
+		return err
+	}
+
+	if registerClassReq.TeacherEmail == "" {
+		return fmt.Errorf("teacher email is required")
+	}
+
+	if err := s.service.RegisterClassToTeacher(r.Context(), mux.Vars(r)["name"], registerClassReq.TeacherEmail); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Audit API functions
+func (s *APIServer) getAuditLog(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return err
+	}
+
+	filter := AuditFilter{
+		Actor:         query.Get("actor"),
+		Target:        query.Get("target"),
+		Action:        query.Get("action"),
+		CreatedAfter:  opts.CreatedAfter,
+		CreatedBefore: opts.CreatedBefore,
+		Limit:         opts.Limit,
+		Cursor:        opts.Cursor,
+	}
+
+	entries, cursor, err := s.service.AuditLog(r.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, AuditResponse{Entries: entries, NextCursor: cursor})
+}
+
+// Event API functions
+
+// how often a comment is sent on an idle event stream so proxies keep it open
+const eventStreamKeepAlive = 15 * time.Second
+
+// streams events as Server-Sent Events until the client disconnects.
+// ?types=StudentRegistered,StudentSuspended only streams those event types
+func (s *APIServer) streamEvents(w http.ResponseWriter, r *http.Request) error {
+	if s.events == nil {
+		return fmt.Errorf("event stream is not available")
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported")
+	}
+
+	types := map[string]bool{}
+	if param := r.URL.Query().Get("types"); param != "" {
+		for _, eventType := range strings.Split(param, ",") {
+			if !IsValidEventType(eventType) {
+				return fmt.Errorf("invalid event type %s", eventType)
+			}
+			types[eventType] = true
 		}
-		if exists {
-			// check if student is suspended
-			isSuspended, err := s.store.IsStudentSuspended(studentEmail)
-			if err != nil {
-				return err
+	}
+
+	events, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-events:
+			// the stream fell too far behind, the client reconnects
+			if !ok {
+				return nil
+			}
+			if len(types) > 0 && !types[event.Type] {
+				continue
 			}
-			if !isSuspended {
-				// check for duplicates
-				hasDuplicate := StringExistsInArray(studentEmail, notifiedStudentsResponse.StudentEmails)
-				if !hasDuplicate {
-					notifiedStudentsResponse.StudentEmails = append(notifiedStudentsResponse.StudentEmails, studentEmail)
-				}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return nil
 			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
 		}
-		// else {
-		// 	return fmt.Errorf("@mentioned student %s does not exist in database", student_email)
-		// }
+		flusher.Flush()
+	}
+}
+
+// Webhook API functions
+func (s *APIServer) createWebhook(w http.ResponseWriter, r *http.Request) error {
+	createWebhookReq := new(CreateWebhookRequest)
+	if err := json.NewDecoder(r.Body).Decode(createWebhookReq); err != nil {
+		return err
+	}
+
+	webhook, err := s.service.CreateWebhook(r.Context(), createWebhookReq.URL, createWebhookReq.EventTypes, createWebhookReq.Secret)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusCreated, webhook)
+}
+
+func (s *APIServer) getWebhooks(w http.ResponseWriter, r *http.Request) error {
+	webhooks, err := s.service.ListWebhooks(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, WebhooksResponse{Webhooks: webhooks})
+}
+
+func (s *APIServer) getWebhook(w http.ResponseWriter, r *http.Request) error {
+	id, err := webhookID(r)
+	if err != nil {
+		return err
 	}
 
-	//fmt.Println(studentsToGetNotificationReq.TeacherEmail)
-	//fmt.Println(studentsToGetNotificationReq.NotificationString)
+	webhook, err := s.service.GetWebhook(r.Context(), id)
+	if err != nil {
+		return err
+	}
 
-	return WriteJSON(w, http.StatusOK, notifiedStudentsResponse)
+	return WriteJSON(w, http.StatusOK, webhook)
+}
+
+func (s *APIServer) deleteWebhook(w http.ResponseWriter, r *http.Request) error {
+	id, err := webhookID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.service.DeleteWebhook(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) error {
+	id, err := webhookID(r)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := s.service.WebhookDeliveries(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, WebhookDeliveriesResponse{Deliveries: deliveries})
+}
+
+func webhookID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid webhook id %s", mux.Vars(r)["id"])
+	}
+	return id, nil
 }
 
 // utility functions
@@ -212,6 +800,63 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// reads the pagination, filter and sort query parameters of list endpoints:
+// limit, cursor, sort (email or created_at), order (asc or desc), email_prefix,
+// created_after, created_before (RFC 3339) and suspended (true or false)
+func parseListOptions(r *http.Request) (ListOptions, error) {
+	query := r.URL.Query()
+	opts := ListOptions{
+		Limit:       defaultPageSize,
+		Cursor:      query.Get("cursor"),
+		Sort:        query.Get("sort"),
+		EmailPrefix: query.Get("email_prefix"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		opts.Limit = n
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, fmt.Errorf("order must be asc or desc")
+	}
+
+	for param, field := range map[string]**time.Time{
+		"created_after":  &opts.CreatedAfter,
+		"created_before": &opts.CreatedBefore,
+	} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			*field = &t
+		}
+	}
+
+	if suspended := query.Get("suspended"); suspended != "" {
+		b, err := strconv.ParseBool(suspended)
+		if err != nil {
+			return opts, fmt.Errorf("suspended must be true or false")
+		}
+		opts.Suspended = &b
+	}
+
+	return opts, nil
+}
+
 type apiFunc func(http.ResponseWriter, *http.Request) error
 
 // convert my api Functions to functions of type http.HandlerFunc.
@@ -221,11 +866,33 @@ func makeHTTPHandlerFunc(f apiFunc) http.HandlerFunc {
 		err := f(w, r)
 		if err != nil {
 			// handle error
-			WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			writeError(w, err)
 		}
 	}
 }
 
+// errors are the client's fault and answered with 400, unless the database
+// is unavailable. clients are then asked to retry once the circuit breaker
+// lets calls through again
+func writeError(w http.ResponseWriter, err error) error {
+	return WriteJSON(w, errorStatus(w, err), ApiError{Error: err.Error()})
+}
+
+// the status of a response reporting err, setting the headers that go with it
+func errorStatus(w http.ResponseWriter, err error) int {
+	if errors.Is(err, ErrDatabaseUnavailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(circuitBreakerCooldown.Seconds())))
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, ErrEmailTaken) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, ErrAuditNotRecorded) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
 func StringExistsInArray(input string, input_array []string) bool {
 	for _, s := range input_array {
 		if s == input {
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// who is acting and on behalf of which request, recorded with every audit
+// entry. the APIs and the command line put it in the context of each call.
+// Actor is taken from where the call came from: the address of an API client
+// or the user running the command line. ClaimedActor is who the client says
+// it acts for, which nothing checks, so it is only kept as a hint
+type AuditInfo struct {
+	Actor        string
+	ClaimedActor string
+	RequestID    string
+}
+
+// the actor of calls that do not say who they are acting for
+const anonymousActor = "anonymous"
+
+const (
+	actorHeader     = "X-Actor"
+	requestIDHeader = "X-Request-ID"
+)
+
+type auditInfoKey struct{}
+
+func WithAuditInfo(ctx context.Context, actor string, claimedActor string, requestID string) context.Context {
+	if actor == "" {
+		actor = anonymousActor
+	}
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	return context.WithValue(ctx, auditInfoKey{}, AuditInfo{Actor: actor, ClaimedActor: claimedActor, RequestID: requestID})
+}
+
+func auditInfoFromContext(ctx context.Context) AuditInfo {
+	if info, ok := ctx.Value(auditInfoKey{}).(AuditInfo); ok {
+		return info
+	}
+	return AuditInfo{Actor: anonymousActor}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// the actor of a call to one of the APIs, named after the API and the
+// address of the client, e.g. http:192.0.2.1
+func remoteActor(api string, addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if addr == "" {
+		return api
+	}
+	return api + ":" + addr
+}
+
+// takes the actor of a JSON API request from the address of the client, see
+// remoteActor, and the claimed actor and request ID from the X-Actor and
+// X-Request-ID headers, generating a request ID if there is none. the
+// request ID is echoed in the response
+func withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithAuditInfo(r.Context(), remoteActor("http", r.RemoteAddr), r.Header.Get(actorHeader), r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, auditInfoFromContext(ctx).RequestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// a cursor of the audit log holds the id of the last entry of a page
+type auditCursor struct {
+	ID int64 `json:"i"`
+}
+
+func encodeAuditCursor(id int64) string {
+	b, _ := json.Marshal(auditCursor{ID: id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeAuditCursor(s string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	cursor := new(auditCursor)
+	if err := json.Unmarshal(b, cursor); err != nil || cursor.ID <= 0 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return cursor.ID, nil
+}
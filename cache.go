@@ -0,0 +1,284 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CachingStore is a Storage that keeps the answers of the lookups made for
+// every notification, IsStudentSuspended and GetStudentsAssignedToTeacher
+// without list options, in memory. the other methods go straight to the
+// wrapped store.
+//
+// cached answers are dropped when they are changed through this store, and
+// expire after the TTL otherwise, so changes made by another process (e.g.
+// an admin command) can be missed for up to the TTL. concurrent misses of
+// the same key load it once
+type CachingStore struct {
+	Storage
+
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+	// incremented by every invalidation, so a load that started before one
+	// does not cache what it read
+	generation uint64
+
+	loads singleflight.Group
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+type cacheEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+}
+
+const (
+	cacheKeySuspended        = "suspended:"
+	cacheKeyAssignedStudents = "assigned:"
+)
+
+// caches lookups of store for ttl, keeping at most maxEntries answers
+func NewCachingStore(store Storage, ttl time.Duration, maxEntries int) *CachingStore {
+	return &CachingStore{
+		Storage:    store,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+func (c *CachingStore) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+	}
+}
+
+// logs the stats every interval until ctx is done, and once more then
+func (c *CachingStore) LogStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.Printf("cache: %s", c.Stats())
+		case <-ctx.Done():
+			log.Printf("cache: %s", c.Stats())
+			return
+		}
+	}
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses, %d evictions, %d entries", s.Hits, s.Misses, s.Evictions, s.Entries)
+}
+
+// the wrapped store without the cache, reading the writes made before
+func (c *CachingStore) Primary() Storage {
+	if primary, ok := c.Storage.(primaryReader); ok {
+		return primary.Primary()
+	}
+	return c.Storage
+}
+
+func (c *CachingStore) IsStudentSuspended(studentEmail string) (bool, error) {
+	value, err := c.get(cacheKeySuspended+studentEmail, func() (any, error) {
+		return c.Storage.IsStudentSuspended(studentEmail)
+	})
+	if err != nil {
+		return false, err
+	}
+	return value.(bool), nil
+}
+
+func (c *CachingStore) GetStudentsAssignedToTeacher(teacherEmail string, opts ListOptions) ([]string, string, error) {
+	// pages and filtered lists are not cached
+	if opts != (ListOptions{}) {
+		return c.Storage.GetStudentsAssignedToTeacher(teacherEmail, opts)
+	}
+
+	value, err := c.get(cacheKeyAssignedStudents+teacherEmail, func() (any, error) {
+		students, _, err := c.Storage.GetStudentsAssignedToTeacher(teacherEmail, opts)
+		return students, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	// the cached list is shared, callers get a copy they can change
+	return append([]string{}, value.([]string)...), "", nil
+}
+
+func (c *CachingStore) UpdateStudentSuspendedState(studentEmail string, suspended bool, audit ...*AuditEntry) error {
+	defer c.invalidate(cacheKeySuspended + studentEmail)
+	return c.Storage.UpdateStudentSuspendedState(studentEmail, suspended, audit...)
+}
+
+func (c *CachingStore) CreateTeacherStudent(teacherStudent *TeacherStudent, audit ...*AuditEntry) error {
+	defer c.invalidate(cacheKeyAssignedStudents + teacherStudent.TeacherEmail)
+	return c.Storage.CreateTeacherStudent(teacherStudent, audit...)
+}
+
+func (c *CachingStore) DeleteTeacherStudent(teacherEmail string, studentEmail string, audit ...*AuditEntry) error {
+	defer c.invalidate(cacheKeyAssignedStudents + teacherEmail)
+	return c.Storage.DeleteTeacherStudent(teacherEmail, studentEmail, audit...)
+}
+
+func (c *CachingStore) DeleteTeacher(email string, audit ...*AuditEntry) error {
+	defer c.invalidate(cacheKeyAssignedStudents + email)
+	return c.Storage.DeleteTeacher(email, audit...)
+}
+
+func (c *CachingStore) RestoreTeacher(email string, audit ...*AuditEntry) error {
+	defer c.invalidate(cacheKeyAssignedStudents + email)
+	return c.Storage.RestoreTeacher(email, audit...)
+}
+
+// a student is in the lists of any number of teachers, so all the lists are
+// dropped
+func (c *CachingStore) DeleteStudent(email string, audit ...*AuditEntry) error {
+	defer c.invalidatePrefix(cacheKeyAssignedStudents)
+	defer c.invalidate(cacheKeySuspended + email)
+	return c.Storage.DeleteStudent(email, audit...)
+}
+
+func (c *CachingStore) RestoreStudent(email string, audit ...*AuditEntry) error {
+	defer c.invalidatePrefix(cacheKeyAssignedStudents)
+	defer c.invalidate(cacheKeySuspended + email)
+	return c.Storage.RestoreStudent(email, audit...)
+}
+
+func (c *CachingStore) RenameTeacher(email string, newEmail string, audit ...*AuditEntry) error {
+	defer c.invalidate(cacheKeyAssignedStudents + newEmail)
+	defer c.invalidate(cacheKeyAssignedStudents + email)
+	return c.Storage.RenameTeacher(email, newEmail, audit...)
+}
+
+func (c *CachingStore) RenameStudent(email string, newEmail string, audit ...*AuditEntry) error {
+	defer c.invalidatePrefix(cacheKeyAssignedStudents)
+	defer c.invalidate(cacheKeySuspended + newEmail)
+	defer c.invalidate(cacheKeySuspended + email)
+	return c.Storage.RenameStudent(email, newEmail, audit...)
+}
+
+func (c *CachingStore) EraseStudent(email string, audit ...*AuditEntry) (string, error) {
+	defer c.invalidatePrefix(cacheKeyAssignedStudents)
+	defer c.invalidate(cacheKeySuspended + email)
+	return c.Storage.EraseStudent(email, audit...)
+}
+
+func (c *CachingStore) ImportRoster(rows []RosterRow, dryRun bool, audit func(TeacherStudentPair) *AuditEntry) (*ImportReport, error) {
+	if !dryRun {
+		defer c.invalidatePrefix(cacheKeyAssignedStudents)
+	}
+	return c.Storage.ImportRoster(rows, dryRun, audit)
+}
+
+// returns the cached value of key, or loads and caches it
+func (c *CachingStore) get(key string, load func() (any, error)) (any, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if c.now().Before(entry.expires) {
+			c.lru.MoveToFront(element)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return entry.value, nil
+		}
+		c.remove(element)
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	// loads started after an invalidation do not wait for ones started
+	// before it
+	flight := key + "@" + strconv.FormatUint(generation, 10)
+	value, err, _ := c.loads.Do(flight, func() (any, error) {
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.set(key, value, generation)
+		return value, nil
+	})
+	return value, err
+}
+
+func (c *CachingStore) set(key string, value any, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: c.now().Add(c.ttl)})
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *CachingStore) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *CachingStore) invalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key, element := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(element)
+		}
+	}
+}
+
+// the caller holds c.mu
+func (c *CachingStore) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counts the lookups that reach the store behind the cache
+type countingStore struct {
+	Storage
+	suspended map[string]bool
+	assigned  map[string][]string
+	lookups   atomic.Int64
+	// lookups wait for it to be closed if it is not nil
+	release chan struct{}
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{suspended: map[string]bool{}, assigned: map[string][]string{}}
+}
+
+func (s *countingStore) IsStudentSuspended(studentEmail string) (bool, error) {
+	s.lookups.Add(1)
+	if s.release != nil {
+		<-s.release
+	}
+	if studentEmail == "broken@gmail.com" {
+		return false, fmt.Errorf("connection reset")
+	}
+	return s.suspended[studentEmail], nil
+}
+
+func (s *countingStore) UpdateStudentSuspendedState(studentEmail string, suspended bool, audit ...*AuditEntry) error {
+	s.suspended[studentEmail] = suspended
+	return nil
+}
+
+func (s *countingStore) GetStudentsAssignedToTeacher(teacherEmail string, opts ListOptions) ([]string, string, error) {
+	s.lookups.Add(1)
+	return append([]string{}, s.assigned[teacherEmail]...), "", nil
+}
+
+func (s *countingStore) CreateTeacherStudent(teacherStudent *TeacherStudent, audit ...*AuditEntry) error {
+	s.assigned[teacherStudent.TeacherEmail] = append(s.assigned[teacherStudent.TeacherEmail], teacherStudent.StudentEmail)
+	return nil
+}
+
+func (s *countingStore) DeleteTeacherStudent(teacherEmail string, studentEmail string, audit ...*AuditEntry) error {
+	students := s.assigned[teacherEmail][:0]
+	for _, student := range s.assigned[teacherEmail] {
+		if student != studentEmail {
+			students = append(students, student)
+		}
+	}
+	s.assigned[teacherEmail] = students
+	return nil
+}
+
+func (s *countingStore) DeleteStudent(email string, audit ...*AuditEntry) error {
+	for teacherEmail := range s.assigned {
+		s.DeleteTeacherStudent(teacherEmail, email)
+	}
+	return nil
+}
+
+func TestCachingStoreHitsAndInvalidation(t *testing.T) {
+	store := newCountingStore()
+	cache := NewCachingStore(store, time.Minute, 100)
+
+	for i := 0; i < 3; i++ {
+		suspended, err := cache.IsStudentSuspended("studentjon@gmail.com")
+		if err != nil || suspended {
+			t.Fatalf("got %v, %v", suspended, err)
+		}
+	}
+	if err := cache.UpdateStudentSuspendedState("studentjon@gmail.com", true); err != nil {
+		t.Fatal(err)
+	}
+	suspended, err := cache.IsStudentSuspended("studentjon@gmail.com")
+	if err != nil || !suspended {
+		t.Errorf("got %v, %v after suspending", suspended, err)
+	}
+
+	cache.CreateTeacherStudent(NewTeacherStudent("teacherken@gmail.com", "studentjon@gmail.com"))
+	students, _, _ := cache.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	// changing the returned list does not change the cached one
+	students[0] = "changed@gmail.com"
+	cache.CreateTeacherStudent(NewTeacherStudent("teacherken@gmail.com", "studenthon@gmail.com"))
+	students, _, _ = cache.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	if len(students) != 2 || students[0] != "studentjon@gmail.com" {
+		t.Errorf("got %v after registering", students)
+	}
+	cache.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	cache.DeleteTeacherStudent("teacherken@gmail.com", "studentjon@gmail.com")
+	students, _, _ = cache.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	if len(students) != 1 || students[0] != "studenthon@gmail.com" {
+		t.Errorf("got %v after deregistering", students)
+	}
+
+	// pages are not cached
+	cache.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{Limit: 1})
+
+	stats := cache.Stats()
+	if stats.Hits != 3 || stats.Misses != 5 || store.lookups.Load() != 6 {
+		t.Errorf("got %+v with %d lookups, want 3 hits, 5 misses and 6 lookups", stats, store.lookups.Load())
+	}
+}
+
+func TestCachingStoreLogStats(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	cache := NewCachingStore(newCountingStore(), time.Minute, 100)
+	cache.IsStudentSuspended("studentjon@gmail.com")
+	cache.IsStudentSuspended("studentjon@gmail.com")
+
+	// the stats are logged once more when the server stops
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cache.LogStats(ctx, time.Hour)
+	if !strings.Contains(logged.String(), "cache: 1 hits, 1 misses, 0 evictions, 1 entries") {
+		t.Errorf("got log %q", logged.String())
+	}
+}
+
+func TestCachingStoreInvalidatesDeletedStudents(t *testing.T) {
+	store := newCountingStore()
+	cache := NewCachingStore(store, time.Minute, 100)
+	for _, teacher := range []string{"teacherken@gmail.com", "teacherjoe@gmail.com"} {
+		cache.CreateTeacherStudent(NewTeacherStudent(teacher, "studentjon@gmail.com"))
+		cache.GetStudentsAssignedToTeacher(teacher, ListOptions{})
+	}
+
+	// the student leaves the list of every teacher
+	cache.DeleteStudent("studentjon@gmail.com")
+	for _, teacher := range []string{"teacherken@gmail.com", "teacherjoe@gmail.com"} {
+		if students, _, _ := cache.GetStudentsAssignedToTeacher(teacher, ListOptions{}); len(students) != 0 {
+			t.Errorf("got %v for %s after deleting the student", students, teacher)
+		}
+	}
+}
+
+func TestCachingStoreExpiry(t *testing.T) {
+	store := newCountingStore()
+	cache := NewCachingStore(store, time.Minute, 100)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.IsStudentSuspended("studentjon@gmail.com")
+	// a change made behind the cache is seen once the entry expires
+	store.suspended["studentjon@gmail.com"] = true
+
+	now = now.Add(59 * time.Second)
+	if suspended, _ := cache.IsStudentSuspended("studentjon@gmail.com"); suspended {
+		t.Errorf("entry expired before the TTL")
+	}
+	now = now.Add(time.Second)
+	if suspended, _ := cache.IsStudentSuspended("studentjon@gmail.com"); !suspended {
+		t.Errorf("entry did not expire after the TTL")
+	}
+}
+
+func TestCachingStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := newCountingStore()
+	cache := NewCachingStore(store, time.Minute, 2)
+
+	cache.IsStudentSuspended("a@gmail.com")
+	cache.IsStudentSuspended("b@gmail.com")
+	cache.IsStudentSuspended("a@gmail.com")
+	cache.IsStudentSuspended("c@gmail.com") // evicts b
+
+	lookups := store.lookups.Load()
+	cache.IsStudentSuspended("a@gmail.com")
+	cache.IsStudentSuspended("c@gmail.com")
+	if store.lookups.Load() != lookups {
+		t.Errorf("a recently used entry was evicted")
+	}
+	cache.IsStudentSuspended("b@gmail.com")
+	if store.lookups.Load() != lookups+1 {
+		t.Errorf("the least recently used entry was not evicted")
+	}
+
+	if stats := cache.Stats(); stats.Evictions != 2 || stats.Entries != 2 {
+		t.Errorf("got %+v, want 2 evictions and 2 entries", stats)
+	}
+}
+
+func TestCachingStoreCollapsesConcurrentMisses(t *testing.T) {
+	store := newCountingStore()
+	store.release = make(chan struct{})
+	cache := NewCachingStore(store, time.Minute, 100)
+
+	const callers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.IsStudentSuspended("studentjon@gmail.com")
+		}()
+	}
+	// let the callers pile up on the first lookup before it finishes
+	for cache.Stats().Misses < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(store.release)
+	wg.Wait()
+
+	if lookups := store.lookups.Load(); lookups != 1 {
+		t.Errorf("got %d lookups for %d concurrent misses, want 1", lookups, callers)
+	}
+}
+
+func TestCachingStoreDoesNotCacheErrors(t *testing.T) {
+	store := newCountingStore()
+	cache := NewCachingStore(store, time.Minute, 100)
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.IsStudentSuspended("broken@gmail.com"); err == nil {
+			t.Errorf("expected the error of the store")
+		}
+	}
+	if store.lookups.Load() != 2 {
+		t.Errorf("an error was cached")
+	}
+}
+
+func TestCachingStoreConformance(t *testing.T) {
+	RunStorageTests(t, func(t *testing.T) Storage {
+		return NewCachingStore(newTestStore(t), time.Minute, 100)
+	})
+}
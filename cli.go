@@ -0,0 +1,662 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const usage = `usage: program [-output table|json] COMMAND [ARGS]
+
+commands:
+  serve [-addr ADDR] [-grpc-addr ADDR]       run the JSON and gRPC APIs (the default command)
+        [-cache-ttl D] [-cache-size N]
+  migrate                                    create or update the database tables
+  teacher add [-name NAME] EMAIL             add a teacher
+  teacher list [-limit N] [-email-prefix P]  list teachers
+  teacher delete|restore EMAIL               delete a teacher, or bring a deleted one back
+  teacher rename EMAIL NEW_EMAIL             change the email of a teacher
+  student suspend EMAIL                      suspend a student
+  student unsuspend EMAIL                    lift a student's suspension
+  student delete|restore EMAIL               delete a student, or bring a deleted one back
+  student rename EMAIL NEW_EMAIL             change the email of a student
+  student export EMAIL                       print everything stored about a student as JSON
+  student erase EMAIL                        anonymise a student's email everywhere, for good
+  register -teacher EMAIL STUDENT...         register students to a teacher
+  deregister -teacher EMAIL STUDENT...       remove students from a teacher
+  common TEACHER...                          list the students common to every teacher
+  notify [-dry-run] -teacher EMAIL MESSAGE   list the students who get a notification
+  import [-dry-run] FILE                     import a teacher,student[,class] CSV roster
+  export [-format csv|jsonl] [-o FILE]       export the roster
+  audit [-actor A] [-target T] [-limit N]    list the audit log, newest first
+  loadtest [-concurrency N] [-duration D]    send a mix of API calls and report their latency
+`
+
+// writes command results either as aligned columns or as JSON
+type cliOutput struct {
+	w    io.Writer
+	json bool
+}
+
+func (o *cliOutput) print(v any, header []string, rows [][]string) error {
+	if o.json {
+		encoder := json.NewEncoder(o.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	if header != nil {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (o *cliOutput) emails(header string, emails []string) error {
+	if emails == nil {
+		emails = []string{}
+	}
+	rows := make([][]string, len(emails))
+	for i, email := range emails {
+		rows[i] = []string{email}
+	}
+	return o.print(map[string][]string{header: emails}, []string{strings.ToUpper(header)}, rows)
+}
+
+func (o *cliOutput) ok(message string) error {
+	return o.print(map[string]string{"status": "ok", "message": message}, nil, [][]string{{message}})
+}
+
+// runs the command named by args[0], or serves the API if there is none
+func runCommand(args []string, stdout io.Writer) error {
+	global := flag.NewFlagSet("program", flag.ContinueOnError)
+	global.Usage = func() { fmt.Fprint(global.Output(), usage) }
+	outputFormat := global.String("output", "table", "table or json")
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+	if *outputFormat != "table" && *outputFormat != "json" {
+		return fmt.Errorf("output must be table or json")
+	}
+	out := &cliOutput{w: stdout, json: *outputFormat == "json"}
+
+	command, args := "serve", global.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	store, err := NewPostgresStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// the admin commands share the business logic of the JSON API, and
+	// their changes are pushed to the webhooks too. a command does not wait
+	// for retries: a delivery that fails is logged as abandoned once the
+	// command is done. only a server streams events, so changes made here do
+	// not reach /api/v1/events
+	resilient := NewResilientStore(store)
+	service := NewSchoolService(resilient)
+	if command != "serve" && command != "migrate" && command != "loadtest" {
+		webhooks := NewWebhookDispatcher(resilient)
+		store.SetEventPublisher(webhooks)
+		defer webhooks.Close()
+	}
+	ctx := WithAuditInfo(context.Background(), cliActor(), "", "")
+
+	switch command {
+	case "serve":
+		return runServeCommand(store, args)
+	case "migrate":
+		if err := store.Init(); err != nil {
+			return err
+		}
+		return out.ok("database is up to date")
+	case "teacher":
+		return runTeacherCommand(ctx, service, out, args)
+	case "student":
+		return runStudentCommand(ctx, service, out, args)
+	case "register":
+		return runRegisterCommand(ctx, service, out, args)
+	case "deregister":
+		return runDeregisterCommand(ctx, service, out, args)
+	case "common":
+		return runCommonCommand(ctx, service, out, args)
+	case "notify":
+		return runNotifyCommand(ctx, service, out, args)
+	case "import":
+		return runImportCommand(ctx, service, out, args)
+	case "export":
+		return runExportCommand(ctx, service, out, args)
+	case "audit":
+		return runAuditCommand(ctx, service, out, args)
+	case "loadtest":
+		return runLoadTestCommand(store, out, args)
+	default:
+		global.Usage()
+		return fmt.Errorf("unknown command %s", command)
+	}
+}
+
+// the actor recorded in the audit log for admin commands
+func cliActor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return "cli:" + user
+	}
+	return "cli"
+}
+
+// serve [-addr ADDR] [-grpc-addr ADDR] [-cache-ttl D] [-cache-size N]
+// [-cache-stats-interval D] [-allow-private-webhooks]
+// the JSON API address defaults to :$PORT, or :3000 if PORT is not set, and
+// the gRPC API address to :$GRPC_PORT, or :50051 if GRPC_PORT is not set
+func runServeCommand(store *PostgresStore, args []string) error {
+	port, exists := os.LookupEnv("PORT")
+	if !exists {
+		port = "3000"
+	}
+	grpcPort, exists := os.LookupEnv("GRPC_PORT")
+	if !exists {
+		grpcPort = "50051"
+	}
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", ":"+port, "address the JSON API listens on")
+	grpcAddr := flags.String("grpc-addr", ":"+grpcPort, "address the gRPC API listens on")
+	cacheTTL, cacheSize := cacheFlags(flags)
+	cacheStatsInterval := flags.Duration("cache-stats-interval", time.Minute, "how often the cache hits and misses are logged, 0 never logs them")
+	allowPrivateWebhooks := flags.Bool("allow-private-webhooks", false, "let webhooks reach loopback and private addresses, for local development")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := store.Init(); err != nil {
+		return err
+	}
+
+	// events of changes made through either API are streamed by the JSON API
+	// and pushed to the webhooks. deliveries are logged through the same
+	// retries and circuit breaker as the APIs
+	resilient := NewResilientStore(store)
+	events := NewEventBroker()
+	webhooks := NewWebhookDispatcher(resilient)
+	webhooks.AllowPrivateAddresses = *allowPrivateWebhooks
+	store.SetEventPublisher(multiPublisher{events, webhooks})
+
+	storage := withCache(resilient, *cacheTTL, *cacheSize)
+	grpcServer := NewGRPCServer(*grpcAddr, storage)
+	server := NewAPIServer(*addr, storage)
+	server.events = events
+	server.service.AllowPrivateWebhooks = *allowPrivateWebhooks
+
+	// both APIs stop on an interrupt or SIGTERM, or when either one fails
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error { return grpcServer.Run(ctx) })
+	group.Go(func() error { return server.Run(ctx) })
+	if cache, ok := storage.(*CachingStore); ok && *cacheStatsInterval > 0 {
+		group.Go(func() error {
+			cache.LogStats(ctx, *cacheStatsInterval)
+			return nil
+		})
+	}
+	err := group.Wait()
+
+	// nothing publishes events once both APIs have stopped, so the queued
+	// ones can be delivered before the store is closed
+	log.Println("shutting down, waiting for webhook deliveries in flight")
+	webhooks.Close()
+	return err
+}
+
+// the flags of the commands that can cache lookups, the cache is off unless
+// a TTL is given
+func cacheFlags(flags *flag.FlagSet) (*time.Duration, *int) {
+	ttl := flags.Duration("cache-ttl", 0, "how long suspensions and students of teachers are cached, 0 turns the cache off")
+	size := flags.Int("cache-size", 10000, "maximum number of cached lookups")
+	return ttl, size
+}
+
+func withCache(store Storage, ttl time.Duration, size int) Storage {
+	if ttl <= 0 {
+		return store
+	}
+	return NewCachingStore(store, ttl, size)
+}
+
+// teacher add [-name NAME] EMAIL
+// teacher list [-limit N] [-email-prefix PREFIX]
+// teacher delete EMAIL
+// teacher restore EMAIL
+// teacher rename EMAIL NEW_EMAIL
+func runTeacherCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: teacher add|list|delete|restore|rename")
+	}
+
+	switch args[0] {
+	case "add":
+		flags := flag.NewFlagSet("teacher add", flag.ContinueOnError)
+		name := flags.String("name", "", "name of the teacher")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: teacher add [-name NAME] EMAIL")
+		}
+
+		teacher, err := service.AddTeacher(ctx, flags.Arg(0), *name)
+		if err != nil {
+			return err
+		}
+		return out.print(teacher, []string{"ID", "EMAIL", "NAME"},
+			[][]string{{fmt.Sprint(teacher.ID), teacher.Email, teacher.Name}})
+
+	case "list":
+		flags := flag.NewFlagSet("teacher list", flag.ContinueOnError)
+		limit := flags.Int("limit", 0, "maximum number of teachers, 0 lists every teacher")
+		emailPrefix := flags.String("email-prefix", "", "only list teachers whose email starts with this")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		teachers, cursor, err := service.ListTeachers(ctx, ListOptions{Limit: *limit, EmailPrefix: *emailPrefix})
+		if err != nil {
+			return err
+		}
+
+		rows := make([][]string, len(teachers))
+		for i, teacher := range teachers {
+			rows[i] = []string{fmt.Sprint(teacher.ID), teacher.Email, teacher.Name, teacher.CreatedAt.Format("2006-01-02 15:04:05")}
+		}
+		return out.print(TeachersResponse{Teachers: teachers, NextCursor: cursor},
+			[]string{"ID", "EMAIL", "NAME", "CREATED AT"}, rows)
+
+	case "delete", "restore":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: teacher %s EMAIL", args[0])
+		}
+
+		if args[0] == "delete" {
+			if err := service.DeleteTeacher(ctx, args[1]); err != nil {
+				return err
+			}
+			return out.ok(fmt.Sprintf("%s deleted", args[1]))
+		}
+		if err := service.RestoreTeacher(ctx, args[1]); err != nil {
+			return err
+		}
+		return out.ok(fmt.Sprintf("%s restored", args[1]))
+
+	case "rename":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: teacher rename EMAIL NEW_EMAIL")
+		}
+
+		teacher, err := service.RenameTeacher(ctx, args[1], args[2])
+		if err != nil {
+			return err
+		}
+		return out.print(teacher, []string{"ID", "EMAIL", "NAME"},
+			[][]string{{fmt.Sprint(teacher.ID), teacher.Email, teacher.Name}})
+
+	default:
+		return fmt.Errorf("unknown teacher command %s", args[0])
+	}
+}
+
+// student suspend EMAIL
+// student unsuspend EMAIL
+// student delete EMAIL
+// student restore EMAIL
+// student rename EMAIL NEW_EMAIL
+// student export EMAIL
+// student erase EMAIL
+func runStudentCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	if len(args) == 3 && args[0] == "rename" {
+		student, err := service.RenameStudent(ctx, args[1], args[2])
+		if err != nil {
+			return err
+		}
+		return out.print(student, []string{"ID", "EMAIL", "NAME"},
+			[][]string{{fmt.Sprint(student.ID), student.Email, student.Name}})
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: student suspend|unsuspend|delete|restore|export|erase EMAIL, or student rename EMAIL NEW_EMAIL")
+	}
+
+	var err error
+	var done string
+	switch args[0] {
+	case "suspend":
+		err = service.SuspendStudent(ctx, args[1])
+		done = "suspended"
+	case "unsuspend":
+		err = service.UnsuspendStudent(ctx, args[1])
+		done = "unsuspended"
+	case "delete":
+		err = service.DeleteStudent(ctx, args[1])
+		done = "deleted"
+	case "restore":
+		err = service.RestoreStudent(ctx, args[1])
+		done = "restored"
+	case "export":
+		export, err := service.ExportStudentData(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		// the bundle does not fit in a table, so it is always JSON
+		return (&cliOutput{w: out.w, json: true}).print(export, nil, nil)
+	case "erase":
+		erased, err := service.EraseStudent(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return out.print(EraseStudentResponse{ErasedEmail: erased}, []string{"ERASED EMAIL"}, [][]string{{erased}})
+	default:
+		return fmt.Errorf("usage: student suspend|unsuspend|delete|restore|export|erase EMAIL, or student rename EMAIL NEW_EMAIL")
+	}
+	if err != nil {
+		return err
+	}
+
+	return out.ok(fmt.Sprintf("%s %s", args[1], done))
+}
+
+// register -teacher EMAIL STUDENT...
+func runRegisterCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	flags := flag.NewFlagSet("register", flag.ContinueOnError)
+	teacher := flags.String("teacher", "", "email of the teacher")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *teacher == "" || flags.NArg() == 0 {
+		return fmt.Errorf("usage: register -teacher EMAIL STUDENT...")
+	}
+
+	if err := service.RegisterStudents(ctx, *teacher, flags.Args()); err != nil {
+		return err
+	}
+
+	return out.ok(fmt.Sprintf("registered %d students to %s", flags.NArg(), *teacher))
+}
+
+// deregister -teacher EMAIL STUDENT...
+func runDeregisterCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	flags := flag.NewFlagSet("deregister", flag.ContinueOnError)
+	teacher := flags.String("teacher", "", "email of the teacher")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *teacher == "" || flags.NArg() == 0 {
+		return fmt.Errorf("usage: deregister -teacher EMAIL STUDENT...")
+	}
+
+	if err := service.DeregisterStudents(ctx, *teacher, flags.Args()); err != nil {
+		return err
+	}
+
+	return out.ok(fmt.Sprintf("deregistered %d students from %s", flags.NArg(), *teacher))
+}
+
+// common TEACHER...
+func runCommonCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: common TEACHER...")
+	}
+
+	students, err := service.CommonStudents(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	return out.emails("students", students)
+}
+
+// notify [-dry-run] -teacher EMAIL MESSAGE
+// sends the notification, or with -dry-run only lists who would get it
+func runNotifyCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	flags := flag.NewFlagSet("notify", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list the recipients")
+	teacher := flags.String("teacher", "", "email of the teacher sending the notification")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *teacher == "" || flags.NArg() == 0 {
+		return fmt.Errorf("usage: notify [-dry-run] -teacher EMAIL MESSAGE")
+	}
+
+	notification := strings.Join(flags.Args(), " ")
+	var recipients []string
+	var err error
+	if *dryRun {
+		recipients, err = service.NotificationRecipients(ctx, *teacher, notification)
+	} else {
+		recipients, err = service.SendNotification(ctx, *teacher, notification)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *dryRun && !out.json {
+		fmt.Fprintln(out.w, "dry run, the notification would go to:")
+	}
+	return out.emails("recipients", recipients)
+}
+
+// import [-dry-run] FILE
+// imports a teacher,student[,class] CSV roster, reading stdin if FILE is -
+func runImportCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would change without writing anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [-dry-run] FILE")
+	}
+
+	var input io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	report, importErr := service.ImportRoster(ctx, input, *dryRun)
+	if report != nil {
+		if err := printImportReport(out, report); err != nil {
+			return err
+		}
+	}
+	return importErr
+}
+
+// lists every change of an import report, one per row
+func printImportReport(out *cliOutput, report *ImportReport) error {
+	if out.json {
+		return out.print(report, nil, nil)
+	}
+
+	var rows [][]string
+	for _, rowErr := range report.Errors {
+		rows = append(rows, []string{"error", fmt.Sprintf("line %d: %s", rowErr.Line, rowErr.Error)})
+	}
+	for _, email := range report.NewTeachers {
+		rows = append(rows, []string{"new teacher", email})
+	}
+	for _, email := range report.NewStudents {
+		rows = append(rows, []string{"new student", email})
+	}
+	for _, name := range report.NewClasses {
+		rows = append(rows, []string{"new class", name})
+	}
+	for _, pair := range report.NewRegistrations {
+		rows = append(rows, []string{"new registration", pair.TeacherEmail + " " + pair.StudentEmail})
+	}
+	for _, pair := range report.NewClassMemberships {
+		rows = append(rows, []string{"new class member", pair.ClassName + " " + pair.StudentEmail})
+	}
+	for _, email := range report.Deleted {
+		rows = append(rows, []string{"skipped deleted", email})
+	}
+	if err := out.print(report, []string{"CHANGE", "DETAILS"}, rows); err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("\n%d rows imported", report.Rows)
+	if report.DryRun {
+		summary = fmt.Sprintf("\ndry run, %d rows checked and nothing written", report.Rows)
+	}
+	_, err := fmt.Fprintln(out.w, summary)
+	return err
+}
+
+// export [-format csv|jsonl] [-o FILE]
+// writes the whole roster to FILE, or to stdout if no file is given
+func runExportCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", ExportFormatCSV, "csv or jsonl")
+	output := flags.String("o", "", "file to write the roster to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !IsValidExportFormat(*format) {
+		return fmt.Errorf("format must be csv or jsonl")
+	}
+
+	if *output == "" {
+		return service.ExportRoster(ctx, out.w, *format)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := service.ExportRoster(ctx, file, *format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// audit [-actor ACTOR] [-target TARGET] [-action ACTION] [-limit N]
+func runAuditCommand(ctx context.Context, service *SchoolService, out *cliOutput, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	actor := flags.String("actor", "", "only list entries of this actor")
+	target := flags.String("target", "", "only list entries about this teacher or student")
+	action := flags.String("action", "", "only list entries of this action")
+	limit := flags.Int("limit", 50, "maximum number of entries, 0 lists every entry")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	entries, cursor, err := service.AuditLog(ctx, AuditFilter{Actor: *actor, Target: *target, Action: *action, Limit: *limit})
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, len(entries))
+	for i, entry := range entries {
+		rows[i] = []string{entry.CreatedAt.Format("2006-01-02 15:04:05"), entry.Actor, entry.Action, entry.Target, string(entry.Payload)}
+	}
+	return out.print(AuditResponse{Entries: entries, NextCursor: cursor},
+		[]string{"TIME", "ACTOR", "ACTION", "TARGET", "DETAILS"}, rows)
+}
+
+// loadtest [-concurrency N] [-duration D] [-requests N] [-mix CALL=WEIGHT,...]
+// [-teachers N] [-students N] [-cache-ttl D] [-cache-size N]
+// sends API calls through the router of the JSON API, writing loadtest_*
+// teachers and students to the database
+func runLoadTestCommand(store *PostgresStore, out *cliOutput, args []string) error {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	concurrency := flags.Int("concurrency", 10, "number of requests sent at once")
+	duration := flags.Duration("duration", 10*time.Second, "how long to send requests for")
+	requests := flags.Int("requests", 0, "stop after this many requests, 0 sends requests for the whole duration")
+	mix := flags.String("mix", DefaultLoadMix, "relative weights of the register, common, suspend, unsuspend and notify calls")
+	teachers := flags.Int("teachers", 20, "number of teachers the calls pick from")
+	students := flags.Int("students", 500, "number of students the calls pick from")
+	cacheTTL, cacheSize := cacheFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	weights, err := ParseLoadMix(*mix)
+	if err != nil {
+		return err
+	}
+
+	// the calls write to a schema of their own, which is dropped at the end,
+	// also when the test is interrupted
+	loadStore, drop, err := NewLoadTestStore(store)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := drop(); err != nil {
+			log.Printf("dropping the load test schema: %v", err)
+		}
+	}()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	storage := withCache(NewResilientStore(loadStore), *cacheTTL, *cacheSize)
+	server := NewAPIServer("", storage)
+	report, err := RunLoadTest(ctx, server.Router(), LoadTestConfig{
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Requests:    *requests,
+		Mix:         weights,
+		Teachers:    *teachers,
+		Students:    *students,
+	}, loadStore.dbPool)
+	if err != nil {
+		return err
+	}
+	if cache, ok := storage.(*CachingStore); ok {
+		stats := cache.Stats()
+		report.Cache = &stats
+	}
+
+	if out.json {
+		return out.print(report, nil, nil)
+	}
+	rows := make([][]string, len(report.Calls))
+	for i, call := range report.Calls {
+		rows[i] = []string{call.Call, fmt.Sprint(call.Requests), fmt.Sprint(call.Errors),
+			fmt.Sprintf("%.2f", call.P50), fmt.Sprintf("%.2f", call.P90), fmt.Sprintf("%.2f", call.P99), fmt.Sprintf("%.2f", call.Max)}
+	}
+	if err := out.print(report, []string{"CALL", "REQUESTS", "ERRORS", "P50 MS", "P90 MS", "P99 MS", "MAX MS"}, rows); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out.w, "\n%d requests in %.1fs, %.1f requests/s, %d errors\n",
+		report.Requests, report.Duration, report.RequestsPerSecond, report.Errors)
+	pool := report.Pool
+	fmt.Fprintf(out.w, "pool: %d max conns, %d acquires, %d waited for a connection, %.1fms waiting in total\n",
+		pool.MaxConns, pool.Acquires, pool.WaitedAcquires, pool.AcquireWait)
+	if cache := report.Cache; cache != nil {
+		fmt.Fprintf(out.w, "cache: %d hits, %d misses, %d evictions, %d entries\n",
+			cache.Hits, cache.Misses, cache.Evictions, cache.Entries)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// the domain events published when the roster changes
+const (
+	EventStudentRegistered   = "StudentRegistered"
+	EventStudentDeregistered = "StudentDeregistered"
+	EventStudentSuspended    = "StudentSuspended"
+	EventStudentUnsuspended  = "StudentUnsuspended"
+	EventNotificationCreated = "NotificationCreated"
+)
+
+func IsValidEventType(eventType string) bool {
+	switch eventType {
+	case EventStudentRegistered, EventStudentDeregistered, EventStudentSuspended, EventStudentUnsuspended, EventNotificationCreated:
+		return true
+	}
+	return false
+}
+
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Data      any       `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewEvent(eventType string, data any) *Event {
+	return &Event{
+		ID:        newRequestID(),
+		Type:      eventType,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// the data of the registration events
+type RegistrationEvent struct {
+	TeacherEmail string `json:"teacher"`
+	StudentEmail string `json:"student"`
+}
+
+// the data of the suspension events
+type SuspensionEvent struct {
+	StudentEmail string `json:"student"`
+}
+
+// receives the events of the storage layer once the change they describe
+// has been written. Publish must not block
+type EventPublisher interface {
+	Publish(*Event)
+}
+
+// drops every event, used until a publisher is set
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(*Event) {}
+
+// how many events a subscriber can fall behind before it is dropped
+const eventBufferSize = 64
+
+// an in-process EventPublisher that fans events out to subscribers
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan *Event]struct{}
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: map[chan *Event]struct{}{},
+	}
+}
+
+// sends the event to every subscriber. a subscriber that is too far behind
+// is dropped by closing its channel, so it knows it missed events
+func (b *EventBroker) Publish(event *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// the events published from now on. the returned function unsubscribes
+func (b *EventBroker) Subscribe() (<-chan *Event, func()) {
+	events := make(chan *Event, eventBufferSize)
+
+	b.mu.Lock()
+	b.subscribers[events] = struct{}{}
+	b.mu.Unlock()
+
+	return events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[events]; ok {
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// ends every subscription by closing its channel, e.g. so event streams
+// finish when the server shuts down
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subscribers {
+		delete(b.subscribers, events)
+		close(events)
+	}
+}
+
+// publishes every event to each of the publishers in turn
+type multiPublisher []EventPublisher
+
+func (m multiPublisher) Publish(event *Event) {
+	for _, publisher := range m {
+		publisher.Publish(event)
+	}
+}
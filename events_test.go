@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBrokerDropsSlowSubscriber(t *testing.T) {
+	broker := NewEventBroker()
+	events, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i <= eventBufferSize; i++ {
+		broker.Publish(NewEvent(EventStudentSuspended, SuspensionEvent{StudentEmail: "studentjon@gmail.com"}))
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	if received != eventBufferSize {
+		t.Errorf("got %d events before the channel was closed, want %d", received, eventBufferSize)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	server := NewAPIServer(":0", newFakeStore())
+	server.events = NewEventBroker()
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/events?types=" + EventStudentSuspended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// the headers are only sent once the stream has subscribed
+	server.events.Publish(NewEvent(EventStudentRegistered, RegistrationEvent{TeacherEmail: "teacherken@gmail.com", StudentEmail: "studentjon@gmail.com"}))
+	server.events.Publish(NewEvent(EventStudentSuspended, SuspensionEvent{StudentEmail: "studentjon@gmail.com"}))
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var received []string
+	for len(received) < 3 {
+		select {
+		case line := <-lines:
+			if line != "" {
+				received = append(received, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out, received %v", received)
+		}
+	}
+
+	if !strings.HasPrefix(received[0], "id: ") || received[1] != "event: "+EventStudentSuspended ||
+		!strings.Contains(received[2], `"student":"studentjon@gmail.com"`) {
+		t.Errorf("unexpected event %v", received)
+	}
+}
+
+func TestStreamEventsInvalidType(t *testing.T) {
+	server := NewAPIServer(":0", newFakeStore())
+	server.events = NewEventBroker()
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/events?types=StudentExpelled", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestShutdownEndsEventStreams(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := NewAPIServer(addr, newFakeStore())
+	server.events = NewEventBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Run(ctx)
+	}()
+
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		resp, err = http.Get("http://" + addr + "/api/v1/events")
+		if err == nil {
+			break
+		}
+		if attempt == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer resp.Body.Close()
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	case <-time.After(shutdownTimeout / 2):
+		t.Fatalf("an open event stream held the shutdown up")
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Errorf("the event stream did not end cleanly: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/Luquenje/Golang-API-Assesment/schoolpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer serves the gRPC API. like APIServer it only translates requests
+// and responses, the business rules live in SchoolService
+type GRPCServer struct {
+	schoolpb.UnimplementedSchoolServiceServer
+	listenAddr string
+	service    *SchoolService
+}
+
+func NewGRPCServer(listenAddr string, store Storage) *GRPCServer {
+	return &GRPCServer{
+		listenAddr: listenAddr,
+		service:    NewSchoolService(store),
+	}
+}
+
+// serves the gRPC API until ctx is done, then stops taking calls and waits
+// up to shutdownTimeout for the calls in flight
+func (s *GRPCServer) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("gRPC API running on port: ", s.listenAddr)
+
+	server := s.newServer()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+		timer := time.AfterFunc(shutdownTimeout, server.Stop)
+		defer timer.Stop()
+		server.GracefulStop()
+	}()
+
+	return server.Serve(listener)
+}
+
+// serves the gRPC API on an existing listener
+func (s *GRPCServer) Serve(listener net.Listener) error {
+	return s.newServer().Serve(listener)
+}
+
+func (s *GRPCServer) newServer() *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(withRPCInfo))
+	schoolpb.RegisterSchoolServiceServer(server, s)
+	return server
+}
+
+// takes the actor of a call from the address of the client and the claimed
+// actor and request ID from its x-actor and x-request-id metadata, like
+// withRequestInfo does for the JSON API. calls with
+// "x-read-your-writes: true" metadata read from the primary database
+func withRPCInfo(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	actor := remoteActor("grpc", "")
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		actor = remoteActor("grpc", p.Addr.String())
+	}
+
+	var claimedActor, requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-actor"); len(values) > 0 {
+			claimedActor = values[0]
+		}
+		if values := md.Get("x-request-id"); len(values) > 0 {
+			requestID = values[0]
+		}
+	}
+	ctx = WithAuditInfo(ctx, actor, claimedActor, requestID)
+	if values := metadata.ValueFromIncomingContext(ctx, "x-read-your-writes"); len(values) > 0 && values[0] == "true" {
+		ctx = WithReadYourWrites(ctx)
+	}
+	return handler(ctx, req)
+}
+
+// errors are reported as InvalidArgument, the gRPC equivalent of the
+// 400 Bad Request returned by the JSON API, or as Unavailable while the
+// database is unavailable
+func grpcError(err error) error {
+	if errors.Is(err, ErrDatabaseUnavailable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, ErrAuditNotRecorded) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+func (s *GRPCServer) Register(ctx context.Context, req *schoolpb.RegisterRequest) (*schoolpb.RegisterResponse, error) {
+	if req.Teacher == "" {
+		return nil, status.Error(codes.InvalidArgument, "teacher email is required")
+	}
+
+	if err := s.service.RegisterStudents(ctx, req.Teacher, req.Students); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &schoolpb.RegisterResponse{}, nil
+}
+
+func (s *GRPCServer) CommonStudents(ctx context.Context, req *schoolpb.CommonStudentsRequest) (*schoolpb.CommonStudentsResponse, error) {
+	students, err := s.service.CommonStudents(ctx, req.Teachers)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &schoolpb.CommonStudentsResponse{Students: students}, nil
+}
+
+func (s *GRPCServer) Suspend(ctx context.Context, req *schoolpb.SuspendRequest) (*schoolpb.SuspendResponse, error) {
+	if err := s.service.SuspendStudent(ctx, req.Student); err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &schoolpb.SuspendResponse{}, nil
+}
+
+func (s *GRPCServer) RetrieveForNotifications(ctx context.Context, req *schoolpb.RetrieveForNotificationsRequest) (*schoolpb.RetrieveForNotificationsResponse, error) {
+	recipients, err := s.service.SendNotification(ctx, req.Teacher, req.Notification)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &schoolpb.RetrieveForNotificationsResponse{Recipients: recipients}, nil
+}
+
+func (s *GRPCServer) ListTeachers(ctx context.Context, req *schoolpb.ListTeachersRequest) (*schoolpb.ListTeachersResponse, error) {
+	opts, err := listOptionsFromProto(req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	teachers, cursor, err := s.service.ListTeachers(ctx, opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &schoolpb.ListTeachersResponse{Teachers: teachersToProto(teachers), NextCursor: cursor}, nil
+}
+
+func (s *GRPCServer) ListStudents(ctx context.Context, req *schoolpb.ListStudentsRequest) (*schoolpb.ListStudentsResponse, error) {
+	opts, err := listOptionsFromProto(req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	students, cursor, err := s.service.ListStudents(ctx, opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &schoolpb.ListStudentsResponse{Students: studentsToProto(students), NextCursor: cursor}, nil
+}
+
+func (s *GRPCServer) ListStudentsOfTeacher(ctx context.Context, req *schoolpb.ListStudentsOfTeacherRequest) (*schoolpb.ListStudentsResponse, error) {
+	opts, err := listOptionsFromProto(req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	students, cursor, err := s.service.StudentsOfTeacher(ctx, req.Teacher, opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &schoolpb.ListStudentsResponse{Students: studentsToProto(students), NextCursor: cursor}, nil
+}
+
+func (s *GRPCServer) ListTeachersOfStudent(ctx context.Context, req *schoolpb.ListTeachersOfStudentRequest) (*schoolpb.ListTeachersResponse, error) {
+	opts, err := listOptionsFromProto(req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	teachers, cursor, err := s.service.TeachersOfStudent(ctx, req.Student, opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &schoolpb.ListTeachersResponse{Teachers: teachersToProto(teachers), NextCursor: cursor}, nil
+}
+
+// conversions between the protobuf messages and the types of this package
+
+// applies the same defaults and limits as parseListOptions
+func listOptionsFromProto(options *schoolpb.ListOptions) (ListOptions, error) {
+	opts := ListOptions{Limit: defaultPageSize}
+	if options == nil {
+		return opts, nil
+	}
+
+	if options.Limit != 0 {
+		if options.Limit < 1 || options.Limit > maxPageSize {
+			return opts, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxPageSize)
+		}
+		opts.Limit = int(options.Limit)
+	}
+	opts.Cursor = options.Cursor
+	opts.Sort = options.Sort
+	opts.Descending = options.Descending
+	opts.EmailPrefix = options.EmailPrefix
+	if options.CreatedAfter != nil {
+		t := options.CreatedAfter.AsTime()
+		opts.CreatedAfter = &t
+	}
+	if options.CreatedBefore != nil {
+		t := options.CreatedBefore.AsTime()
+		opts.CreatedBefore = &t
+	}
+	opts.Suspended = options.Suspended
+
+	return opts, nil
+}
+
+func timestampToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func teachersToProto(teachers []*Teacher) []*schoolpb.Teacher {
+	messages := make([]*schoolpb.Teacher, len(teachers))
+	for i, teacher := range teachers {
+		messages[i] = &schoolpb.Teacher{
+			Id:                teacher.ID,
+			Email:             teacher.Email,
+			Name:              teacher.Name,
+			ContactPreference: teacher.ContactPreference,
+			CreatedAt:         timestampToProto(teacher.CreatedAt),
+			UpdatedAt:         timestampToProto(teacher.UpdatedAt),
+		}
+	}
+	return messages
+}
+
+func studentsToProto(students []*Student) []*schoolpb.Student {
+	messages := make([]*schoolpb.Student, len(students))
+	for i, student := range students {
+		messages[i] = &schoolpb.Student{
+			Id:                student.ID,
+			Email:             student.Email,
+			Name:              student.Name,
+			ContactPreference: student.ContactPreference,
+			IsSuspended:       student.IsSuspended,
+			CreatedAt:         timestampToProto(student.CreatedAt),
+			UpdatedAt:         timestampToProto(student.UpdatedAt),
+		}
+		if student.GradeLevel != nil {
+			gradeLevel := int32(*student.GradeLevel)
+			messages[i].GradeLevel = &gradeLevel
+		}
+	}
+	return messages
+}
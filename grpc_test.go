@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/Luquenje/Golang-API-Assesment/schoolpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// starts the gRPC API on an in-memory listener and returns a client for it
+func newTestGRPCClient(t *testing.T, store Storage) schoolpb.SchoolServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	go NewGRPCServer("bufnet", store).Serve(listener)
+	t.Cleanup(func() { listener.Close() })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return schoolpb.NewSchoolServiceClient(conn)
+}
+
+func TestGRPCRegisterAndNotify(t *testing.T) {
+	client := newTestGRPCClient(t, newFakeStore())
+	ctx := context.Background()
+
+	_, err := client.Register(ctx, &schoolpb.RegisterRequest{
+		Teacher:  "teacherken@gmail.com",
+		Students: []string{"studentjon@gmail.com", "studenthon@gmail.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Register(ctx, &schoolpb.RegisterRequest{
+		Teacher:  "teacherjoe@gmail.com",
+		Students: []string{"studentjon@gmail.com", "studentagnes@gmail.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	common, err := client.CommonStudents(ctx, &schoolpb.CommonStudentsRequest{
+		Teachers: []string{"teacherken@gmail.com", "teacherjoe@gmail.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(common.Students, []string{"studentjon@gmail.com"}) {
+		t.Errorf("unexpected common students: %v", common.Students)
+	}
+
+	if _, err := client.Suspend(ctx, &schoolpb.SuspendRequest{Student: "studentjon@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	notified, err := client.RetrieveForNotifications(ctx, &schoolpb.RetrieveForNotificationsRequest{
+		Teacher:      "teacherken@gmail.com",
+		Notification: "Hello @studentagnes@gmail.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"studenthon@gmail.com", "studentagnes@gmail.com"}
+	if !reflect.DeepEqual(notified.Recipients, expected) {
+		t.Errorf("unexpected recipients: got %v, want %v", notified.Recipients, expected)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	client := newTestGRPCClient(t, newFakeStore())
+	ctx := context.Background()
+
+	_, err := client.Suspend(ctx, &schoolpb.SuspendRequest{Student: "nobody@gmail.com"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("suspending a missing student returned %v, want InvalidArgument", err)
+	}
+
+	_, err = client.ListTeachers(ctx, &schoolpb.ListTeachersRequest{Options: &schoolpb.ListOptions{Limit: 1000}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("listing with a limit that is too large returned %v, want InvalidArgument", err)
+	}
+}
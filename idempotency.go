@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// the longest Idempotency-Key header accepted
+const maxIdempotencyKeyLength = 255
+
+// captures the response of a handler while writing it to the client
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// makes POST requests sent with an Idempotency-Key header safe to retry.
+// the first successful response for a key is stored and replayed to repeats
+// of the same request, reusing the key for a different request is rejected
+// with 422. failed requests release the key so they can be retried
+func (s *APIServer) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			WriteJSON(w, http.StatusBadRequest, ApiError{Error: fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)})
+			return
+		}
+
+		// no route accepts a larger body than a roster import
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRosterSize))
+		if err != nil {
+			WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// a request retried on the other of /api and /api/v1 is the same
+		// request
+		path := r.URL.Path
+		if !strings.HasPrefix(path, "/api/v1/") {
+			path = v1Path(path)
+		}
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s?%s\n", r.Method, path, r.URL.RawQuery)
+		hash.Write(body)
+
+		record, err := s.service.BeginIdempotentRequest(r.Context(), key, hex.EncodeToString(hash.Sum(nil)))
+		switch {
+		case errors.Is(err, ErrIdempotencyKeyReused):
+			WriteJSON(w, http.StatusUnprocessableEntity, ApiError{Error: err.Error()})
+			return
+		case errors.Is(err, ErrIdempotencyKeyInProgress):
+			WriteJSON(w, http.StatusConflict, ApiError{Error: err.Error()})
+			return
+		case err != nil:
+			writeError(w, err)
+			return
+		case record != nil:
+			if record.ContentType != "" {
+				w.Header().Set("Content-Type", record.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.StatusCode)
+			w.Write(record.Body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.statusCode < 200 || rec.statusCode >= 300 {
+			if err := s.service.ReleaseIdempotencyKey(r.Context(), key); err != nil {
+				log.Printf("releasing idempotency key %s: %v", key, err)
+			}
+			return
+		}
+
+		record = NewIdempotencyRecord(key, "")
+		record.StatusCode = rec.statusCode
+		record.ContentType = rec.Header().Get("Content-Type")
+		record.Body = rec.body.Bytes()
+		if err := s.service.CompleteIdempotentRequest(r.Context(), record); err != nil {
+			log.Printf("storing the response for idempotency key %s: %v", key, err)
+		}
+	})
+}
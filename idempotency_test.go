@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postWithIdempotencyKey(router http.Handler, path string, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(idempotencyKeyHeader, key)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIdempotentNotification(t *testing.T) {
+	store := newFakeStore()
+	router := NewAPIServer(":0", store).Router()
+
+	rr := postWithIdempotencyKey(router, "/api/v1/register", "register-1",
+		`{"teacher": "teacherken@gmail.com", "students": ["studentjon@gmail.com"]}`)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("register: got status %d, want %d", rr.Code, http.StatusNoContent)
+	}
+
+	body := `{"teacher": "teacherken@gmail.com", "notification": "Hello @studentagnes@gmail.com"}`
+	first := postWithIdempotencyKey(router, "/api/v1/retrievefornotifications", "notify-1", body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", first.Code, http.StatusOK)
+	}
+
+	// a student registered after the first request must not change the
+	// replayed response
+	store.teacherStudent["teacherken@gmail.com"] = append(store.teacherStudent["teacherken@gmail.com"], "studenthon@gmail.com")
+	store.students["studenthon@gmail.com"] = NewStudent("studenthon@gmail.com")
+
+	retry := postWithIdempotencyKey(router, "/api/v1/retrievefornotifications", "notify-1", body)
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry was not replayed: got %d %q, want %d %q", retry.Code, retry.Body.String(), first.Code, first.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replayed response is missing the Idempotent-Replayed header")
+	}
+
+	reused := postWithIdempotencyKey(router, "/api/v1/retrievefornotifications", "notify-1",
+		`{"teacher": "teacherken@gmail.com", "notification": "Goodbye"}`)
+	if reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused with a different body: got status %d, want %d", reused.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyKeyReleasedOnError(t *testing.T) {
+	store := newFakeStore()
+	router := NewAPIServer(":0", store).Router()
+
+	// suspending a student that does not exist fails, so the key can be
+	// used again once the student exists
+	rr := postWithIdempotencyKey(router, "/api/v1/suspend", "suspend-1", `{"student": "studentjon@gmail.com"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if _, ok := store.idempotency["suspend-1"]; ok {
+		t.Fatalf("key of a failed request was kept")
+	}
+
+	store.students["studentjon@gmail.com"] = NewStudent("studentjon@gmail.com")
+	rr = postWithIdempotencyKey(router, "/api/v1/suspend", "suspend-1", `{"student": "studentjon@gmail.com"}`)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusNoContent)
+	}
+}
+
+func TestIdempotencyKeyAcrossVersions(t *testing.T) {
+	router := NewAPIServer(":0", newFakeStore()).Router()
+	body := `{"teacher": "teacherken@gmail.com", "students": ["studentjon@gmail.com"]}`
+
+	first := postWithIdempotencyKey(router, "/api/register", "register-1", body)
+	if first.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", first.Code, http.StatusNoContent)
+	}
+	retry := postWithIdempotencyKey(router, "/api/v1/register", "register-1", body)
+	if retry.Code != http.StatusNoContent || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry on /api/v1: got status %d replayed %q, want the replayed response", retry.Code, retry.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotencyKeyLease(t *testing.T) {
+	store := newFakeStore()
+	router := NewAPIServer(":0", store).Router()
+	body := `{"teacher": "teacherken@gmail.com", "students": ["studentjon@gmail.com"]}`
+
+	// another request that died while holding the key
+	abandoned := NewIdempotencyRecord("register-1", "other")
+	store.idempotency[abandoned.Key] = abandoned
+	if rr := postWithIdempotencyKey(router, "/api/v1/register", "register-1", body); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("within the lease: got status %d, want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	abandoned.CreatedAt = abandoned.CreatedAt.Add(-idempotencyKeyLease)
+	if rr := postWithIdempotencyKey(router, "/api/v1/register", "register-1", body); rr.Code != http.StatusNoContent {
+		t.Errorf("after the lease: got status %d, want %d", rr.Code, http.StatusNoContent)
+	}
+}
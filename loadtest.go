@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// calls a load test sends, named as in the -mix flag of the loadtest command
+const (
+	LoadCallRegister  = "register"
+	LoadCallCommon    = "common"
+	LoadCallSuspend   = "suspend"
+	LoadCallUnsuspend = "unsuspend"
+	LoadCallNotify    = "notify"
+)
+
+var loadCalls = []string{LoadCallRegister, LoadCallCommon, LoadCallSuspend, LoadCallUnsuspend, LoadCallNotify}
+
+const DefaultLoadMix = "register=4,common=3,suspend=1,unsuspend=1,notify=2"
+
+// students registered to each teacher before a load test starts
+const loadSeedStudentsPerTeacher = 5
+
+type LoadTestConfig struct {
+	// number of requests sent at once
+	Concurrency int
+	// how long to send requests for
+	Duration time.Duration
+	// stops after this many requests, 0 sends requests for the whole duration
+	Requests int
+	// relative weight of each call, DefaultLoadMix if nil
+	Mix map[string]int
+	// number of teachers and students the calls pick from
+	Teachers int
+	Students int
+	Seed     int64
+}
+
+// parses a mix like register=4,common=3 into the weight of each call
+func ParseLoadMix(mix string) (map[string]int, error) {
+	weights := map[string]int{}
+	total := 0
+	for _, part := range strings.Split(mix, ",") {
+		call, weight, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, fmt.Errorf("mix entry %q is not CALL=WEIGHT", part)
+		}
+		if !isLoadCall(call) {
+			return nil, fmt.Errorf("unknown call %q, calls are %s", call, strings.Join(loadCalls, ", "))
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("weight of %s must be a number of at least 0", call)
+		}
+		weights[call] = n
+		total += n
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("mix has no call with a weight")
+	}
+	return weights, nil
+}
+
+func isLoadCall(call string) bool {
+	for _, c := range loadCalls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+type LoadCallStats struct {
+	Call     string  `json:"call"`
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	P50      float64 `json:"p50_ms"`
+	P90      float64 `json:"p90_ms"`
+	P99      float64 `json:"p99_ms"`
+	Max      float64 `json:"max_ms"`
+
+	latencies []time.Duration
+}
+
+// connection pool activity during a load test
+type LoadPoolStats struct {
+	MaxConns int32 `json:"max_conns"`
+	Acquires int64 `json:"acquires"`
+	// acquires that had to wait for a connection to be released
+	WaitedAcquires   int64   `json:"waited_acquires"`
+	CanceledAcquires int64   `json:"canceled_acquires"`
+	AcquireWait      float64 `json:"acquire_wait_ms"`
+}
+
+type LoadTestReport struct {
+	Duration          float64          `json:"duration_s"`
+	Requests          int              `json:"requests"`
+	Errors            int              `json:"errors"`
+	RequestsPerSecond float64          `json:"requests_per_second"`
+	Calls             []*LoadCallStats `json:"calls"`
+	Pool              *LoadPoolStats   `json:"pool,omitempty"`
+	Cache             *CacheStats      `json:"cache,omitempty"`
+}
+
+// sends a mix of API calls to handler until the duration or the number of
+// requests runs out, and reports the latency of each call. the calls go
+// through the whole router, so they are timed the way clients see them
+// apart from the network. pool, if not nil, is the pool of the store behind
+// handler and its wait statistics are reported
+func RunLoadTest(ctx context.Context, handler http.Handler, config LoadTestConfig, pool *pgxpool.Pool) (*LoadTestReport, error) {
+	if config.Concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	}
+	if config.Teachers < 2 || config.Students < loadSeedStudentsPerTeacher {
+		return nil, fmt.Errorf("load tests need at least 2 teachers and %d students", loadSeedStudentsPerTeacher)
+	}
+	if config.Duration <= 0 && config.Requests <= 0 {
+		return nil, fmt.Errorf("load tests need a duration or a number of requests")
+	}
+	if config.Mix == nil {
+		config.Mix, _ = ParseLoadMix(DefaultLoadMix)
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	// every teacher has students before the calls start, so common and
+	// notify have work to do, and every student exists, so suspend and
+	// unsuspend can find the one they pick
+	for i := 0; i < config.Teachers; i++ {
+		students := make([]string, loadSeedStudentsPerTeacher)
+		for j := range students {
+			students[j] = loadStudentEmail((i*loadSeedStudentsPerTeacher + j) % config.Students)
+		}
+		for j := i; j < config.Students; j += config.Teachers {
+			if student := loadStudentEmail(j); !StringExistsInArray(student, students) {
+				students = append(students, student)
+			}
+		}
+		if err := sendLoadRequest(handler, newLoadRegisterRequest(loadTeacherEmail(i), students)); err != nil {
+			return nil, fmt.Errorf("seeding the roster: %v", err)
+		}
+	}
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+
+	var before *pgxpool.Stat
+	if pool != nil {
+		before = pool.Stat()
+	}
+
+	var sent atomic.Int64
+	results := make([]map[string]*LoadCallStats, config.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for worker := 0; worker < config.Concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			generator := &loadRequestGenerator{
+				config: config,
+				rand:   rand.New(rand.NewSource(config.Seed + int64(worker))),
+			}
+			results[worker] = generator.run(ctx, handler, &sent)
+		}(worker)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &LoadTestReport{Duration: elapsed.Seconds()}
+	for _, call := range loadCalls {
+		stats := &LoadCallStats{Call: call}
+		for _, result := range results {
+			if s, ok := result[call]; ok {
+				stats.Requests += s.Requests
+				stats.Errors += s.Errors
+				stats.latencies = append(stats.latencies, s.latencies...)
+			}
+		}
+		if stats.Requests == 0 {
+			continue
+		}
+		stats.summarize()
+		report.Requests += stats.Requests
+		report.Errors += stats.Errors
+		report.Calls = append(report.Calls, stats)
+	}
+	report.RequestsPerSecond = float64(report.Requests) / elapsed.Seconds()
+
+	if pool != nil {
+		after := pool.Stat()
+		report.Pool = &LoadPoolStats{
+			MaxConns:         after.MaxConns(),
+			Acquires:         after.AcquireCount() - before.AcquireCount(),
+			WaitedAcquires:   after.EmptyAcquireCount() - before.EmptyAcquireCount(),
+			CanceledAcquires: after.CanceledAcquireCount() - before.CanceledAcquireCount(),
+			AcquireWait:      milliseconds(after.AcquireDuration() - before.AcquireDuration()),
+		}
+	}
+
+	return report, nil
+}
+
+// sets the percentiles from the recorded latencies
+func (s *LoadCallStats) summarize() {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	s.P50 = milliseconds(percentile(s.latencies, 50))
+	s.P90 = milliseconds(percentile(s.latencies, 90))
+	s.P99 = milliseconds(percentile(s.latencies, 99))
+	s.Max = milliseconds(s.latencies[len(s.latencies)-1])
+}
+
+// the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// picks the calls of one load test worker
+type loadRequestGenerator struct {
+	config LoadTestConfig
+	rand   *rand.Rand
+}
+
+func (g *loadRequestGenerator) run(ctx context.Context, handler http.Handler, sent *atomic.Int64) map[string]*LoadCallStats {
+	stats := map[string]*LoadCallStats{}
+	for ctx.Err() == nil {
+		if g.config.Requests > 0 && sent.Add(1) > int64(g.config.Requests) {
+			break
+		}
+
+		call := g.pickCall()
+		request := g.newRequest(call)
+
+		started := time.Now()
+		err := sendLoadRequest(handler, request)
+		latency := time.Since(started)
+
+		s, ok := stats[call]
+		if !ok {
+			s = &LoadCallStats{Call: call}
+			stats[call] = s
+		}
+		s.Requests++
+		if err != nil {
+			s.Errors++
+		}
+		s.latencies = append(s.latencies, latency)
+	}
+	return stats
+}
+
+func (g *loadRequestGenerator) pickCall() string {
+	total := 0
+	for _, weight := range g.config.Mix {
+		total += weight
+	}
+	n := g.rand.Intn(total)
+	for _, call := range loadCalls {
+		n -= g.config.Mix[call]
+		if n < 0 {
+			return call
+		}
+	}
+	return LoadCallRegister
+}
+
+func (g *loadRequestGenerator) newRequest(call string) *http.Request {
+	teacher := loadTeacherEmail(g.rand.Intn(g.config.Teachers))
+	student := loadStudentEmail(g.rand.Intn(g.config.Students))
+
+	switch call {
+	case LoadCallCommon:
+		query := url.Values{"teacher": {teacher, loadTeacherEmail(g.rand.Intn(g.config.Teachers))}}
+		request, _ := http.NewRequest(http.MethodGet, "/api/v1/commonstudents?"+query.Encode(), nil)
+		return request
+	case LoadCallSuspend:
+		return newLoadJSONRequest("/api/v1/suspend", map[string]string{"student": student})
+	case LoadCallUnsuspend:
+		return newLoadJSONRequest("/api/v1/unsuspend", map[string]string{"student": student})
+	case LoadCallNotify:
+		mentioned := loadStudentEmail(g.rand.Intn(g.config.Students))
+		return newLoadJSONRequest("/api/v1/retrievefornotifications", map[string]string{
+			"teacher":      teacher,
+			"notification": "Load test @" + mentioned,
+		})
+	default:
+		students := []string{student, loadStudentEmail(g.rand.Intn(g.config.Students))}
+		return newLoadRegisterRequest(teacher, students)
+	}
+}
+
+func loadTeacherEmail(i int) string {
+	return fmt.Sprintf("loadtest_teacher_%d@example.com", i)
+}
+
+func loadStudentEmail(i int) string {
+	return fmt.Sprintf("loadtest_student_%d@example.com", i)
+}
+
+func newLoadRegisterRequest(teacher string, students []string) *http.Request {
+	return newLoadJSONRequest("/api/v1/register", RegisterStudentsToTeacherRequest{TeacherEmail: teacher, StudentEmails: students})
+}
+
+func newLoadJSONRequest(path string, v any) *http.Request {
+	body, _ := json.Marshal(v)
+	request, _ := http.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(actorHeader, "loadtest")
+	return request
+}
+
+// sends a request through the handler, any response but 2xx is an error
+func sendLoadRequest(handler http.Handler, request *http.Request) error {
+	response := &loadResponse{header: http.Header{}}
+	handler.ServeHTTP(response, request)
+	if response.code == 0 {
+		response.code = http.StatusOK
+	}
+	if response.code < 200 || response.code > 299 {
+		return fmt.Errorf("%s %s: %d %s", request.Method, request.URL.Path, response.code, strings.TrimSpace(response.body.String()))
+	}
+	return nil
+}
+
+// the response to a load test call, kept in memory
+type loadResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *loadResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *loadResponse) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *loadResponse) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+// a store writing to a new schema of the database of store, so the
+// teachers, students and append-only audit entries of a load test do not
+// end up next to the real ones. drop closes it and drops the schema
+func NewLoadTestStore(store *PostgresStore) (loadStore *PostgresStore, drop func() error, err error) {
+	ctx := context.Background()
+	schema := fmt.Sprintf("loadtest_%d", time.Now().UnixNano())
+	if _, err := store.dbPool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		return nil, nil, err
+	}
+	dropSchema := func() error {
+		_, err := store.dbPool.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		return err
+	}
+
+	poolConfig := store.dbPool.Config().Copy()
+	poolConfig.ConnConfig.RuntimeParams["search_path"] = schema
+	loadStore, err = NewPostgresStoreWithConfig(poolConfig)
+	if err != nil {
+		dropSchema()
+		return nil, nil, err
+	}
+	if err := loadStore.Init(); err != nil {
+		loadStore.Close()
+		dropSchema()
+		return nil, nil, err
+	}
+
+	drop = func() error {
+		loadStore.Close()
+		return dropSchema()
+	}
+	return loadStore, drop, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseLoadMix(t *testing.T) {
+	mix, err := ParseLoadMix("register=4, common=1,notify=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mix) != 3 || mix[LoadCallRegister] != 4 || mix[LoadCallCommon] != 1 || mix[LoadCallNotify] != 0 {
+		t.Errorf("got %v", mix)
+	}
+
+	for _, invalid := range []string{"", "register", "register=x", "register=-1", "delete=1", "register=0"} {
+		if _, err := ParseLoadMix(invalid); err == nil {
+			t.Errorf("expected an error for mix %q", invalid)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	for _, test := range []struct {
+		p        int
+		expected time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	} {
+		if got := percentile(latencies, test.p); got != test.expected {
+			t.Errorf("p%d: got %v, want %v", test.p, got, test.expected)
+		}
+	}
+	if got := percentile(latencies[:1], 50); got != time.Millisecond {
+		t.Errorf("p50 of one latency: got %v", got)
+	}
+}
+
+func TestRunLoadTestMix(t *testing.T) {
+	var mu sync.Mutex
+	paths := map[string]int{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/api/v1/suspend" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	report, err := RunLoadTest(context.Background(), handler, LoadTestConfig{
+		Concurrency: 4,
+		Requests:    200,
+		Mix:         map[string]int{LoadCallRegister: 1, LoadCallSuspend: 1},
+		Teachers:    3,
+		Students:    10,
+		Seed:        1,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Requests != 200 || len(report.Calls) != 2 || report.Pool != nil {
+		t.Fatalf("got report %+v", report)
+	}
+	for _, call := range report.Calls {
+		switch call.Call {
+		case LoadCallRegister:
+			if call.Errors != 0 {
+				t.Errorf("register: got %d errors, want 0", call.Errors)
+			}
+		case LoadCallSuspend:
+			if call.Errors != call.Requests {
+				t.Errorf("suspend: got %d errors of %d requests", call.Errors, call.Requests)
+			}
+		default:
+			t.Errorf("unexpected call %s", call.Call)
+		}
+		if call.P50 > call.P99 || call.P99 > call.Max {
+			t.Errorf("%s: percentiles out of order: %+v", call.Call, call)
+		}
+	}
+
+	// the roster is seeded with one registration per teacher first
+	if paths["/api/v1/register"]+paths["/api/v1/suspend"] != 203 {
+		t.Errorf("got requests %v", paths)
+	}
+}
+
+// suspend and unsuspend pick from every student, so every one of them is
+// registered first
+func TestRunLoadTestSeedsEveryStudent(t *testing.T) {
+	var mu sync.Mutex
+	seeded := map[string]bool{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request RegisterStudentsToTeacherRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		mu.Lock()
+		for _, student := range request.StudentEmails {
+			seeded[student] = true
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	_, err := RunLoadTest(context.Background(), handler, LoadTestConfig{
+		Concurrency: 1,
+		Requests:    1,
+		Mix:         map[string]int{LoadCallSuspend: 1},
+		Teachers:    3,
+		Students:    20,
+		Seed:        1,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if !seeded[loadStudentEmail(i)] {
+			t.Errorf("%s was not registered before the calls", loadStudentEmail(i))
+		}
+	}
+}
+
+func TestLoadTestStoreDropsItsSchema(t *testing.T) {
+	store := newTestStore(t)
+	loadStore, drop, err := NewLoadTestStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustStorage(t, loadStore.CreateTeacher(NewTeacher(loadTeacherEmail(0))))
+	if exists, err := store.TeacherExists(loadTeacherEmail(0)); err != nil || exists {
+		t.Errorf("TeacherExists: got %v, %v in the tables of the store, want the load test schema only", exists, err)
+	}
+
+	if err := drop(); err != nil {
+		t.Fatal(err)
+	}
+	var schemas int
+	err = store.dbPool.QueryRow(context.Background(), `SELECT COUNT(1) FROM pg_namespace WHERE nspname LIKE 'loadtest\_%'`).Scan(&schemas)
+	if err != nil || schemas != 0 {
+		t.Errorf("got %d load test schemas, %v after dropping", schemas, err)
+	}
+}
+
+func TestRunLoadTestAgainstDatabase(t *testing.T) {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+
+	report, err := RunLoadTest(context.Background(), server.Router(), LoadTestConfig{
+		Concurrency: 8,
+		Requests:    100,
+		Teachers:    4,
+		Students:    20,
+	}, store.dbPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 100 || report.Errors != 0 {
+		t.Errorf("got %d requests with %d errors, want 100 without errors", report.Requests, report.Errors)
+	}
+	if report.Pool == nil || report.Pool.Acquires == 0 || report.Pool.MaxConns != 3 {
+		t.Errorf("got pool stats %+v", report.Pool)
+	}
+}
+
+// the benchmarks send requests from GOMAXPROCS goroutines (change it with
+// -cpu) to a store with the same 3 connections as the server
+
+func benchmarkRequests(b *testing.B, newRequest func(i int) *http.Request) {
+	store := newTestStore(b)
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+
+	roster := map[string][]string{}
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 20; j++ {
+			roster[loadTeacherEmail(i)] = append(roster[loadTeacherEmail(i)], loadStudentEmail(i*10+j))
+		}
+	}
+	for teacher, students := range roster {
+		if err := server.service.RegisterStudents(context.Background(), teacher, students); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	next := 0
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			i := next
+			next++
+			mu.Unlock()
+
+			if err := sendLoadRequest(router, newRequest(i)); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func BenchmarkRegister(b *testing.B) {
+	benchmarkRequests(b, func(i int) *http.Request {
+		return newLoadRegisterRequest(loadTeacherEmail(i%10), []string{loadStudentEmail(1000 + i)})
+	})
+}
+
+func BenchmarkCommonStudents(b *testing.B) {
+	benchmarkRequests(b, func(i int) *http.Request {
+		path := fmt.Sprintf("/api/v1/commonstudents?teacher=%s&teacher=%s", loadTeacherEmail(i%10), loadTeacherEmail((i+1)%10))
+		return httptest.NewRequest(http.MethodGet, path, nil)
+	})
+}
+
+func BenchmarkSuspend(b *testing.B) {
+	benchmarkRequests(b, func(i int) *http.Request {
+		path := "/api/v1/suspend"
+		if i%2 == 1 {
+			path = "/api/v1/unsuspend"
+		}
+		body := fmt.Sprintf(`{"student": %q}`, loadStudentEmail(i%100))
+		return httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	})
+}
+
+func BenchmarkRetrieveForNotifications(b *testing.B) {
+	benchmarkRequests(b, func(i int) *http.Request {
+		body := fmt.Sprintf(`{"teacher": %q, "notification": "Hello @%s"}`, loadTeacherEmail(i%10), loadStudentEmail(i%100))
+		return httptest.NewRequest(http.MethodPost, "/api/v1/retrievefornotifications", bytes.NewBufferString(body))
+	})
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log"
 	"os"
 
@@ -15,23 +17,11 @@ func init() {
 }
 
 func main() {
-	store, err := NewPostgresStore()
-	if err != nil {
+	if err := runCommand(os.Args[1:], os.Stdout); err != nil {
+		// the usage has already been printed when -h is given
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
 		log.Fatal(err)
 	}
-
-	if err := store.Init(); err != nil {
-		log.Fatal(err)
-	}
-
-	//fmt.Print("%+v\n", store)
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		port = "3000"
-	}
-
-	server := NewAPIServer(":"+port, store)
-	server.Run()
-
-	store.db.Close()
 }
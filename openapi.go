@@ -0,0 +1,57 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// the OpenAPI 3 description of the JSON API, keep it in step with
+// APIServer.Router
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// the Swagger UI assets apiDocsPage loads, vendored so /docs works without
+// internet access, see third_party/swagger-ui/README.md
+//
+//go:embed third_party/swagger-ui/swagger-ui-bundle.js third_party/swagger-ui/swagger-ui.css
+var swaggerUIFiles embed.FS
+
+// a Swagger UI page rendering /openapi.json
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Golang API Assesment</title>
+  <link rel="stylesheet" href="/docs/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="/docs/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+func serveOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+func serveAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(apiDocsPage))
+}
+
+func serveAPIDocsAssets() http.Handler {
+	assets, err := fs.Sub(swaggerUIFiles, "third_party/swagger-ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/docs/", http.FileServer(http.FS(assets)))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// fails when a route is added to the router without describing it in
+// openapi.json, or when the spec describes a route that does not exist.
+// the deprecated unversioned paths are covered by their /api/v1 entries
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	routed := map[string]bool{}
+	router := NewAPIServer(":0", newFakeStore()).Router()
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// the version prefixes of the subrouters match every method
+			return nil
+		}
+		if strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/api/v1/") {
+			path = v1Path(path)
+		}
+
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			routed[method+" "+path] = true
+			if _, ok := spec.Paths[path][method]; !ok {
+				t.Errorf("%s %s is not described in openapi.json", strings.ToUpper(method), path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if !routed[method+" "+path] {
+				t.Errorf("openapi.json describes %s %s which is not routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestServeOpenAPISpec(t *testing.T) {
+	router := NewAPIServer(":0", newFakeStore()).Router()
+
+	for _, path := range []string{"/openapi.json", "/docs", "/docs/swagger-ui-bundle.js", "/docs/swagger-ui.css"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("GET %s: got status %d, want %d", path, rr.Code, http.StatusOK)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if strings.Contains(rr.Body.String(), "://") {
+		t.Errorf("/docs loads assets from another host:\n%s", rr.Body.String())
+	}
+}
+
+func TestLegacyRoutesDeprecated(t *testing.T) {
+	router := NewAPIServer(":0", newFakeStore()).Router()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/commonstudents?teacher=teacherken%40gmail.com", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if rr.Header().Get("Deprecation") != "@1782777600" || rr.Header().Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" {
+		t.Errorf("legacy route has unexpected Deprecation or Sunset headers: %v", rr.Header())
+	}
+	if link := rr.Header().Get("Link"); !strings.Contains(link, "</api/v1/commonstudents>") {
+		t.Errorf("unexpected Link header %q", link)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/commonstudents?teacher=teacherken%40gmail.com", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if rr.Header().Get("Deprecation") != "" {
+		t.Errorf("versioned route must not be deprecated")
+	}
+}
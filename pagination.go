@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sort keys supported by the list queries
+const (
+	SortByEmail     = "email"
+	SortByCreatedAt = "created_at"
+)
+
+// a cursor points just after the last row of a page. it holds the sort key
+// and the email of that row, or the name of a class, which breaks ties
+// between equal sort keys
+type listCursor struct {
+	Sort  string    `json:"s"`
+	Email string    `json:"e"`
+	Time  time.Time `json:"t,omitempty"`
+}
+
+func encodeCursor(sort string, email string, createdAt time.Time) string {
+	cursor := listCursor{Sort: sort, Email: email}
+	if sort == SortByCreatedAt {
+		cursor.Time = createdAt.UTC()
+	}
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	cursor := new(listCursor)
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// builds the WHERE, ORDER BY and LIMIT clauses of a keyset paginated list
+// query over table. conditions and args are the query's own conditions and
+// the arguments they use, the returned args are to be passed to the query
+func buildListClauses(table string, conditions []string, args []any, opts ListOptions) (string, []any, error) {
+	return buildKeyedListClauses(table, "email", conditions, args, opts)
+}
+
+// buildListClauses for a table whose unique key is the key column instead of
+// email, SortByEmail and EmailPrefix then apply to that column
+func buildKeyedListClauses(table string, key string, conditions []string, args []any, opts ListOptions) (string, []any, error) {
+	sort := opts.Sort
+	if sort == "" {
+		sort = SortByEmail
+	}
+	if sort != SortByEmail && sort != SortByCreatedAt {
+		return "", nil, fmt.Errorf("invalid sort %s", sort)
+	}
+
+	addArg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if opts.EmailPrefix != "" {
+		// the prefix is matched literally, so LIKE wildcards in it are escaped
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(opts.EmailPrefix)
+		conditions = append(conditions, fmt.Sprintf("%s.%s LIKE %s", table, key, addArg(escaped+"%")))
+	}
+	if opts.CreatedAfter != nil {
+		conditions = append(conditions, fmt.Sprintf("%s.created_at >= %s", table, addArg(opts.CreatedAfter.UTC())))
+	}
+	if opts.CreatedBefore != nil {
+		conditions = append(conditions, fmt.Sprintf("%s.created_at < %s", table, addArg(opts.CreatedBefore.UTC())))
+	}
+	if opts.Suspended != nil {
+		conditions = append(conditions, fmt.Sprintf("COALESCE(%s.is_suspended, false) = %s", table, addArg(*opts.Suspended)))
+	}
+
+	comparison, direction := ">", "ASC"
+	if opts.Descending {
+		comparison, direction = "<", "DESC"
+	}
+
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		if cursor.Sort != sort {
+			return "", nil, fmt.Errorf("cursor does not match sort %s", sort)
+		}
+		if sort == SortByCreatedAt {
+			conditions = append(conditions, fmt.Sprintf("(%s.created_at, %s.%s) %s (%s, %s)",
+				table, table, key, comparison, addArg(cursor.Time), addArg(cursor.Email)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("%s.%s %s %s", table, key, comparison, addArg(cursor.Email)))
+		}
+	}
+
+	clauses := ""
+	if len(conditions) > 0 {
+		clauses += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if sort == SortByCreatedAt {
+		clauses += fmt.Sprintf(" ORDER BY %s.created_at %s, %s.%s %s", table, direction, table, key, direction)
+	} else {
+		clauses += fmt.Sprintf(" ORDER BY %s.%s %s", table, key, direction)
+	}
+
+	// one extra row is read to know whether there is a next page
+	if opts.Limit > 0 {
+		clauses += " LIMIT " + addArg(opts.Limit+1)
+	}
+
+	return clauses, args, nil
+}
+
+// trims the extra row read by a limited list query and returns the cursor of
+// the next page, or an empty string if this is the last page
+func paginate[T any](opts ListOptions, items []T, key func(T) (string, time.Time)) ([]T, string) {
+	if opts.Limit <= 0 || len(items) <= opts.Limit {
+		return items, ""
+	}
+	sort := opts.Sort
+	if sort == "" {
+		sort = SortByEmail
+	}
+	items = items[:opts.Limit]
+	email, createdAt := key(items[len(items)-1])
+	return items, encodeCursor(sort, email, createdAt)
+}
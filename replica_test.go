@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// a store whose reads lag behind the writes of its primary
+type laggingStore struct {
+	Storage
+	teachers []*Teacher
+	primary  *laggingStore
+}
+
+func (s *laggingStore) GetTeachers(opts ListOptions) ([]*Teacher, string, error) {
+	return s.teachers, "", nil
+}
+
+func (s *laggingStore) Primary() Storage {
+	return s.primary
+}
+
+func TestReadYourWritesHeader(t *testing.T) {
+	primary := &laggingStore{teachers: []*Teacher{NewTeacher("teacherken@gmail.com")}}
+	store := &laggingStore{teachers: []*Teacher{}, primary: primary}
+	router := NewAPIServer(":0", NewCachingStore(store, time.Minute, 10)).Router()
+
+	for _, test := range []struct {
+		header   string
+		expected int
+	}{
+		{"", 0},
+		{"false", 0},
+		{"true", 1},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/teachers", nil)
+		if test.header != "" {
+			req.Header.Set(readYourWritesHeader, test.header)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response TeachersResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Teachers) != test.expected {
+			t.Errorf("%s %q: got %d teachers, want %d", readYourWritesHeader, test.header, len(response.Teachers), test.expected)
+		}
+	}
+}
+
+func TestPostgresStoreReplicaConformance(t *testing.T) {
+	// the replica is the same database, so the store behaves as if the
+	// replica never lagged
+	RunStorageTests(t, func(t *testing.T) Storage {
+		store := newTestStore(t)
+		if err := store.ConnectReplica(store.dbPool.Config().Copy()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(store.replicaPool.Close)
+		return store
+	})
+}
+
+func TestPostgresStoreReplicaFallback(t *testing.T) {
+	store := newTestStore(t)
+
+	// nothing listens on a unix socket in an empty directory
+	replicaConfig := store.dbPool.Config().Copy()
+	replicaConfig.ConnConfig.Host = t.TempDir()
+	replicaConfig.ConnConfig.ConnectTimeout = time.Second
+	replicaConfig.ConnConfig.Fallbacks = nil
+	if err := store.ConnectReplica(replicaConfig); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.replicaPool.Close)
+
+	if err := store.CreateTeacher(NewTeacher("teacherken@gmail.com")); err != nil {
+		t.Fatal(err)
+	}
+	teachers, _, err := store.GetTeachers(ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(teachers) != 1 {
+		t.Errorf("got %d teachers from the primary, want 1", len(teachers))
+	}
+	if store.replicaDownUntil.Load() <= time.Now().UnixNano() {
+		t.Errorf("the failed replica is not skipped")
+	}
+
+	primary := store.Primary().(*PostgresStore)
+	if primary.replicaPool != nil {
+		t.Errorf("Primary still reads from the replica")
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// returned while the database cannot be reached. the JSON API answers it
+// with 503 Service Unavailable and the gRPC API with Unavailable
+var ErrDatabaseUnavailable = errors.New("database is unavailable")
+
+// how a database error can be handled
+type dbErrorKind int
+
+const (
+	// the error is an answer, e.g. a duplicate key, and retrying changes nothing
+	dbErrorPermanent dbErrorKind = iota
+	// the database could not run the statement, so it can be run again
+	dbErrorRetryable
+	// the connection broke while the statement ran, so it may or may not
+	// have been applied. only reads are retried
+	dbErrorUncertain
+)
+
+// Postgres error codes of failures that go away by themselves
+var transientPgErrorCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+func classifyDBError(err error) dbErrorKind {
+	if err == nil {
+		return dbErrorPermanent
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// class 08 is connection exceptions. the server reported the
+		// failure, so the statement was not applied
+		if transientPgErrorCodes[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08") {
+			return dbErrorRetryable
+		}
+		return dbErrorPermanent
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || pgconn.SafeToRetry(err) {
+		return dbErrorRetryable
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.Timeout(err) {
+		return dbErrorUncertain
+	}
+
+	return dbErrorPermanent
+}
+
+// CircuitBreaker stops calls to the database for a while once it has failed
+// Threshold times in a row. after the cooldown a single call is let through
+// to test it, which closes the breaker if it succeeds and opens it again if
+// it fails
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	testing   bool
+}
+
+// how long the circuit breaker of a ResilientStore stays open
+const circuitBreakerCooldown = 10 * time.Second
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// reports whether a call may go to the database
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.Threshold {
+		return true
+	}
+	if b.testing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.testing = true
+	return true
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.testing = false
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.testing = false
+	if b.failures >= b.Threshold {
+		b.openUntil = b.now().Add(b.Cooldown)
+	}
+}
+
+// ResilientStore is a Storage that retries the operations of the wrapped
+// store that fail because the database is briefly unavailable, waiting a
+// random part of an exponentially growing backoff between attempts. writes
+// are only retried when they cannot have been applied. a circuit breaker
+// makes calls fail fast with ErrDatabaseUnavailable while the database is
+// down
+type ResilientStore struct {
+	Storage
+
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	breaker     *CircuitBreaker
+	sleep       func(time.Duration)
+}
+
+func NewResilientStore(store Storage) *ResilientStore {
+	return &ResilientStore{
+		Storage:     store,
+		MaxAttempts: 3,
+		Backoff:     100 * time.Millisecond,
+		MaxBackoff:  time.Second,
+		breaker:     NewCircuitBreaker(5, circuitBreakerCooldown),
+		sleep:       time.Sleep,
+	}
+}
+
+// the wrapped store reading its own writes, with the same retries and
+// circuit breaker
+func (r *ResilientStore) Primary() Storage {
+	primary, ok := r.Storage.(primaryReader)
+	if !ok {
+		return r
+	}
+
+	view := *r
+	view.Storage = primary.Primary()
+	return &view
+}
+
+func (r *ResilientStore) read(op func() error) error {
+	return r.call(true, r.MaxAttempts, op)
+}
+
+func (r *ResilientStore) write(op func() error) error {
+	return r.call(false, r.MaxAttempts, op)
+}
+
+// runs op once through the circuit breaker, for writes that commit in parts
+// and so cannot be run again from the start
+func (r *ResilientStore) once(op func() error) error {
+	return r.call(false, 1, op)
+}
+
+func (r *ResilientStore) call(readOnly bool, maxAttempts int, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		if !r.breaker.Allow() {
+			return ErrDatabaseUnavailable
+		}
+
+		err := op()
+		kind := classifyDBError(err)
+		if kind == dbErrorPermanent {
+			r.breaker.Success()
+			return err
+		}
+		r.breaker.Failure()
+
+		if attempt >= maxAttempts || (kind == dbErrorUncertain && !readOnly) {
+			return fmt.Errorf("%w: %v", ErrDatabaseUnavailable, err)
+		}
+		r.sleep(r.backoff(attempt))
+	}
+}
+
+// a random wait of up to Backoff doubled for every attempt so far, so
+// clients that failed together do not retry together
+func (r *ResilientStore) backoff(attempt int) time.Duration {
+	limit := r.Backoff << (attempt - 1)
+	if limit > r.MaxBackoff || limit <= 0 {
+		limit = r.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// the wrapped operations, every method of Storage must have one.
+// reads are retried on any transient failure
+
+func (r *ResilientStore) CreateTeacher(teacher *Teacher) error {
+	return r.write(func() error { return r.Storage.CreateTeacher(teacher) })
+}
+
+func (r *ResilientStore) GetTeachers(opts ListOptions) (teachers []*Teacher, cursor string, err error) {
+	err = r.read(func() (err error) {
+		teachers, cursor, err = r.Storage.GetTeachers(opts)
+		return err
+	})
+	return teachers, cursor, err
+}
+
+func (r *ResilientStore) UpdateTeacher(teacher *Teacher) error {
+	return r.write(func() error { return r.Storage.UpdateTeacher(teacher) })
+}
+
+func (r *ResilientStore) GetTeacherByEmail(email string) (teacher *Teacher, err error) {
+	err = r.read(func() (err error) {
+		teacher, err = r.Storage.GetTeacherByEmail(email)
+		return err
+	})
+	return teacher, err
+}
+
+func (r *ResilientStore) TeacherExists(email string) (exists bool, err error) {
+	err = r.read(func() (err error) {
+		exists, err = r.Storage.TeacherExists(email)
+		return err
+	})
+	return exists, err
+}
+
+func (r *ResilientStore) DeleteTeacher(email string, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.DeleteTeacher(email, audit...) })
+}
+
+func (r *ResilientStore) RestoreTeacher(email string, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.RestoreTeacher(email, audit...) })
+}
+
+func (r *ResilientStore) RenameTeacher(email string, newEmail string, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.RenameTeacher(email, newEmail, audit...) })
+}
+
+func (r *ResilientStore) CreateStudent(student *Student) error {
+	return r.write(func() error { return r.Storage.CreateStudent(student) })
+}
+
+func (r *ResilientStore) UpdateStudent(student *Student) error {
+	return r.write(func() error { return r.Storage.UpdateStudent(student) })
+}
+
+func (r *ResilientStore) UpdateStudentSuspendedState(email string, suspended bool, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.UpdateStudentSuspendedState(email, suspended, audit...) })
+}
+
+func (r *ResilientStore) GetStudents(opts ListOptions) (students []*Student, cursor string, err error) {
+	err = r.read(func() (err error) {
+		students, cursor, err = r.Storage.GetStudents(opts)
+		return err
+	})
+	return students, cursor, err
+}
+
+func (r *ResilientStore) GetStudentByEmail(email string) (student *Student, err error) {
+	err = r.read(func() (err error) {
+		student, err = r.Storage.GetStudentByEmail(email)
+		return err
+	})
+	return student, err
+}
+
+func (r *ResilientStore) StudentExists(email string) (exists bool, err error) {
+	err = r.read(func() (err error) {
+		exists, err = r.Storage.StudentExists(email)
+		return err
+	})
+	return exists, err
+}
+
+func (r *ResilientStore) IsStudentSuspended(email string) (suspended bool, err error) {
+	err = r.read(func() (err error) {
+		suspended, err = r.Storage.IsStudentSuspended(email)
+		return err
+	})
+	return suspended, err
+}
+
+func (r *ResilientStore) DeleteStudent(email string, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.DeleteStudent(email, audit...) })
+}
+
+func (r *ResilientStore) RestoreStudent(email string, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.RestoreStudent(email, audit...) })
+}
+
+func (r *ResilientStore) RenameStudent(email string, newEmail string, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.RenameStudent(email, newEmail, audit...) })
+}
+
+func (r *ResilientStore) GetStudentData(email string) (export *StudentDataExport, err error) {
+	err = r.read(func() (err error) {
+		export, err = r.Storage.GetStudentData(email)
+		return err
+	})
+	return export, err
+}
+
+func (r *ResilientStore) EraseStudent(email string, audit ...*AuditEntry) (erased string, err error) {
+	err = r.write(func() (err error) {
+		erased, err = r.Storage.EraseStudent(email, audit...)
+		return err
+	})
+	return erased, err
+}
+
+func (r *ResilientStore) CreateTeacherStudent(teacherStudent *TeacherStudent, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.CreateTeacherStudent(teacherStudent, audit...) })
+}
+
+func (r *ResilientStore) GetTeacherStudentByEmail(teacherEmail string, studentEmail string) (teacherStudent *TeacherStudent, err error) {
+	err = r.read(func() (err error) {
+		teacherStudent, err = r.Storage.GetTeacherStudentByEmail(teacherEmail, studentEmail)
+		return err
+	})
+	return teacherStudent, err
+}
+
+func (r *ResilientStore) GetStudentsAssignedToTeacher(teacherEmail string, opts ListOptions) (students []string, cursor string, err error) {
+	err = r.read(func() (err error) {
+		students, cursor, err = r.Storage.GetStudentsAssignedToTeacher(teacherEmail, opts)
+		return err
+	})
+	return students, cursor, err
+}
+
+func (r *ResilientStore) GetStudentsOfTeacher(teacherEmail string, opts ListOptions) (students []*Student, cursor string, err error) {
+	err = r.read(func() (err error) {
+		students, cursor, err = r.Storage.GetStudentsOfTeacher(teacherEmail, opts)
+		return err
+	})
+	return students, cursor, err
+}
+
+func (r *ResilientStore) GetTeachersOfStudent(studentEmail string, opts ListOptions) (teachers []*Teacher, cursor string, err error) {
+	err = r.read(func() (err error) {
+		teachers, cursor, err = r.Storage.GetTeachersOfStudent(studentEmail, opts)
+		return err
+	})
+	return teachers, cursor, err
+}
+
+func (r *ResilientStore) TeacherStudentExists(teacherEmail string, studentEmail string) (exists bool, err error) {
+	err = r.read(func() (err error) {
+		exists, err = r.Storage.TeacherStudentExists(teacherEmail, studentEmail)
+		return err
+	})
+	return exists, err
+}
+
+func (r *ResilientStore) DeleteTeacherStudent(teacherEmail string, studentEmail string, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.DeleteTeacherStudent(teacherEmail, studentEmail, audit...) })
+}
+
+func (r *ResilientStore) GetCommonStudentsOfTeachers(teacherEmails []string) (students []string, err error) {
+	err = r.read(func() (err error) {
+		students, err = r.Storage.GetCommonStudentsOfTeachers(teacherEmails)
+		return err
+	})
+	return students, err
+}
+
+func (r *ResilientStore) CreateClass(class *Class) error {
+	return r.write(func() error { return r.Storage.CreateClass(class) })
+}
+
+func (r *ResilientStore) UpdateClass(name string, class *Class) error {
+	return r.write(func() error { return r.Storage.UpdateClass(name, class) })
+}
+
+func (r *ResilientStore) DeleteClass(name string) error {
+	return r.write(func() error { return r.Storage.DeleteClass(name) })
+}
+
+func (r *ResilientStore) GetClasses(opts ListOptions) (classes []*Class, cursor string, err error) {
+	err = r.read(func() (err error) {
+		classes, cursor, err = r.Storage.GetClasses(opts)
+		return err
+	})
+	return classes, cursor, err
+}
+
+func (r *ResilientStore) GetClassByName(name string) (class *Class, err error) {
+	err = r.read(func() (err error) {
+		class, err = r.Storage.GetClassByName(name)
+		return err
+	})
+	return class, err
+}
+
+func (r *ResilientStore) ClassExists(name string) (exists bool, err error) {
+	err = r.read(func() (err error) {
+		exists, err = r.Storage.ClassExists(name)
+		return err
+	})
+	return exists, err
+}
+
+func (r *ResilientStore) CreateClassStudent(classStudent *ClassStudent) error {
+	return r.write(func() error { return r.Storage.CreateClassStudent(classStudent) })
+}
+
+func (r *ResilientStore) DeleteClassStudent(classID int, studentEmail string) error {
+	return r.write(func() error { return r.Storage.DeleteClassStudent(classID, studentEmail) })
+}
+
+func (r *ResilientStore) GetStudentsInClass(classID int, opts ListOptions) (students []string, cursor string, err error) {
+	err = r.read(func() (err error) {
+		students, cursor, err = r.Storage.GetStudentsInClass(classID, opts)
+		return err
+	})
+	return students, cursor, err
+}
+
+func (r *ResilientStore) ClassStudentExists(classID int, studentEmail string) (exists bool, err error) {
+	err = r.read(func() (err error) {
+		exists, err = r.Storage.ClassStudentExists(classID, studentEmail)
+		return err
+	})
+	return exists, err
+}
+
+// every batch of an import is committed on its own. running the import
+// again after some were committed would leave their registrations out of
+// the report, so it is not retried
+func (r *ResilientStore) ImportRoster(rows []RosterRow, dryRun bool, audit func(TeacherStudentPair) *AuditEntry) (report *ImportReport, err error) {
+	err = r.once(func() (err error) {
+		report, err = r.Storage.ImportRoster(rows, dryRun, audit)
+		return err
+	})
+	return report, err
+}
+
+// the records already handed to fn cannot be taken back, so the export is
+// retried like a write, only when it failed before it started. errors of fn,
+// e.g. a client that went away, say nothing about the database
+func (r *ResilientStore) ExportRoster(fn func(*RosterRecord) error) error {
+	var fnErr error
+	err := r.write(func() error {
+		err := r.Storage.ExportRoster(func(record *RosterRecord) error {
+			fnErr = fn(record)
+			return fnErr
+		})
+		if fnErr != nil {
+			return nil
+		}
+		return err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (r *ResilientStore) ReserveIdempotencyKey(record *IdempotencyRecord, expiredBefore time.Time, abandonedBefore time.Time) (reserved bool, err error) {
+	err = r.write(func() (err error) {
+		reserved, err = r.Storage.ReserveIdempotencyKey(record, expiredBefore, abandonedBefore)
+		return err
+	})
+	return reserved, err
+}
+
+func (r *ResilientStore) GetIdempotencyRecord(key string) (record *IdempotencyRecord, err error) {
+	err = r.read(func() (err error) {
+		record, err = r.Storage.GetIdempotencyRecord(key)
+		return err
+	})
+	return record, err
+}
+
+func (r *ResilientStore) SaveIdempotencyResponse(record *IdempotencyRecord) error {
+	return r.write(func() error { return r.Storage.SaveIdempotencyResponse(record) })
+}
+
+func (r *ResilientStore) DeleteIdempotencyKey(key string) error {
+	return r.write(func() error { return r.Storage.DeleteIdempotencyKey(key) })
+}
+
+func (r *ResilientStore) CreateAuditEntries(entries []*AuditEntry) error {
+	return r.write(func() error { return r.Storage.CreateAuditEntries(entries) })
+}
+
+func (r *ResilientStore) GetAuditEntries(filter AuditFilter) (entries []*AuditEntry, cursor string, err error) {
+	err = r.read(func() (err error) {
+		entries, cursor, err = r.Storage.GetAuditEntries(filter)
+		return err
+	})
+	return entries, cursor, err
+}
+
+func (r *ResilientStore) CreateNotification(notification *Notification, audit ...*AuditEntry) error {
+	return r.write(func() error { return r.Storage.CreateNotification(notification, audit...) })
+}
+
+func (r *ResilientStore) CreateWebhook(webhook *WebhookSubscription) error {
+	return r.write(func() error { return r.Storage.CreateWebhook(webhook) })
+}
+
+func (r *ResilientStore) GetWebhooks() (webhooks []*WebhookSubscription, err error) {
+	err = r.read(func() (err error) {
+		webhooks, err = r.Storage.GetWebhooks()
+		return err
+	})
+	return webhooks, err
+}
+
+func (r *ResilientStore) GetWebhook(id int64) (webhook *WebhookSubscription, err error) {
+	err = r.read(func() (err error) {
+		webhook, err = r.Storage.GetWebhook(id)
+		return err
+	})
+	return webhook, err
+}
+
+func (r *ResilientStore) DeleteWebhook(id int64) error {
+	return r.write(func() error { return r.Storage.DeleteWebhook(id) })
+}
+
+func (r *ResilientStore) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	return r.write(func() error { return r.Storage.CreateWebhookDelivery(delivery) })
+}
+
+func (r *ResilientStore) GetWebhookDeliveries(webhookID int64, limit int) (deliveries []*WebhookDelivery, err error) {
+	err = r.read(func() (err error) {
+		deliveries, err = r.Storage.GetWebhookDeliveries(webhookID, limit)
+		return err
+	})
+	return deliveries, err
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// a store whose operations fail with the queued errors before succeeding
+type flakyStore struct {
+	Storage
+	errs  []error
+	calls int
+}
+
+func (s *flakyStore) next() error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	if len(s.errs) > 1 {
+		s.errs = s.errs[1:]
+	}
+	return err
+}
+
+func (s *flakyStore) CreateTeacher(teacher *Teacher) error {
+	return s.next()
+}
+
+func (s *flakyStore) GetTeachers(opts ListOptions) ([]*Teacher, string, error) {
+	if err := s.next(); err != nil {
+		return nil, "", err
+	}
+	return []*Teacher{NewTeacher("teacherken@gmail.com")}, "", nil
+}
+
+func (s *flakyStore) ImportRoster(rows []RosterRow, dryRun bool, audit func(TeacherStudentPair) *AuditEntry) (*ImportReport, error) {
+	if err := s.next(); err != nil {
+		return nil, err
+	}
+	return NewImportReport(dryRun), nil
+}
+
+func newTestResilientStore(errs ...error) (*ResilientStore, *flakyStore, *[]time.Duration) {
+	store := &flakyStore{errs: errs}
+	resilient := NewResilientStore(store)
+	sleeps := &[]time.Duration{}
+	resilient.sleep = func(d time.Duration) { *sleeps = append(*sleeps, d) }
+	return resilient, store, sleeps
+}
+
+func TestClassifyDBError(t *testing.T) {
+	for _, test := range []struct {
+		err      error
+		expected dbErrorKind
+	}{
+		{nil, dbErrorPermanent},
+		{fmt.Errorf("entry does not exist"), dbErrorPermanent},
+		{&pgconn.PgError{Code: "23505"}, dbErrorPermanent}, // unique_violation
+		{&pgconn.PgError{Code: "40001"}, dbErrorRetryable},
+		{&pgconn.PgError{Code: "53300"}, dbErrorRetryable},
+		{&pgconn.PgError{Code: "0"}, dbErrorPermanent},
+		{&pgconn.PgError{}, dbErrorPermanent},
+		{fmt.Errorf("query: %w", &pgconn.PgError{Code: "08006"}), dbErrorRetryable},
+		{&pgconn.ConnectError{}, dbErrorRetryable},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), dbErrorUncertain},
+		{io.ErrUnexpectedEOF, dbErrorUncertain},
+	} {
+		if got := classifyDBError(test.err); got != test.expected {
+			t.Errorf("classifyDBError(%v): got %d, want %d", test.err, got, test.expected)
+		}
+	}
+}
+
+func TestResilientStoreRetriesReads(t *testing.T) {
+	resilient, store, sleeps := newTestResilientStore(syscall.ECONNRESET, io.EOF, nil)
+
+	teachers, _, err := resilient.GetTeachers(ListOptions{})
+	if err != nil || len(teachers) != 1 {
+		t.Fatalf("got %v, %v", teachers, err)
+	}
+	if store.calls != 3 || len(*sleeps) != 2 {
+		t.Errorf("got %d calls and %d waits, want 3 and 2", store.calls, len(*sleeps))
+	}
+	for i, sleep := range *sleeps {
+		if limit := resilient.Backoff << i; sleep < 0 || sleep > limit {
+			t.Errorf("wait %d: got %v, want at most %v", i, sleep, limit)
+		}
+	}
+}
+
+func TestResilientStoreGivesUp(t *testing.T) {
+	resilient, store, _ := newTestResilientStore(syscall.ECONNRESET)
+
+	_, _, err := resilient.GetTeachers(ListOptions{})
+	if !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("got error %v, want %v", err, ErrDatabaseUnavailable)
+	}
+	if store.calls != resilient.MaxAttempts {
+		t.Errorf("got %d calls, want %d", store.calls, resilient.MaxAttempts)
+	}
+}
+
+func TestResilientStoreRetriesWritesOnlyWhenSafe(t *testing.T) {
+	resilient, store, _ := newTestResilientStore(&pgconn.PgError{Code: "40001"}, nil)
+	if err := resilient.CreateTeacher(NewTeacher("teacherken@gmail.com")); err != nil {
+		t.Errorf("retried write: %v", err)
+	}
+	if store.calls != 2 {
+		t.Errorf("got %d calls of a write that was not applied, want 2", store.calls)
+	}
+
+	// the write may have been applied before the connection broke
+	resilient, store, _ = newTestResilientStore(syscall.ECONNRESET, nil)
+	if err := resilient.CreateTeacher(NewTeacher("teacherken@gmail.com")); !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("got error %v, want %v", err, ErrDatabaseUnavailable)
+	}
+	if store.calls != 1 {
+		t.Errorf("got %d calls of a write that may have been applied, want 1", store.calls)
+	}
+
+	resilient, store, _ = newTestResilientStore(&pgconn.PgError{Code: "23505"})
+	if err := resilient.CreateTeacher(NewTeacher("teacherken@gmail.com")); err == nil || errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("got error %v, want the duplicate key error", err)
+	}
+	if store.calls != 1 {
+		t.Errorf("got %d calls of a failed write, want 1", store.calls)
+	}
+}
+
+// batches committed before the failure would be left out of the report of
+// a retry
+func TestResilientStoreDoesNotRetryImports(t *testing.T) {
+	resilient, store, _ := newTestResilientStore(&pgconn.PgError{Code: "40001"}, nil)
+	if _, err := resilient.ImportRoster(nil, false, nil); !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("got error %v, want %v", err, ErrDatabaseUnavailable)
+	}
+	if store.calls != 1 {
+		t.Errorf("got %d calls of an import, want 1", store.calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(2, 10*time.Second)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	if !breaker.Allow() {
+		t.Fatalf("opened before the threshold")
+	}
+	breaker.Failure()
+	if breaker.Allow() {
+		t.Fatalf("did not open at the threshold")
+	}
+
+	// a single call tests the database after the cooldown
+	now = now.Add(10 * time.Second)
+	if !breaker.Allow() {
+		t.Fatalf("did not let a call through after the cooldown")
+	}
+	if breaker.Allow() {
+		t.Errorf("let a second call through while testing")
+	}
+	breaker.Failure()
+	if breaker.Allow() {
+		t.Errorf("did not open again after the test failed")
+	}
+
+	now = now.Add(10 * time.Second)
+	breaker.Allow()
+	breaker.Success()
+	if !breaker.Allow() || !breaker.Allow() {
+		t.Errorf("did not close after the test succeeded")
+	}
+}
+
+func TestResilientStoreFailsFast(t *testing.T) {
+	resilient, store, _ := newTestResilientStore(&pgconn.ConnectError{})
+
+	for i := 0; i < 10; i++ {
+		if _, _, err := resilient.GetTeachers(ListOptions{}); !errors.Is(err, ErrDatabaseUnavailable) {
+			t.Fatalf("got error %v, want %v", err, ErrDatabaseUnavailable)
+		}
+	}
+	if store.calls != resilient.breaker.Threshold {
+		t.Errorf("got %d calls, want the circuit breaker to stop them after %d", store.calls, resilient.breaker.Threshold)
+	}
+}
+
+func TestDatabaseUnavailableResponses(t *testing.T) {
+	resilient, _, _ := newTestResilientStore(&pgconn.ConnectError{})
+	router := NewAPIServer(":0", resilient).Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/teachers", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("got status %d with Retry-After %q, want 503 with Retry-After", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	if code := status.Code(grpcError(ErrDatabaseUnavailable)); code != codes.Unavailable {
+		t.Errorf("got gRPC code %v, want %v", code, codes.Unavailable)
+	}
+}
+
+// embedding Storage would silently skip the retries of an operation added
+// to Storage later
+func TestResilientStoreWrapsEveryMethod(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "resilience.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped := map[string]bool{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil {
+			continue
+		}
+		if star, ok := fn.Recv.List[0].Type.(*ast.StarExpr); ok {
+			if ident, ok := star.X.(*ast.Ident); ok && ident.Name == "ResilientStore" {
+				wrapped[fn.Name.Name] = true
+			}
+		}
+	}
+
+	storage := reflect.TypeOf((*Storage)(nil)).Elem()
+	for i := 0; i < storage.NumMethod(); i++ {
+		if name := storage.Method(i).Name; !wrapped[name] {
+			t.Errorf("ResilientStore does not wrap %s", name)
+		}
+	}
+}
+
+func TestResilientStoreConformance(t *testing.T) {
+	RunStorageTests(t, func(t *testing.T) Storage {
+		return NewResilientStore(newTestStore(t))
+	})
+}
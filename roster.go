@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// number of roster rows written to the database per transaction
+const rosterBatchSize = 1000
+
+var emailRegexp = regexp.MustCompile(`^[\w.%+-]+@[\w.-]+\.[a-zA-Z]{2,}$`)
+
+func IsValidEmail(email string) bool {
+	return emailRegexp.MatchString(email)
+}
+
+// the form emails are stored and compared in. emails are case-insensitive,
+// so StudentJon@gmail.com and studentjon@gmail.com are the same student
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func NormalizeEmails(emails []string) []string {
+	normalized := make([]string, len(emails))
+	for i, email := range emails {
+		normalized[i] = NormalizeEmail(email)
+	}
+	return normalized
+}
+
+// one teacher,student[,class] line of a roster
+type RosterRow struct {
+	Line         int    `json:"line"`
+	TeacherEmail string `json:"teacher"`
+	StudentEmail string `json:"student"`
+	ClassName    string `json:"class,omitempty"`
+}
+
+type RosterRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type TeacherStudentPair struct {
+	TeacherEmail string `json:"teacher"`
+	StudentEmail string `json:"student"`
+}
+
+type ClassStudentPair struct {
+	ClassName    string `json:"class"`
+	StudentEmail string `json:"student"`
+}
+
+// what an import changed, or would change for a dry run
+type ImportReport struct {
+	DryRun              bool                 `json:"dry_run"`
+	Rows                int                  `json:"rows"`
+	Errors              []RosterRowError     `json:"errors,omitempty"`
+	NewTeachers         []string             `json:"new_teachers"`
+	NewStudents         []string             `json:"new_students"`
+	NewClasses          []string             `json:"new_classes"`
+	NewRegistrations    []TeacherStudentPair `json:"new_registrations"`
+	NewClassMemberships []ClassStudentPair   `json:"new_class_memberships"`
+	// deleted teachers and students named by the roster, whose rows were
+	// skipped
+	Deleted []string `json:"deleted,omitempty"`
+	// why an import stopped after some batches were committed, the report
+	// lists what those batches changed
+	Error string `json:"error,omitempty"`
+}
+
+func NewImportReport(dryRun bool) *ImportReport {
+	return &ImportReport{
+		DryRun:              dryRun,
+		NewTeachers:         []string{},
+		NewStudents:         []string{},
+		NewClasses:          []string{},
+		NewRegistrations:    []TeacherStudentPair{},
+		NewClassMemberships: []ClassStudentPair{},
+	}
+}
+
+// reads a teacher,student[,class] CSV roster and validates every row. a
+// first line naming the columns is treated as a header, in which case the
+// columns may come in any order and unknown columns are ignored
+func ParseRosterCSV(r io.Reader) ([]RosterRow, []RosterRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	columns := map[string]int{"teacher": 0, "student": 1, "class": 2}
+	rows := []RosterRow{}
+	rowErrors := []RosterRowError{}
+
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowErrors = append(rowErrors, RosterRowError{Line: parseErr.Line, Error: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if first && isRosterHeader(record) {
+			columns = map[string]int{}
+			for i, name := range record {
+				columns[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			if _, ok := columns["teacher"]; !ok {
+				return nil, nil, fmt.Errorf("roster header has no teacher column")
+			}
+			if _, ok := columns["student"]; !ok {
+				return nil, nil, fmt.Errorf("roster header has no student column")
+			}
+			continue
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := RosterRow{
+			Line:         line,
+			TeacherEmail: NormalizeEmail(field("teacher")),
+			StudentEmail: NormalizeEmail(field("student")),
+			ClassName:    field("class"),
+		}
+
+		if err := validateRosterRow(row); err != nil {
+			rowErrors = append(rowErrors, RosterRowError{Line: line, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, rowErrors, nil
+}
+
+func isRosterHeader(record []string) bool {
+	for _, name := range record {
+		if strings.EqualFold(strings.TrimSpace(name), "teacher") {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRosterRow(row RosterRow) error {
+	if row.TeacherEmail == "" {
+		return fmt.Errorf("teacher email is required")
+	}
+	if !IsValidEmail(row.TeacherEmail) {
+		return fmt.Errorf("invalid teacher email %s", row.TeacherEmail)
+	}
+	if row.StudentEmail == "" {
+		return fmt.Errorf("student email is required")
+	}
+	if !IsValidEmail(row.StudentEmail) {
+		return fmt.Errorf("invalid student email %s", row.StudentEmail)
+	}
+	if len(row.ClassName) > 255 {
+		return fmt.Errorf("class name is longer than 255 characters")
+	}
+	return nil
+}
+
+// parses and validates a CSV roster, then applies it to the store. nothing
+// is written if any row is invalid or if dryRun is set. audit makes the
+// audit entries of new registrations, see Storage.ImportRoster
+func ImportRosterCSV(store Storage, r io.Reader, dryRun bool, audit func(TeacherStudentPair) *AuditEntry) (*ImportReport, error) {
+	rows, rowErrors, err := ParseRosterCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rowErrors) > 0 {
+		report := NewImportReport(true)
+		report.Rows = len(rows) + len(rowErrors)
+		report.Errors = rowErrors
+		return report, fmt.Errorf("roster has %d invalid rows", len(rowErrors))
+	}
+
+	return store.ImportRoster(rows, dryRun, audit)
+}
+
+// roster export formats
+const (
+	ExportFormatCSV   = "csv"
+	ExportFormatJSONL = "jsonl"
+)
+
+func IsValidExportFormat(format string) bool {
+	return format == ExportFormatCSV || format == ExportFormatJSONL
+}
+
+// one teacher-student registration of an exported roster. teachers without
+// students and students without teachers are exported with the other side empty
+type RosterRecord struct {
+	TeacherEmail     string `json:"teacher"`
+	StudentEmail     string `json:"student"`
+	StudentSuspended bool   `json:"suspended"`
+}
+
+// streams the whole roster from the store to w in the given format. records
+// are written as they are read so the roster is never held in memory
+func ExportRoster(store Storage, w io.Writer, format string) error {
+	buffered := bufio.NewWriter(w)
+
+	var write func(*RosterRecord) error
+	switch format {
+	case ExportFormatCSV:
+		// the header names the columns the same way ParseRosterCSV does
+		writer := csv.NewWriter(buffered)
+		if err := writer.Write([]string{"teacher", "student", "suspended"}); err != nil {
+			return err
+		}
+		write = func(record *RosterRecord) error {
+			writer.Write([]string{record.TeacherEmail, record.StudentEmail, strconv.FormatBool(record.StudentSuspended)})
+			writer.Flush()
+			return writer.Error()
+		}
+	case ExportFormatJSONL:
+		encoder := json.NewEncoder(buffered)
+		write = func(record *RosterRecord) error {
+			return encoder.Encode(record)
+		}
+	default:
+		return fmt.Errorf("invalid export format %s", format)
+	}
+
+	if err := store.ExportRoster(write); err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
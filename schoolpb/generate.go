@@ -0,0 +1,5 @@
+// Package schoolpb holds the protobuf messages and gRPC service generated
+// from school.proto. run go generate after changing school.proto
+package schoolpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative school.proto
@@ -0,0 +1,376 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: school.proto
+
+package schoolpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	SchoolService_Register_FullMethodName                 = "/school.v1.SchoolService/Register"
+	SchoolService_CommonStudents_FullMethodName           = "/school.v1.SchoolService/CommonStudents"
+	SchoolService_Suspend_FullMethodName                  = "/school.v1.SchoolService/Suspend"
+	SchoolService_RetrieveForNotifications_FullMethodName = "/school.v1.SchoolService/RetrieveForNotifications"
+	SchoolService_ListTeachers_FullMethodName             = "/school.v1.SchoolService/ListTeachers"
+	SchoolService_ListStudents_FullMethodName             = "/school.v1.SchoolService/ListStudents"
+	SchoolService_ListStudentsOfTeacher_FullMethodName    = "/school.v1.SchoolService/ListStudentsOfTeacher"
+	SchoolService_ListTeachersOfStudent_FullMethodName    = "/school.v1.SchoolService/ListTeachersOfStudent"
+)
+
+// SchoolServiceClient is the client API for SchoolService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SchoolServiceClient interface {
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	CommonStudents(ctx context.Context, in *CommonStudentsRequest, opts ...grpc.CallOption) (*CommonStudentsResponse, error)
+	Suspend(ctx context.Context, in *SuspendRequest, opts ...grpc.CallOption) (*SuspendResponse, error)
+	RetrieveForNotifications(ctx context.Context, in *RetrieveForNotificationsRequest, opts ...grpc.CallOption) (*RetrieveForNotificationsResponse, error)
+	ListTeachers(ctx context.Context, in *ListTeachersRequest, opts ...grpc.CallOption) (*ListTeachersResponse, error)
+	ListStudents(ctx context.Context, in *ListStudentsRequest, opts ...grpc.CallOption) (*ListStudentsResponse, error)
+	ListStudentsOfTeacher(ctx context.Context, in *ListStudentsOfTeacherRequest, opts ...grpc.CallOption) (*ListStudentsResponse, error)
+	ListTeachersOfStudent(ctx context.Context, in *ListTeachersOfStudentRequest, opts ...grpc.CallOption) (*ListTeachersResponse, error)
+}
+
+type schoolServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSchoolServiceClient(cc grpc.ClientConnInterface) SchoolServiceClient {
+	return &schoolServiceClient{cc}
+}
+
+func (c *schoolServiceClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, SchoolService_Register_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *schoolServiceClient) CommonStudents(ctx context.Context, in *CommonStudentsRequest, opts ...grpc.CallOption) (*CommonStudentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CommonStudentsResponse)
+	err := c.cc.Invoke(ctx, SchoolService_CommonStudents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *schoolServiceClient) Suspend(ctx context.Context, in *SuspendRequest, opts ...grpc.CallOption) (*SuspendResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SuspendResponse)
+	err := c.cc.Invoke(ctx, SchoolService_Suspend_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *schoolServiceClient) RetrieveForNotifications(ctx context.Context, in *RetrieveForNotificationsRequest, opts ...grpc.CallOption) (*RetrieveForNotificationsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RetrieveForNotificationsResponse)
+	err := c.cc.Invoke(ctx, SchoolService_RetrieveForNotifications_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *schoolServiceClient) ListTeachers(ctx context.Context, in *ListTeachersRequest, opts ...grpc.CallOption) (*ListTeachersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTeachersResponse)
+	err := c.cc.Invoke(ctx, SchoolService_ListTeachers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *schoolServiceClient) ListStudents(ctx context.Context, in *ListStudentsRequest, opts ...grpc.CallOption) (*ListStudentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListStudentsResponse)
+	err := c.cc.Invoke(ctx, SchoolService_ListStudents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *schoolServiceClient) ListStudentsOfTeacher(ctx context.Context, in *ListStudentsOfTeacherRequest, opts ...grpc.CallOption) (*ListStudentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListStudentsResponse)
+	err := c.cc.Invoke(ctx, SchoolService_ListStudentsOfTeacher_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *schoolServiceClient) ListTeachersOfStudent(ctx context.Context, in *ListTeachersOfStudentRequest, opts ...grpc.CallOption) (*ListTeachersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTeachersResponse)
+	err := c.cc.Invoke(ctx, SchoolService_ListTeachersOfStudent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SchoolServiceServer is the server API for SchoolService service.
+// All implementations must embed UnimplementedSchoolServiceServer
+// for forward compatibility
+type SchoolServiceServer interface {
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	CommonStudents(context.Context, *CommonStudentsRequest) (*CommonStudentsResponse, error)
+	Suspend(context.Context, *SuspendRequest) (*SuspendResponse, error)
+	RetrieveForNotifications(context.Context, *RetrieveForNotificationsRequest) (*RetrieveForNotificationsResponse, error)
+	ListTeachers(context.Context, *ListTeachersRequest) (*ListTeachersResponse, error)
+	ListStudents(context.Context, *ListStudentsRequest) (*ListStudentsResponse, error)
+	ListStudentsOfTeacher(context.Context, *ListStudentsOfTeacherRequest) (*ListStudentsResponse, error)
+	ListTeachersOfStudent(context.Context, *ListTeachersOfStudentRequest) (*ListTeachersResponse, error)
+	mustEmbedUnimplementedSchoolServiceServer()
+}
+
+// UnimplementedSchoolServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedSchoolServiceServer struct {
+}
+
+func (UnimplementedSchoolServiceServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedSchoolServiceServer) CommonStudents(context.Context, *CommonStudentsRequest) (*CommonStudentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommonStudents not implemented")
+}
+func (UnimplementedSchoolServiceServer) Suspend(context.Context, *SuspendRequest) (*SuspendResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Suspend not implemented")
+}
+func (UnimplementedSchoolServiceServer) RetrieveForNotifications(context.Context, *RetrieveForNotificationsRequest) (*RetrieveForNotificationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RetrieveForNotifications not implemented")
+}
+func (UnimplementedSchoolServiceServer) ListTeachers(context.Context, *ListTeachersRequest) (*ListTeachersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTeachers not implemented")
+}
+func (UnimplementedSchoolServiceServer) ListStudents(context.Context, *ListStudentsRequest) (*ListStudentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListStudents not implemented")
+}
+func (UnimplementedSchoolServiceServer) ListStudentsOfTeacher(context.Context, *ListStudentsOfTeacherRequest) (*ListStudentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListStudentsOfTeacher not implemented")
+}
+func (UnimplementedSchoolServiceServer) ListTeachersOfStudent(context.Context, *ListTeachersOfStudentRequest) (*ListTeachersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTeachersOfStudent not implemented")
+}
+func (UnimplementedSchoolServiceServer) mustEmbedUnimplementedSchoolServiceServer() {}
+
+// UnsafeSchoolServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SchoolServiceServer will
+// result in compilation errors.
+type UnsafeSchoolServiceServer interface {
+	mustEmbedUnimplementedSchoolServiceServer()
+}
+
+func RegisterSchoolServiceServer(s grpc.ServiceRegistrar, srv SchoolServiceServer) {
+	s.RegisterService(&SchoolService_ServiceDesc, srv)
+}
+
+func _SchoolService_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchoolServiceServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SchoolService_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchoolServiceServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SchoolService_CommonStudents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommonStudentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchoolServiceServer).CommonStudents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SchoolService_CommonStudents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchoolServiceServer).CommonStudents(ctx, req.(*CommonStudentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SchoolService_Suspend_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SuspendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchoolServiceServer).Suspend(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SchoolService_Suspend_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchoolServiceServer).Suspend(ctx, req.(*SuspendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SchoolService_RetrieveForNotifications_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetrieveForNotificationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchoolServiceServer).RetrieveForNotifications(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SchoolService_RetrieveForNotifications_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchoolServiceServer).RetrieveForNotifications(ctx, req.(*RetrieveForNotificationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SchoolService_ListTeachers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTeachersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchoolServiceServer).ListTeachers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SchoolService_ListTeachers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchoolServiceServer).ListTeachers(ctx, req.(*ListTeachersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SchoolService_ListStudents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListStudentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchoolServiceServer).ListStudents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SchoolService_ListStudents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchoolServiceServer).ListStudents(ctx, req.(*ListStudentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SchoolService_ListStudentsOfTeacher_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListStudentsOfTeacherRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchoolServiceServer).ListStudentsOfTeacher(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SchoolService_ListStudentsOfTeacher_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchoolServiceServer).ListStudentsOfTeacher(ctx, req.(*ListStudentsOfTeacherRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SchoolService_ListTeachersOfStudent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTeachersOfStudentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchoolServiceServer).ListTeachersOfStudent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SchoolService_ListTeachersOfStudent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchoolServiceServer).ListTeachersOfStudent(ctx, req.(*ListTeachersOfStudentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SchoolService_ServiceDesc is the grpc.ServiceDesc for SchoolService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SchoolService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "school.v1.SchoolService",
+	HandlerType: (*SchoolServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _SchoolService_Register_Handler,
+		},
+		{
+			MethodName: "CommonStudents",
+			Handler:    _SchoolService_CommonStudents_Handler,
+		},
+		{
+			MethodName: "Suspend",
+			Handler:    _SchoolService_Suspend_Handler,
+		},
+		{
+			MethodName: "RetrieveForNotifications",
+			Handler:    _SchoolService_RetrieveForNotifications_Handler,
+		},
+		{
+			MethodName: "ListTeachers",
+			Handler:    _SchoolService_ListTeachers_Handler,
+		},
+		{
+			MethodName: "ListStudents",
+			Handler:    _SchoolService_ListStudents_Handler,
+		},
+		{
+			MethodName: "ListStudentsOfTeacher",
+			Handler:    _SchoolService_ListStudentsOfTeacher_Handler,
+		},
+		{
+			MethodName: "ListTeachersOfStudent",
+			Handler:    _SchoolService_ListTeachersOfStudent_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "school.proto",
+}
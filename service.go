@@ -0,0 +1,769 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SchoolService owns the business rules of the school administration on top
+// of Storage, so the JSON API, the command line and anything else built on
+// it behave the same way. it knows nothing about how requests arrive
+type SchoolService struct {
+	store Storage
+
+	// lets webhooks be created for loopback and private addresses, for
+	// tests and local development
+	AllowPrivateWebhooks bool
+}
+
+func NewSchoolService(store Storage) *SchoolService {
+	return &SchoolService{
+		store: store,
+	}
+}
+
+// Read replicas
+
+// a store whose reads may lag behind its writes, e.g. because they go to a
+// read replica. Primary returns the store reading its own writes
+type primaryReader interface {
+	Primary() Storage
+}
+
+type readYourWritesKey struct{}
+
+// asks for the reads of a call to see every write made before it, at the
+// cost of not using a read replica or cache
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesKey{}, true)
+}
+
+func readsYourWrites(ctx context.Context) bool {
+	readYourWrites, _ := ctx.Value(readYourWritesKey{}).(bool)
+	return readYourWrites
+}
+
+// the store that lists, common students and exports are read from
+func (s *SchoolService) reader(ctx context.Context) Storage {
+	if primary, ok := s.store.(primaryReader); ok && readsYourWrites(ctx) {
+		return primary.Primary()
+	}
+	return s.store
+}
+
+// Registration
+
+// registers students to a teacher. the teacher, the students and the links
+// between them are created if they do not exist yet
+func (s *SchoolService) RegisterStudents(ctx context.Context, teacherEmail string, studentEmails []string) error {
+	teacherEmail, studentEmails = NormalizeEmail(teacherEmail), NormalizeEmails(studentEmails)
+
+	// check if the teacher exists in the database
+	exists, err := s.store.TeacherExists(teacherEmail)
+	if err != nil {
+		return err
+	}
+	// if entry does not exist, then i create a new entry
+	if !exists {
+		teacher := NewTeacher(teacherEmail)
+		err := s.store.CreateTeacher(teacher)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.createStudentsIfNotExist(studentEmails); err != nil {
+		return err
+	}
+
+	for _, studentEmail := range studentEmails {
+		// check if the students are already registered to the teacher
+		exists, err := s.store.TeacherStudentExists(teacherEmail, studentEmail)
+		if err != nil {
+			return err
+		}
+		// if entry does not exist, then i create a new entry
+		if !exists {
+			teacherstudent := NewTeacherStudent(teacherEmail, studentEmail)
+			entry := s.auditEntry(ctx, AuditActionRegister, studentEmail, map[string]string{"teacher": teacherEmail})
+			if err := s.store.CreateTeacherStudent(teacherstudent, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removes the registrations of students to a teacher, students that are not
+// registered to the teacher are skipped
+func (s *SchoolService) DeregisterStudents(ctx context.Context, teacherEmail string, studentEmails []string) error {
+	teacherEmail, studentEmails = NormalizeEmail(teacherEmail), NormalizeEmails(studentEmails)
+
+	exists, err := s.store.TeacherExists(teacherEmail)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("teacher does not exist")
+	}
+
+	for _, studentEmail := range studentEmails {
+		exists, err := s.store.TeacherStudentExists(teacherEmail, studentEmail)
+		if err != nil {
+			return err
+		}
+		if exists {
+			entry := s.auditEntry(ctx, AuditActionDeregister, studentEmail, map[string]string{"teacher": teacherEmail})
+			if err := s.store.DeleteTeacherStudent(teacherEmail, studentEmail, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *SchoolService) createStudentsIfNotExist(studentEmails []string) error {
+	for _, studentEmail := range studentEmails {
+		// check if the students exist in the database
+		exists, err := s.store.StudentExists(studentEmail)
+		if err != nil {
+			return err
+		}
+		// if entry does not exist, then i create a new entry
+		if !exists {
+			student := NewStudent(studentEmail)
+			err := s.store.CreateStudent(student)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// the students registered to every one of the teachers
+func (s *SchoolService) CommonStudents(ctx context.Context, teacherEmails []string) ([]string, error) {
+	return s.reader(ctx).GetCommonStudentsOfTeachers(NormalizeEmails(teacherEmails))
+}
+
+// Suspension
+
+func (s *SchoolService) SuspendStudent(ctx context.Context, studentEmail string) error {
+	studentEmail = NormalizeEmail(studentEmail)
+	return s.setStudentSuspended(studentEmail, true, s.auditEntry(ctx, AuditActionSuspend, studentEmail, nil))
+}
+
+func (s *SchoolService) UnsuspendStudent(ctx context.Context, studentEmail string) error {
+	studentEmail = NormalizeEmail(studentEmail)
+	return s.setStudentSuspended(studentEmail, false, s.auditEntry(ctx, AuditActionUnsuspend, studentEmail, nil))
+}
+
+func (s *SchoolService) setStudentSuspended(studentEmail string, suspended bool, entry *AuditEntry) error {
+	//check if student exists
+	exists, err := s.store.StudentExists(studentEmail)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return fmt.Errorf("student does not exist")
+	}
+
+	return s.store.UpdateStudentSuspendedState(studentEmail, suspended, entry)
+}
+
+// Notifications
+
+// matches an @mention of an email address, the email is the first submatch
+var mentionRegexp = regexp.MustCompile(`@([\w.%+-]+@[\w.-]+\.[a-zA-Z]{2,})`)
+
+// the emails @mentioned in a notification, in order of appearance and
+// normalized, so @StudentJon@gmail.com mentions studentjon@gmail.com
+func ParseMentions(notification string) []string {
+	mentions := []string{}
+	for _, match := range mentionRegexp.FindAllStringSubmatch(notification, -1) {
+		mentions = append(mentions, NormalizeEmail(match[1]))
+	}
+	return mentions
+}
+
+// the students who get a notification: every student registered to the
+// teacher plus the @mentioned students, leaving out suspended students
+func (s *SchoolService) NotificationRecipients(ctx context.Context, teacherEmail string, notification string) ([]string, error) {
+	teacherEmail = NormalizeEmail(teacherEmail)
+	var recipients []string
+	// get all students under teacher
+	students, _, err := s.store.GetStudentsAssignedToTeacher(teacherEmail, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// check if student is suspended
+	for _, student := range students {
+		isSuspended, err := s.store.IsStudentSuspended(student)
+		if err != nil {
+			return nil, err
+		}
+		if !isSuspended {
+			recipients = append(recipients, student)
+		}
+	}
+
+	for _, studentEmail := range ParseMentions(notification) {
+		// @mentioned emails that are not students are ignored
+		exists, err := s.store.StudentExists(studentEmail)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+
+		// check if student is suspended
+		isSuspended, err := s.store.IsStudentSuspended(studentEmail)
+		if err != nil {
+			return nil, err
+		}
+		if !isSuspended && !StringExistsInArray(studentEmail, recipients) {
+			recipients = append(recipients, studentEmail)
+		}
+	}
+
+	return recipients, nil
+}
+
+// sends a notification from the teacher and returns its recipients, see
+// NotificationRecipients. the notification is stored, which publishes a
+// NotificationCreated event, and recorded in the audit log
+func (s *SchoolService) SendNotification(ctx context.Context, teacherEmail string, notification string) ([]string, error) {
+	teacherEmail = NormalizeEmail(teacherEmail)
+	recipients, err := s.NotificationRecipients(ctx, teacherEmail, notification)
+	if err != nil {
+		return nil, err
+	}
+
+	// the store adds the notification_id
+	entry := s.auditEntry(ctx, AuditActionNotify, teacherEmail, map[string]any{
+		"notification": notification,
+		"recipients":   recipients,
+	})
+	if err := s.store.CreateNotification(NewNotification(teacherEmail, notification, recipients), entry); err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// Teachers and students
+
+func (s *SchoolService) AddTeacher(ctx context.Context, email string, name string) (*Teacher, error) {
+	email = NormalizeEmail(email)
+	if !IsValidEmail(email) {
+		return nil, fmt.Errorf("invalid teacher email %s", email)
+	}
+
+	exists, err := s.store.TeacherExists(email)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("teacher already exists")
+	}
+
+	teacher := NewTeacher(email)
+	teacher.Name = name
+	if err := s.store.CreateTeacher(teacher); err != nil {
+		return nil, err
+	}
+	return teacher, nil
+}
+
+func (s *SchoolService) GetTeacher(ctx context.Context, email string) (*Teacher, error) {
+	return s.store.GetTeacherByEmail(NormalizeEmail(email))
+}
+
+func (s *SchoolService) ListTeachers(ctx context.Context, opts ListOptions) ([]*Teacher, string, error) {
+	opts.EmailPrefix = strings.ToLower(opts.EmailPrefix)
+	return s.reader(ctx).GetTeachers(opts)
+}
+
+func (s *SchoolService) GetStudent(ctx context.Context, email string) (*Student, error) {
+	return s.store.GetStudentByEmail(NormalizeEmail(email))
+}
+
+func (s *SchoolService) ListStudents(ctx context.Context, opts ListOptions) ([]*Student, string, error) {
+	opts.EmailPrefix = strings.ToLower(opts.EmailPrefix)
+	return s.reader(ctx).GetStudents(opts)
+}
+
+func (s *SchoolService) StudentsOfTeacher(ctx context.Context, teacherEmail string, opts ListOptions) ([]*Student, string, error) {
+	teacherEmail = NormalizeEmail(teacherEmail)
+	opts.EmailPrefix = strings.ToLower(opts.EmailPrefix)
+	exists, err := s.store.TeacherExists(teacherEmail)
+	if err != nil {
+		return nil, "", err
+	}
+	if !exists {
+		return nil, "", fmt.Errorf("teacher does not exist")
+	}
+
+	return s.reader(ctx).GetStudentsOfTeacher(teacherEmail, opts)
+}
+
+func (s *SchoolService) TeachersOfStudent(ctx context.Context, studentEmail string, opts ListOptions) ([]*Teacher, string, error) {
+	studentEmail = NormalizeEmail(studentEmail)
+	opts.EmailPrefix = strings.ToLower(opts.EmailPrefix)
+	exists, err := s.store.StudentExists(studentEmail)
+	if err != nil {
+		return nil, "", err
+	}
+	if !exists {
+		return nil, "", fmt.Errorf("student does not exist")
+	}
+
+	return s.reader(ctx).GetTeachersOfStudent(studentEmail, opts)
+}
+
+// changes the fields of the profile that are set in the request
+func (s *SchoolService) UpdateTeacherProfile(ctx context.Context, email string, update *UpdateTeacherRequest) (*Teacher, error) {
+	teacher, err := s.store.GetTeacherByEmail(NormalizeEmail(email))
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		teacher.Name = *update.Name
+	}
+	if update.ContactPreference != nil {
+		if !IsValidContactPreference(*update.ContactPreference) {
+			return nil, fmt.Errorf("invalid contact preference %s", *update.ContactPreference)
+		}
+		teacher.ContactPreference = *update.ContactPreference
+	}
+	teacher.UpdatedAt = time.Now().UTC()
+
+	if err := s.store.UpdateTeacher(teacher); err != nil {
+		return nil, err
+	}
+	return teacher, nil
+}
+
+// changes the fields of the profile that are set in the request
+func (s *SchoolService) UpdateStudentProfile(ctx context.Context, email string, update *UpdateStudentRequest) (*Student, error) {
+	student, err := s.store.GetStudentByEmail(NormalizeEmail(email))
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		student.Name = *update.Name
+	}
+	if update.GradeLevel != nil {
+		if *update.GradeLevel < 0 {
+			return nil, fmt.Errorf("invalid grade level %d", *update.GradeLevel)
+		}
+		student.GradeLevel = update.GradeLevel
+	}
+	if update.ContactPreference != nil {
+		if !IsValidContactPreference(*update.ContactPreference) {
+			return nil, fmt.Errorf("invalid contact preference %s", *update.ContactPreference)
+		}
+		student.ContactPreference = *update.ContactPreference
+	}
+	student.UpdatedAt = time.Now().UTC()
+
+	if err := s.store.UpdateStudent(student); err != nil {
+		return nil, err
+	}
+	return student, nil
+}
+
+// Deletion
+
+// soft deletes a teacher, their registrations are hidden until they are
+// restored
+func (s *SchoolService) DeleteTeacher(ctx context.Context, email string) error {
+	email = NormalizeEmail(email)
+	if err := s.store.DeleteTeacher(email, s.auditEntry(ctx, AuditActionDelete, email, map[string]string{"kind": "teacher"})); err != nil {
+		return notFoundError(err, "teacher does not exist")
+	}
+	return nil
+}
+
+func (s *SchoolService) RestoreTeacher(ctx context.Context, email string) error {
+	email = NormalizeEmail(email)
+	if err := s.store.RestoreTeacher(email, s.auditEntry(ctx, AuditActionRestore, email, map[string]string{"kind": "teacher"})); err != nil {
+		return notFoundError(err, "no deleted teacher "+email)
+	}
+	return nil
+}
+
+// soft deletes a student, their registrations and class memberships are
+// hidden and they stop getting notifications until they are restored
+func (s *SchoolService) DeleteStudent(ctx context.Context, email string) error {
+	email = NormalizeEmail(email)
+	if err := s.store.DeleteStudent(email, s.auditEntry(ctx, AuditActionDelete, email, map[string]string{"kind": "student"})); err != nil {
+		return notFoundError(err, "student does not exist")
+	}
+	return nil
+}
+
+func (s *SchoolService) RestoreStudent(ctx context.Context, email string) error {
+	email = NormalizeEmail(email)
+	if err := s.store.RestoreStudent(email, s.auditEntry(ctx, AuditActionRestore, email, map[string]string{"kind": "student"})); err != nil {
+		return notFoundError(err, "no deleted student "+email)
+	}
+	return nil
+}
+
+// Email changes
+
+// changes the email of a teacher. the audit entry is recorded under the new
+// email with the old one in its payload, older entries keep the old email
+func (s *SchoolService) RenameTeacher(ctx context.Context, email string, newEmail string) (*Teacher, error) {
+	email, newEmail = NormalizeEmail(email), NormalizeEmail(newEmail)
+	if !IsValidEmail(newEmail) {
+		return nil, fmt.Errorf("invalid teacher email %s", newEmail)
+	}
+	if newEmail == email {
+		return nil, fmt.Errorf("new email is the same as the old one")
+	}
+
+	entry := s.auditEntry(ctx, AuditActionRename, newEmail, map[string]string{"kind": "teacher", "previous_email": email})
+	if err := s.store.RenameTeacher(email, newEmail, entry); err != nil {
+		return nil, notFoundError(err, "teacher does not exist")
+	}
+	return s.store.GetTeacherByEmail(newEmail)
+}
+
+// changes the email of a student, see RenameTeacher
+func (s *SchoolService) RenameStudent(ctx context.Context, email string, newEmail string) (*Student, error) {
+	email, newEmail = NormalizeEmail(email), NormalizeEmail(newEmail)
+	if !IsValidEmail(newEmail) {
+		return nil, fmt.Errorf("invalid student email %s", newEmail)
+	}
+	if newEmail == email {
+		return nil, fmt.Errorf("new email is the same as the old one")
+	}
+
+	entry := s.auditEntry(ctx, AuditActionRename, newEmail, map[string]string{"kind": "student", "previous_email": email})
+	if err := s.store.RenameStudent(email, newEmail, entry); err != nil {
+		return nil, notFoundError(err, "student does not exist")
+	}
+	return s.store.GetStudentByEmail(newEmail)
+}
+
+// Data subject requests
+
+// everything stored about a student, including a deleted one
+func (s *SchoolService) ExportStudentData(ctx context.Context, email string) (*StudentDataExport, error) {
+	email = NormalizeEmail(email)
+	export, err := s.store.GetStudentData(email)
+	if err != nil {
+		return nil, notFoundError(err, "student does not exist")
+	}
+
+	export.Suspensions = []*AuditEntry{}
+	for _, entry := range export.AuditEntries {
+		if NormalizeEmail(entry.Target) == email && (entry.Action == AuditActionSuspend || entry.Action == AuditActionUnsuspend) {
+			export.Suspensions = append(export.Suspensions, entry)
+		}
+	}
+	export.ExportedAt = time.Now().UTC()
+	return export, nil
+}
+
+// erases a student for good by replacing their email everywhere with an
+// anonymous one, which is returned. the erasure is recorded under the
+// anonymous email, so the log does not keep the erased one
+func (s *SchoolService) EraseStudent(ctx context.Context, email string) (string, error) {
+	email = NormalizeEmail(email)
+	// the store replaces the email in the entry along with the others
+	erased, err := s.store.EraseStudent(email, s.auditEntry(ctx, AuditActionErase, email, nil))
+	if err != nil {
+		return "", notFoundError(err, "student does not exist")
+	}
+	return erased, nil
+}
+
+// replaces the missing entry error of the store with one naming what is
+// missing
+func notFoundError(err error, message string) error {
+	if err.Error() == "entry does not exist" {
+		return errors.New(message)
+	}
+	return err
+}
+
+// Classes
+
+func (s *SchoolService) CreateClass(ctx context.Context, name string, kind string) (*Class, error) {
+	if name == "" {
+		return nil, fmt.Errorf("class name is required")
+	}
+	class := NewClass(name, kind)
+	if !IsValidClassKind(class.Kind) {
+		return nil, fmt.Errorf("invalid class kind %s", class.Kind)
+	}
+
+	exists, err := s.store.ClassExists(class.Name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("class already exists")
+	}
+
+	if err := s.store.CreateClass(class); err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+func (s *SchoolService) GetClass(ctx context.Context, name string) (*Class, error) {
+	return s.store.GetClassByName(name)
+}
+
+func (s *SchoolService) ListClasses(ctx context.Context, opts ListOptions) ([]*Class, string, error) {
+	return s.store.GetClasses(opts)
+}
+
+// renames the class and/or changes its kind, empty fields are not changed
+func (s *SchoolService) UpdateClass(ctx context.Context, name string, update *UpdateClassRequest) (*Class, error) {
+	class, err := s.store.GetClassByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != "" {
+		class.Name = update.Name
+	}
+	if update.Kind != "" {
+		if !IsValidClassKind(update.Kind) {
+			return nil, fmt.Errorf("invalid class kind %s", update.Kind)
+		}
+		class.Kind = update.Kind
+	}
+
+	if err := s.store.UpdateClass(name, class); err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+func (s *SchoolService) DeleteClass(ctx context.Context, name string) error {
+	return s.store.DeleteClass(name)
+}
+
+func (s *SchoolService) StudentsInClass(ctx context.Context, name string, opts ListOptions) ([]string, string, error) {
+	opts.EmailPrefix = strings.ToLower(opts.EmailPrefix)
+	class, err := s.store.GetClassByName(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return s.store.GetStudentsInClass(class.ID, opts)
+}
+
+// adds students to a class, creating the students that do not exist yet
+func (s *SchoolService) AddStudentsToClass(ctx context.Context, name string, studentEmails []string) error {
+	studentEmails = NormalizeEmails(studentEmails)
+
+	class, err := s.store.GetClassByName(name)
+	if err != nil {
+		return err
+	}
+
+	if err := s.createStudentsIfNotExist(studentEmails); err != nil {
+		return err
+	}
+
+	for _, studentEmail := range studentEmails {
+		exists, err := s.store.ClassStudentExists(class.ID, studentEmail)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := s.store.CreateClassStudent(NewClassStudent(class.ID, studentEmail)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *SchoolService) RemoveStudentFromClass(ctx context.Context, name string, studentEmail string) error {
+	class, err := s.store.GetClassByName(name)
+	if err != nil {
+		return err
+	}
+
+	return s.store.DeleteClassStudent(class.ID, NormalizeEmail(studentEmail))
+}
+
+// registers every student currently in the class to the teacher
+func (s *SchoolService) RegisterClassToTeacher(ctx context.Context, name string, teacherEmail string) error {
+	students, _, err := s.StudentsInClass(ctx, name, ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	return s.RegisterStudents(ctx, teacherEmail, students)
+}
+
+// Rosters
+
+// the registrations created by an import are recorded in the audit log
+func (s *SchoolService) ImportRoster(ctx context.Context, r io.Reader, dryRun bool) (*ImportReport, error) {
+	return ImportRosterCSV(s.store, r, dryRun, func(registration TeacherStudentPair) *AuditEntry {
+		return s.auditEntry(ctx, AuditActionRegister, registration.StudentEmail,
+			map[string]string{"teacher": registration.TeacherEmail, "source": "import"})
+	})
+}
+
+func (s *SchoolService) ExportRoster(ctx context.Context, w io.Writer, format string) error {
+	return ExportRoster(s.reader(ctx), w, format)
+}
+
+// Idempotency
+
+// how long the response to a request with an idempotency key is replayed
+const idempotencyKeyTTL = 24 * time.Hour
+
+// how long a request holds its idempotency key before it has a response.
+// a request that died while holding it, e.g. because the server stopped,
+// leaves the key to a retry after this
+const idempotencyKeyLease = 5 * time.Minute
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still being handled")
+)
+
+// claims an idempotency key for a request identified by requestHash. when
+// the same request was already handled it returns the stored record so its
+// response can be replayed. when it returns nil the caller handles the
+// request and then calls CompleteIdempotentRequest or ReleaseIdempotencyKey
+func (s *SchoolService) BeginIdempotentRequest(ctx context.Context, key string, requestHash string) (*IdempotencyRecord, error) {
+	record := NewIdempotencyRecord(key, requestHash)
+	reserved, err := s.store.ReserveIdempotencyKey(record, record.CreatedAt.Add(-idempotencyKeyTTL), record.CreatedAt.Add(-idempotencyKeyLease))
+	if err != nil {
+		return nil, err
+	}
+	if reserved {
+		return nil, nil
+	}
+
+	record, err = s.store.GetIdempotencyRecord(key)
+	if err != nil {
+		return nil, err
+	}
+	if record.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if record.StatusCode == 0 {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return record, nil
+}
+
+// stores the response to replay for the key
+func (s *SchoolService) CompleteIdempotentRequest(ctx context.Context, record *IdempotencyRecord) error {
+	return s.store.SaveIdempotencyResponse(record)
+}
+
+// frees the key so the request can be retried, e.g. after it failed
+func (s *SchoolService) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return s.store.DeleteIdempotencyKey(key)
+}
+
+// Audit log
+
+// an entry for an action taken by the actor of ctx. payload holds the
+// details of the action and is stored as JSON
+func (s *SchoolService) auditEntry(ctx context.Context, action string, target string, payload any) *AuditEntry {
+	info := auditInfoFromContext(ctx)
+	var raw json.RawMessage
+	if payload != nil {
+		raw, _ = json.Marshal(payload)
+	}
+	entry := NewAuditEntry(info.Actor, action, target, raw, info.RequestID)
+	entry.ClaimedActor = info.ClaimedActor
+	return entry
+}
+
+// returned when the audit entries of a change could not be written. they
+// are written in the transaction of the change, so it was not made either
+var ErrAuditNotRecorded = errors.New("the change was not made because it could not be recorded in the audit log")
+
+func (s *SchoolService) AuditLog(ctx context.Context, filter AuditFilter) ([]*AuditEntry, string, error) {
+	filter.Target = NormalizeEmail(filter.Target)
+	return s.reader(ctx).GetAuditEntries(filter)
+}
+
+// Webhooks
+
+// how many deliveries of a webhook are listed
+const webhookDeliveriesShown = 100
+
+// subscribes a URL to events, a secret is generated when none is given.
+// the URL must not resolve to a loopback, private or link-local address. the
+// returned subscription is the only one that shows the secret
+func (s *SchoolService) CreateWebhook(ctx context.Context, rawURL string, eventTypes []string, secret string) (*WebhookSubscription, error) {
+	if err := validateWebhookURL(ctx, rawURL, s.AllowPrivateWebhooks); err != nil {
+		return nil, err
+	}
+	for _, eventType := range eventTypes {
+		if !IsValidEventType(eventType) {
+			return nil, fmt.Errorf("invalid event type %s", eventType)
+		}
+	}
+	if secret == "" {
+		secret = newRequestID()
+	}
+
+	webhook := NewWebhookSubscription(rawURL, eventTypes, secret)
+	if err := s.store.CreateWebhook(webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *SchoolService) ListWebhooks(ctx context.Context) ([]*WebhookSubscription, error) {
+	webhooks, err := s.store.GetWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	return webhooks, nil
+}
+
+func (s *SchoolService) GetWebhook(ctx context.Context, id int64) (*WebhookSubscription, error) {
+	webhook, err := s.store.GetWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (s *SchoolService) DeleteWebhook(ctx context.Context, id int64) error {
+	return s.store.DeleteWebhook(id)
+}
+
+// the latest delivery attempts of a webhook, newest first
+func (s *SchoolService) WebhookDeliveries(ctx context.Context, id int64) ([]*WebhookDelivery, error) {
+	if _, err := s.store.GetWebhook(id); err != nil {
+		return nil, err
+	}
+	return s.store.GetWebhookDeliveries(id, webhookDeliveriesShown)
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// an in-memory Storage with just the methods SchoolService uses for
+// registration, suspension and notifications. calling any other method
+// panics on the nil embedded Storage
+type fakeStore struct {
+	Storage
+	teachers       map[string]*Teacher
+	students       map[string]*Student
+	teacherStudent map[string][]string
+	deleted        map[string]*Student
+	idempotency    map[string]*IdempotencyRecord
+	audit          []*AuditEntry
+	auditErr       error // fails audited changes, which are not made, if not nil
+	notifications  []*Notification
+
+	// webhook deliveries are logged from the dispatcher's goroutines
+	mu         sync.Mutex
+	webhooks   []*WebhookSubscription
+	deliveries []*WebhookDelivery
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		teachers:       map[string]*Teacher{},
+		students:       map[string]*Student{},
+		teacherStudent: map[string][]string{},
+		deleted:        map[string]*Student{},
+		idempotency:    map[string]*IdempotencyRecord{},
+	}
+}
+
+func (f *fakeStore) CreateTeacher(teacher *Teacher) error {
+	if _, ok := f.teachers[teacher.Email]; ok {
+		return fmt.Errorf("duplicate teacher %s", teacher.Email)
+	}
+	f.teachers[teacher.Email] = teacher
+	return nil
+}
+
+func (f *fakeStore) TeacherExists(email string) (bool, error) {
+	_, ok := f.teachers[email]
+	return ok, nil
+}
+
+func (f *fakeStore) CreateStudent(student *Student) error {
+	if _, ok := f.students[student.Email]; ok {
+		return fmt.Errorf("duplicate student %s", student.Email)
+	}
+	f.students[student.Email] = student
+	return nil
+}
+
+func (f *fakeStore) StudentExists(email string) (bool, error) {
+	_, ok := f.students[email]
+	return ok, nil
+}
+
+func (f *fakeStore) IsStudentSuspended(email string) (bool, error) {
+	student, ok := f.students[email]
+	return ok && student.IsSuspended, nil
+}
+
+// makes a change and records its audit entries, or does neither, like the
+// transactions of PostgresStore
+func (f *fakeStore) audited(audit []*AuditEntry, change func() error) error {
+	if f.auditErr != nil && len(audit) > 0 {
+		return fmt.Errorf("%w: %w", ErrAuditNotRecorded, f.auditErr)
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	return f.CreateAuditEntries(audit)
+}
+
+func (f *fakeStore) UpdateStudentSuspendedState(email string, suspended bool, audit ...*AuditEntry) error {
+	return f.audited(audit, func() error {
+		if student, ok := f.students[email]; ok {
+			student.IsSuspended = suspended
+		}
+		return nil
+	})
+}
+
+func (f *fakeStore) DeleteStudent(email string, audit ...*AuditEntry) error {
+	return f.audited(audit, func() error {
+		student, ok := f.students[email]
+		if !ok {
+			return fmt.Errorf("entry does not exist")
+		}
+		delete(f.students, email)
+		f.deleted[email] = student
+		return nil
+	})
+}
+
+func (f *fakeStore) RestoreStudent(email string, audit ...*AuditEntry) error {
+	return f.audited(audit, func() error {
+		student, ok := f.deleted[email]
+		if !ok {
+			return fmt.Errorf("entry does not exist")
+		}
+		delete(f.deleted, email)
+		f.students[email] = student
+		return nil
+	})
+}
+
+func (f *fakeStore) RenameStudent(email string, newEmail string, audit ...*AuditEntry) error {
+	return f.audited(audit, func() error {
+		student, ok := f.students[email]
+		if !ok {
+			return fmt.Errorf("entry does not exist")
+		}
+		if _, taken := f.students[newEmail]; taken {
+			return ErrEmailTaken
+		}
+		delete(f.students, email)
+		student.Email = newEmail
+		f.students[newEmail] = student
+		return nil
+	})
+}
+
+func (f *fakeStore) GetStudentByEmail(email string) (*Student, error) {
+	student, ok := f.students[email]
+	if !ok {
+		return nil, fmt.Errorf("entry does not exist")
+	}
+	return student, nil
+}
+
+func (f *fakeStore) GetStudentData(email string) (*StudentDataExport, error) {
+	student, ok := f.students[email]
+	if !ok {
+		return nil, fmt.Errorf("entry does not exist")
+	}
+	export := &StudentDataExport{Student: student}
+	for _, entry := range f.audit {
+		if entry.Target == email || entry.Actor == email {
+			export.AuditEntries = append(export.AuditEntries, entry)
+		}
+	}
+	return export, nil
+}
+
+func (f *fakeStore) CreateTeacherStudent(teacherstudent *TeacherStudent, audit ...*AuditEntry) error {
+	return f.audited(audit, func() error {
+		f.teacherStudent[teacherstudent.TeacherEmail] = append(f.teacherStudent[teacherstudent.TeacherEmail], teacherstudent.StudentEmail)
+		return nil
+	})
+}
+
+func (f *fakeStore) TeacherStudentExists(teacherEmail string, studentEmail string) (bool, error) {
+	return StringExistsInArray(studentEmail, f.teacherStudent[teacherEmail]), nil
+}
+
+func (f *fakeStore) GetStudentsAssignedToTeacher(teacherEmail string, opts ListOptions) ([]string, string, error) {
+	students := append([]string{}, f.teacherStudent[teacherEmail]...)
+	sort.Strings(students)
+	return students, "", nil
+}
+
+func (f *fakeStore) GetCommonStudentsOfTeachers(teacherEmails []string) ([]string, error) {
+	if len(teacherEmails) == 0 {
+		return nil, fmt.Errorf("no teacher emails provided")
+	}
+
+	common := []string{}
+	for _, studentEmail := range f.teacherStudent[teacherEmails[0]] {
+		inAll := true
+		for _, teacherEmail := range teacherEmails[1:] {
+			inAll = inAll && StringExistsInArray(studentEmail, f.teacherStudent[teacherEmail])
+		}
+		if inAll {
+			common = append(common, studentEmail)
+		}
+	}
+	sort.Strings(common)
+	return common, nil
+}
+
+func (f *fakeStore) ReserveIdempotencyKey(record *IdempotencyRecord, expiredBefore time.Time, abandonedBefore time.Time) (bool, error) {
+	if existing, ok := f.idempotency[record.Key]; ok && !existing.CreatedAt.Before(expiredBefore) &&
+		(existing.StatusCode != 0 || !existing.CreatedAt.Before(abandonedBefore)) {
+		return false, nil
+	}
+	f.idempotency[record.Key] = record
+	return true, nil
+}
+
+func (f *fakeStore) GetIdempotencyRecord(key string) (*IdempotencyRecord, error) {
+	record, ok := f.idempotency[key]
+	if !ok {
+		return nil, fmt.Errorf("entry does not exist")
+	}
+	return record, nil
+}
+
+func (f *fakeStore) SaveIdempotencyResponse(record *IdempotencyRecord) error {
+	existing, ok := f.idempotency[record.Key]
+	if !ok {
+		return fmt.Errorf("entry does not exist")
+	}
+	existing.StatusCode, existing.ContentType, existing.Body = record.StatusCode, record.ContentType, record.Body
+	return nil
+}
+
+func (f *fakeStore) DeleteIdempotencyKey(key string) error {
+	delete(f.idempotency, key)
+	return nil
+}
+
+func (f *fakeStore) DeleteTeacherStudent(teacherEmail string, studentEmail string, audit ...*AuditEntry) error {
+	return f.audited(audit, func() error {
+		students := f.teacherStudent[teacherEmail]
+		for i, email := range students {
+			if email == studentEmail {
+				f.teacherStudent[teacherEmail] = append(students[:i:i], students[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("entry does not exist")
+	})
+}
+
+func (f *fakeStore) CreateAuditEntries(entries []*AuditEntry) error {
+	if f.auditErr != nil {
+		return fmt.Errorf("%w: %w", ErrAuditNotRecorded, f.auditErr)
+	}
+	for _, entry := range entries {
+		entry.ID = int64(len(f.audit) + 1)
+		f.audit = append(f.audit, entry)
+	}
+	return nil
+}
+
+func (f *fakeStore) CreateNotification(notification *Notification, audit ...*AuditEntry) error {
+	return f.audited(audit, func() error {
+		notification.ID = int64(len(f.notifications) + 1)
+		f.notifications = append(f.notifications, notification)
+		return setNotificationID(audit, notification.ID)
+	})
+}
+
+func (f *fakeStore) CreateWebhook(webhook *WebhookSubscription) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	webhook.ID = int64(len(f.webhooks) + 1)
+	f.webhooks = append(f.webhooks, webhook)
+	return nil
+}
+
+func (f *fakeStore) GetWebhooks() ([]*WebhookSubscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	webhooks := []*WebhookSubscription{}
+	for _, webhook := range f.webhooks {
+		copied := *webhook
+		webhooks = append(webhooks, &copied)
+	}
+	return webhooks, nil
+}
+
+func (f *fakeStore) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delivery.ID = int64(len(f.deliveries) + 1)
+	f.deliveries = append(f.deliveries, delivery)
+	return nil
+}
+
+func TestParseMentions(t *testing.T) {
+	mentions := ParseMentions("Hello students! @studentagnes@gmail.com @studentmiche@gmail.com, and teacherken@gmail.com")
+	expected := []string{"studentagnes@gmail.com", "studentmiche@gmail.com"}
+
+	if !reflect.DeepEqual(mentions, expected) {
+		t.Errorf("unexpected mentions: got %v, want %v", mentions, expected)
+	}
+}
+
+func TestServiceRegisterStudents(t *testing.T) {
+	store := newFakeStore()
+	service := NewSchoolService(store)
+	ctx := context.Background()
+
+	// registering twice must not create anything twice
+	for i := 0; i < 2; i++ {
+		err := service.RegisterStudents(ctx, "teacherken@gmail.com", []string{"studentjon@gmail.com", "studenthon@gmail.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(store.teachers) != 1 || len(store.students) != 2 {
+		t.Errorf("unexpected entries: %d teachers and %d students", len(store.teachers), len(store.students))
+	}
+
+	students, _, _ := store.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	expected := []string{"studenthon@gmail.com", "studentjon@gmail.com"}
+	if !reflect.DeepEqual(students, expected) {
+		t.Errorf("unexpected students registered: got %v, want %v", students, expected)
+	}
+}
+
+func TestServiceEmailCase(t *testing.T) {
+	store := newFakeStore()
+	service := NewSchoolService(store)
+	ctx := context.Background()
+
+	if err := service.RegisterStudents(ctx, "TeacherKen@gmail.com", []string{"StudentJon@gmail.com", " studenthon@gmail.com "}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RegisterStudents(ctx, "teacherken@gmail.com", []string{"studentjon@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RegisterStudents(ctx, "teacherjoe@gmail.com", []string{"studentagnes@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.teachers) != 2 || len(store.students) != 3 {
+		t.Errorf("unexpected entries: %d teachers and %d students", len(store.teachers), len(store.students))
+	}
+
+	if err := service.SuspendStudent(ctx, "STUDENTHON@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := service.NotificationRecipients(ctx, "TEACHERKEN@gmail.com", "Hello @StudentAgnes@Gmail.com @StudentJon@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"studentjon@gmail.com", "studentagnes@gmail.com"}
+	if !reflect.DeepEqual(recipients, expected) {
+		t.Errorf("unexpected recipients: got %v, want %v", recipients, expected)
+	}
+}
+
+func TestServiceSuspendMissingStudent(t *testing.T) {
+	service := NewSchoolService(newFakeStore())
+
+	if err := service.SuspendStudent(context.Background(), "nobody@gmail.com"); err == nil {
+		t.Errorf("expected an error when suspending a student that does not exist")
+	}
+}
+
+func TestServiceDeleteAndRestoreStudent(t *testing.T) {
+	store := newFakeStore()
+	service := NewSchoolService(store)
+	ctx := WithAuditInfo(context.Background(), "admin", "", "req-1")
+
+	if err := service.RegisterStudents(ctx, "teacherken@gmail.com", []string{"studentjon@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteStudent(ctx, "studentjon@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteStudent(ctx, "studentjon@gmail.com"); err == nil || err.Error() != "student does not exist" {
+		t.Errorf("deleting twice: got error %v, want student does not exist", err)
+	}
+	// a deleted student cannot be suspended
+	if err := service.SuspendStudent(ctx, "studentjon@gmail.com"); err == nil {
+		t.Errorf("expected an error when suspending a deleted student")
+	}
+	if err := service.RestoreStudent(ctx, "studentjon@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RestoreStudent(ctx, "studentjon@gmail.com"); err == nil {
+		t.Errorf("expected an error when restoring a student that is not deleted")
+	}
+
+	last := store.audit[len(store.audit)-2:]
+	if last[0].Action != AuditActionDelete || last[1].Action != AuditActionRestore || last[1].Target != "studentjon@gmail.com" {
+		t.Errorf("got audit entries %+v, want a delete and a restore", last)
+	}
+}
+
+func TestServiceRenameStudent(t *testing.T) {
+	store := newFakeStore()
+	router := NewAPIServer(":0", store).Router()
+	service := NewSchoolService(store)
+	if err := service.RegisterStudents(context.Background(), "teacherken@gmail.com", []string{"studentjon@gmail.com", "studenthon@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rename := func(email string, newEmail string) *httptest.ResponseRecorder {
+		body := strings.NewReader(`{"email": "` + newEmail + `"}`)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/students/"+email+"/rename", body))
+		return rr
+	}
+
+	if rr := rename("studentjon@gmail.com", "jon@school.edu"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "jon@school.edu") {
+		t.Errorf("got %d %s, want 200 with the student", rr.Code, rr.Body.String())
+	}
+	if rr := rename("jon@school.edu", "studenthon@gmail.com"); rr.Code != http.StatusConflict {
+		t.Errorf("renaming to a taken email: got %d, want 409", rr.Code)
+	}
+	if rr := rename("jon@school.edu", "not an email"); rr.Code != http.StatusBadRequest {
+		t.Errorf("renaming to an invalid email: got %d, want 400", rr.Code)
+	}
+
+	last := store.audit[len(store.audit)-1]
+	if last.Action != AuditActionRename || last.Target != "jon@school.edu" || !strings.Contains(string(last.Payload), "studentjon@gmail.com") {
+		t.Errorf("got audit entry %+v, want the rename under the new email", last)
+	}
+}
+
+func TestServiceExportStudentData(t *testing.T) {
+	store := newFakeStore()
+	service := NewSchoolService(store)
+	ctx := context.Background()
+
+	if err := service.RegisterStudents(ctx, "teacherken@gmail.com", []string{"studentjon@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SuspendStudent(ctx, "studentjon@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.UnsuspendStudent(ctx, "studentjon@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := service.ExportStudentData(ctx, "studentjon@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.AuditEntries) != 3 || len(export.Suspensions) != 2 || export.Suspensions[1].Action != AuditActionUnsuspend {
+		t.Errorf("got %d audit entries and suspensions %+v, want 3 entries and a suspension and unsuspension",
+			len(export.AuditEntries), export.Suspensions)
+	}
+	if _, err := service.ExportStudentData(ctx, "nobody@gmail.com"); err == nil || err.Error() != "student does not exist" {
+		t.Errorf("got error %v, want student does not exist", err)
+	}
+}
+
+func TestServiceNotificationRecipients(t *testing.T) {
+	store := newFakeStore()
+	service := NewSchoolService(store)
+	ctx := context.Background()
+
+	if err := service.RegisterStudents(ctx, "teacherken@gmail.com", []string{"studentjon@gmail.com", "studenthon@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.RegisterStudents(ctx, "teacherjoe@gmail.com", []string{"studentagnes@gmail.com", "studentmiche@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SuspendStudent(ctx, "studentjon@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SuspendStudent(ctx, "studentmiche@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// suspended students are left out even when mentioned, unknown mentions
+	// are ignored and a mentioned student of the teacher is only listed once
+	recipients, err := service.NotificationRecipients(ctx, "teacherken@gmail.com",
+		"Hello @studentagnes@gmail.com @studentmiche@gmail.com @studenthon@gmail.com @nobody@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"studenthon@gmail.com", "studentagnes@gmail.com"}
+	if !reflect.DeepEqual(recipients, expected) {
+		t.Errorf("unexpected recipients: got %v, want %v", recipients, expected)
+	}
+}
+
+func TestServiceAuditLog(t *testing.T) {
+	store := newFakeStore()
+	service := NewSchoolService(store)
+	ctx := WithAuditInfo(context.Background(), "teacherken@gmail.com", "", "req-1")
+
+	if err := service.RegisterStudents(ctx, "teacherken@gmail.com", []string{"studentjon@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	// registering again creates nothing, so nothing is recorded
+	if err := service.RegisterStudents(ctx, "teacherken@gmail.com", []string{"studentjon@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SuspendStudent(ctx, "studentjon@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.UnsuspendStudent(ctx, "studentjon@gmail.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SendNotification(ctx, "teacherken@gmail.com", "Hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeregisterStudents(ctx, "teacherken@gmail.com", []string{"studentjon@gmail.com", "nobody@gmail.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	actions := []string{}
+	for _, entry := range store.audit {
+		actions = append(actions, entry.Action+" "+entry.Target)
+		if entry.Actor != "teacherken@gmail.com" || entry.RequestID != "req-1" {
+			t.Errorf("unexpected actor %q or request ID %q", entry.Actor, entry.RequestID)
+		}
+	}
+	expected := []string{
+		"register studentjon@gmail.com",
+		"suspend studentjon@gmail.com",
+		"unsuspend studentjon@gmail.com",
+		"notify teacherken@gmail.com",
+		"deregister studentjon@gmail.com",
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("unexpected audit log: got %v, want %v", actions, expected)
+	}
+	// the store sets the id of the notification
+	if payload := string(store.audit[3].Payload); !strings.Contains(payload, `"notification_id":1`) {
+		t.Errorf("got notify payload %s, want the notification id", payload)
+	}
+}
+
+// the actor is where the request came from, X-Actor is only kept as a claim
+func TestServiceAuditActor(t *testing.T) {
+	store := newFakeStore()
+	store.students["studentjon@gmail.com"] = NewStudent("studentjon@gmail.com")
+	router := NewAPIServer(":0", store).Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/suspend", strings.NewReader(`{"student": "studentjon@gmail.com"}`))
+	req.Header.Set(actorHeader, "admin")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("got %d %s, want 204", rr.Code, rr.Body.String())
+	}
+
+	if len(store.audit) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(store.audit))
+	}
+	// httptest requests come from 192.0.2.1
+	if entry := store.audit[0]; entry.Actor != "http:192.0.2.1" || entry.ClaimedActor != "admin" {
+		t.Errorf("got actor %q claiming %q, want http:192.0.2.1 claiming admin", entry.Actor, entry.ClaimedActor)
+	}
+}
+
+// the change and its audit entry are made together or not at all
+func TestServiceAuditNotRecorded(t *testing.T) {
+	store := newFakeStore()
+	store.students["studentjon@gmail.com"] = NewStudent("studentjon@gmail.com")
+	store.auditErr = fmt.Errorf("disk full")
+	router := NewAPIServer(":0", store).Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/suspend", strings.NewReader(`{"student": "studentjon@gmail.com"}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), ErrAuditNotRecorded.Error()) {
+		t.Errorf("got %d %s, want 500 saying the audit entry was not recorded", rr.Code, rr.Body.String())
+	}
+	if suspended, _ := store.IsStudentSuspended("studentjon@gmail.com"); suspended {
+		t.Errorf("the student was suspended without an audit entry")
+	}
+}
+
+// an import that fails after committing a batch
+type partialImportStore struct {
+	*fakeStore
+}
+
+func (s partialImportStore) ImportRoster(rows []RosterRow, dryRun bool, audit func(TeacherStudentPair) *AuditEntry) (*ImportReport, error) {
+	report := NewImportReport(dryRun)
+	report.Rows = 1
+	report.NewRegistrations = append(report.NewRegistrations, TeacherStudentPair{TeacherEmail: rows[0].TeacherEmail, StudentEmail: rows[0].StudentEmail})
+	return report, fmt.Errorf("rows 2 to 2 were not imported: %w", ErrDatabaseUnavailable)
+}
+
+func TestServiceImportRosterPartialReport(t *testing.T) {
+	router := NewAPIServer(":0", partialImportStore{newFakeStore()}).Router()
+
+	body := strings.NewReader("teacherken@gmail.com,studentjon@gmail.com\nteacherken@gmail.com,studenthon@gmail.com\n")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/import", body))
+
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("got %d, want 503 with Retry-After", rr.Code)
+	}
+	for _, expected := range []string{`"rows":1`, "studentjon@gmail.com", "rows 2 to 2 were not imported"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("got %s, want the report of the imported batch and the error", rr.Body.String())
+		}
+	}
+}
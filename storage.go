@@ -1,41 +1,103 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/lib/pq"
 )
 
+// the methods that change data take the audit entries recording the change
+// and write them in its transaction, so neither is stored without the other
 type Storage interface {
 	CreateTeacher(*Teacher) error
-	GetTeachers() ([]*Teacher, error)
+	GetTeachers(ListOptions) ([]*Teacher, string, error)
+	UpdateTeacher(*Teacher) error
 	GetTeacherByEmail(string) (*Teacher, error)
 	TeacherExists(string) (bool, error)
+	DeleteTeacher(string, ...*AuditEntry) error
+	RestoreTeacher(string, ...*AuditEntry) error
+	RenameTeacher(string, string, ...*AuditEntry) error
 
 	CreateStudent(*Student) error
-	UpdateStudentSuspendedState(string, bool) error
-	GetStudents() ([]*Student, error)
+	UpdateStudent(*Student) error
+	UpdateStudentSuspendedState(string, bool, ...*AuditEntry) error
+	GetStudents(ListOptions) ([]*Student, string, error)
 	GetStudentByEmail(string) (*Student, error)
 	StudentExists(string) (bool, error)
 	IsStudentSuspended(string) (bool, error)
+	DeleteStudent(string, ...*AuditEntry) error
+	RestoreStudent(string, ...*AuditEntry) error
+	RenameStudent(string, string, ...*AuditEntry) error
+	GetStudentData(string) (*StudentDataExport, error)
+	EraseStudent(string, ...*AuditEntry) (string, error)
 
-	CreateTeacherStudent(*TeacherStudent) error
+	CreateTeacherStudent(*TeacherStudent, ...*AuditEntry) error
 	GetTeacherStudentByEmail(string, string) (*TeacherStudent, error)
-	GetStudentsAssignedToTeacher(string) ([]string, error)
+	GetStudentsAssignedToTeacher(string, ListOptions) ([]string, string, error)
+	GetStudentsOfTeacher(string, ListOptions) ([]*Student, string, error)
+	GetTeachersOfStudent(string, ListOptions) ([]*Teacher, string, error)
 	TeacherStudentExists(string, string) (bool, error)
+	DeleteTeacherStudent(string, string, ...*AuditEntry) error
 
 	GetCommonStudentsOfTeachers([]string) ([]string, error)
+
+	CreateClass(*Class) error
+	UpdateClass(string, *Class) error
+	DeleteClass(string) error
+	GetClasses(ListOptions) ([]*Class, string, error)
+	GetClassByName(string) (*Class, error)
+	ClassExists(string) (bool, error)
+
+	CreateClassStudent(*ClassStudent) error
+	DeleteClassStudent(int, string) error
+	GetStudentsInClass(int, ListOptions) ([]string, string, error)
+	ClassStudentExists(int, string) (bool, error)
+
+	ImportRoster([]RosterRow, bool, func(TeacherStudentPair) *AuditEntry) (*ImportReport, error)
+	ExportRoster(func(*RosterRecord) error) error
+
+	ReserveIdempotencyKey(record *IdempotencyRecord, expiredBefore time.Time, abandonedBefore time.Time) (bool, error)
+	GetIdempotencyRecord(string) (*IdempotencyRecord, error)
+	SaveIdempotencyResponse(*IdempotencyRecord) error
+	DeleteIdempotencyKey(string) error
+
+	CreateAuditEntries([]*AuditEntry) error
+	GetAuditEntries(AuditFilter) ([]*AuditEntry, string, error)
+
+	CreateNotification(*Notification, ...*AuditEntry) error
+
+	CreateWebhook(*WebhookSubscription) error
+	GetWebhooks() ([]*WebhookSubscription, error)
+	GetWebhook(int64) (*WebhookSubscription, error)
+	DeleteWebhook(int64) error
+	CreateWebhookDelivery(*WebhookDelivery) error
+	GetWebhookDeliveries(int64, int) ([]*WebhookDelivery, error)
 }
 
 type PostgresStore struct {
 	db     *sql.DB
 	dbPool *pgxpool.Pool
+	events EventPublisher
+
+	// lists, common students and exports are read from the replica if there
+	// is one
+	replicaPool *pgxpool.Pool
+	// unix nanoseconds until which reads skip the replica after it failed
+	replicaDownUntil atomic.Int64
 }
 
 func NewPostgresStore() (*PostgresStore, error) {
@@ -61,6 +123,30 @@ func NewPostgresStore() (*PostgresStore, error) {
 	// Set the maximum number of connections in the pool
 	poolConfig.MaxConns = 3
 
+	store, err := NewPostgresStoreWithConfig(poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if replicaConnStr, exists := os.LookupEnv("POSTGRESQL_REPLICA_CONNECTION_STRING"); exists {
+		replicaConfig, err := pgxpool.ParseConfig(replicaConnStr)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		replicaConfig.MaxConns = 3
+
+		if err := store.ConnectReplica(replicaConfig); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// connects to the database described by poolConfig, e.g. a test database
+func NewPostgresStoreWithConfig(poolConfig *pgxpool.Config) (*PostgresStore, error) {
 	// Create the database connection pool
 	dbPool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
@@ -69,22 +155,157 @@ func NewPostgresStore() (*PostgresStore, error) {
 
 	return &PostgresStore{
 		dbPool: dbPool,
+		events: nopPublisher{},
 	}, nil
 }
 
+// sets where the store publishes its events, they are dropped by default
+func (s *PostgresStore) SetEventPublisher(events EventPublisher) {
+	s.events = events
+}
+
+// connects to a read replica of the database, which the read-only queries
+// of lists, common students and exports use from then on
+func (s *PostgresStore) ConnectReplica(poolConfig *pgxpool.Config) error {
+	replicaPool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return err
+	}
+
+	s.replicaPool = replicaPool
+	return nil
+}
+
+// the store without its replica, for reads that must see the writes made
+// just before them
+func (s *PostgresStore) Primary() Storage {
+	if s.replicaPool == nil {
+		return s
+	}
+	return &PostgresStore{dbPool: s.dbPool, events: s.events}
+}
+
+func (s *PostgresStore) Close() {
+	if s.replicaPool != nil {
+		s.replicaPool.Close()
+	}
+	s.dbPool.Close()
+}
+
+// replica reads
+
+// how long reads skip a replica that failed before trying it again
+const replicaRetryAfter = 5 * time.Second
+
+// an error of a replica read that must not be retried on the primary
+type finalReadError struct {
+	err error
+}
+
+func (e finalReadError) Error() string {
+	return e.err.Error()
+}
+
+// runs a read-only query on the replica and hands its rows to collect. the
+// query runs on the primary instead when there is no replica, when the
+// replica failed recently, or when it fails now, so collect must start over
+// every time it is called
+func (s *PostgresStore) queryRead(query string, args []any, collect func(pgx.Rows) error) error {
+	if s.replicaPool != nil && time.Now().UnixNano() >= s.replicaDownUntil.Load() {
+		err := queryPool(s.replicaPool, query, args, collect)
+		var final finalReadError
+		if errors.As(err, &final) {
+			return final.err
+		}
+		if err == nil {
+			return nil
+		}
+
+		s.replicaDownUntil.Store(time.Now().Add(replicaRetryAfter).UnixNano())
+		log.Printf("read replica failed, reading from the primary: %v", err)
+	}
+
+	err := queryPool(s.dbPool, query, args, collect)
+	var final finalReadError
+	if errors.As(err, &final) {
+		return final.err
+	}
+	return err
+}
+
+func queryPool(pool *pgxpool.Pool, query string, args []any, collect func(pgx.Rows) error) error {
+	conn, err := pool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(context.Background(), query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	return collect(rows)
+}
+
 func (s *PostgresStore) Init() error {
 	err := s.createTeacherTable()
 	if err != nil {
 		return err
 	}
+	err = s.migrateTeacherTable()
+	if err != nil {
+		return err
+	}
 	err = s.createStudentTable()
 	if err != nil {
 		return err
 	}
+	err = s.migrateStudentTable()
+	if err != nil {
+		return err
+	}
 	err = s.createTeacherStudentTable()
 	if err != nil {
 		return err
 	}
+	err = s.createActiveTeacherStudentView()
+	if err != nil {
+		return err
+	}
+	err = s.createClassTable()
+	if err != nil {
+		return err
+	}
+	err = s.createClassStudentTable()
+	if err != nil {
+		return err
+	}
+	err = s.migrateEmailForeignKeys()
+	if err != nil {
+		return err
+	}
+	err = s.createIdempotencyKeyTable()
+	if err != nil {
+		return err
+	}
+	err = s.createAuditLogTable()
+	if err != nil {
+		return err
+	}
+	err = s.createNotificationTable()
+	if err != nil {
+		return err
+	}
+	err = s.createWebhookTables()
+	if err != nil {
+		return err
+	}
+	err = s.mergeEmailCaseDuplicates()
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -98,14 +319,48 @@ func (s *PostgresStore) createTeacherTable() error {
 	defer conn.Release()
 
 	query := `create table if not exists Teacher (
+		id BIGSERIAL UNIQUE,
 		email VARCHAR(255) PRIMARY KEY,
-		created_at timestamp
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		contact_preference VARCHAR(32) NOT NULL DEFAULT 'email',
+		created_at timestamp,
+		updated_at timestamp,
+		deleted_at timestamp
 	)`
 
 	_, err = conn.Exec(context.Background(), query)
 	return err
 }
 
+// brings Teacher tables created before profiles or soft deletion existed,
+// which only have email and created_at, up to date. existing rows get an id
+// and their updated_at is set to when they were created
+func (s *PostgresStore) migrateTeacherTable() error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `
+		DO $$ BEGIN
+			-- a serial column is only added once, ADD COLUMN IF NOT EXISTS would still create its sequence
+			IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = 'teacher' AND column_name = 'id') THEN
+				ALTER TABLE Teacher ADD COLUMN id BIGSERIAL UNIQUE;
+			END IF;
+		END $$;
+		ALTER TABLE Teacher ADD COLUMN IF NOT EXISTS name VARCHAR(255) NOT NULL DEFAULT '';
+		ALTER TABLE Teacher ADD COLUMN IF NOT EXISTS contact_preference VARCHAR(32) NOT NULL DEFAULT 'email';
+		ALTER TABLE Teacher ADD COLUMN IF NOT EXISTS updated_at timestamp;
+		ALTER TABLE Teacher ADD COLUMN IF NOT EXISTS deleted_at timestamp;
+		UPDATE Teacher SET updated_at = created_at WHERE updated_at IS NULL;
+		CREATE INDEX IF NOT EXISTS teacher_created_at_idx ON Teacher (created_at, email);`
+
+	_, err = conn.Exec(context.Background(), query)
+	return err
+}
+
 func (s *PostgresStore) CreateTeacher(teacher *Teacher) error {
 	conn, err := s.dbPool.Acquire(context.Background())
 	if err != nil {
@@ -114,14 +369,34 @@ func (s *PostgresStore) CreateTeacher(teacher *Teacher) error {
 	defer conn.Release()
 
 	query := `
-		INSERT INTO Teacher (email, created_at)
-		VALUES ($1, $2);`
+		INSERT INTO Teacher (email, name, contact_preference, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;`
+
+	err = conn.QueryRow(context.Background(), query,
+		teacher.Email,
+		teacher.Name,
+		teacher.ContactPreference,
+		teacher.CreatedAt,
+		teacher.UpdatedAt).Scan(&teacher.ID)
+	return deletedEntryError(conn, "Teacher", teacher.Email, err)
+}
 
-	_, err = conn.Query(context.Background(), query, teacher.Email, teacher.CreatedAt)
+func (s *PostgresStore) UpdateTeacher(teacher *Teacher) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
 
+	query := `UPDATE Teacher SET name = $1, contact_preference = $2, updated_at = $3 WHERE email = $4 AND deleted_at IS NULL;`
+	tag, err := conn.Exec(context.Background(), query, teacher.Name, teacher.ContactPreference, teacher.UpdatedAt, teacher.Email)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
 
 	return nil
 }
@@ -133,7 +408,7 @@ func (s *PostgresStore) GetTeacherByEmail(email string) (*Teacher, error) {
 	}
 	defer conn.Release()
 
-	query := `SELECT * FROM Teacher WHERE email = $1`
+	query := `SELECT ` + teacherColumns + ` FROM Teacher WHERE email = $1 AND deleted_at IS NULL`
 	rows, err := conn.Query(context.Background(), query, email)
 	if err != nil {
 		return nil, err
@@ -150,31 +425,25 @@ func (s *PostgresStore) GetTeacherByEmail(email string) (*Teacher, error) {
 	return nil, fmt.Errorf("entry does not exist")
 }
 
-func (s *PostgresStore) GetTeachers() ([]*Teacher, error) {
-	conn, err := s.dbPool.Acquire(context.Background())
+func (s *PostgresStore) GetTeachers(opts ListOptions) ([]*Teacher, string, error) {
+	opts.Suspended = nil // teachers cannot be suspended
+	clauses, args, err := buildListClauses("Teacher", []string{"Teacher.deleted_at IS NULL"}, nil, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	defer conn.Release()
 
-	query := `SELECT * FROM Teacher`
-	rows, err := conn.Query(context.Background(), query)
+	query := `SELECT ` + teacherColumns + ` FROM Teacher` + clauses
+	var teachers []*Teacher
+	err = s.queryRead(query, args, func(rows pgx.Rows) (err error) {
+		teachers, err = scanTeachers(rows)
+		return err
+	})
 	if err != nil {
-		return nil, err
-	}
-
-	teachers := []*Teacher{}
-	for rows.Next() {
-		teacher, err := scanIntoTeacher(rows)
-
-		if err != nil {
-			return nil, err
-		}
-
-		teachers = append(teachers, teacher)
+		return nil, "", err
 	}
 
-	return teachers, nil
+	teachers, cursor := paginate(opts, teachers, teacherCursorKey)
+	return teachers, cursor, nil
 }
 
 func (s *PostgresStore) TeacherExists(teacheEmail string) (bool, error) {
@@ -184,7 +453,7 @@ func (s *PostgresStore) TeacherExists(teacheEmail string) (bool, error) {
 	}
 	defer conn.Release()
 
-	query := `SELECT COUNT(1) FROM Teacher WHERE email = $1`
+	query := `SELECT COUNT(1) FROM Teacher WHERE email = $1 AND deleted_at IS NULL`
 
 	var count int
 	err = conn.QueryRow(context.Background(), query, teacheEmail).Scan(&count) // queries the count of rows selected
@@ -196,15 +465,55 @@ func (s *PostgresStore) TeacherExists(teacheEmail string) (bool, error) {
 	return exists, nil
 }
 
+// marks a teacher as deleted, which hides them and their registrations
+// until they are restored
+func (s *PostgresStore) DeleteTeacher(email string, audit ...*AuditEntry) error {
+	return s.setDeletedAt("Teacher", email, true, audit)
+}
+
+func (s *PostgresStore) RestoreTeacher(email string, audit ...*AuditEntry) error {
+	return s.setDeletedAt("Teacher", email, false, audit)
+}
+
+// changes the email of a teacher, their registrations follow it and the
+// notifications they sent are moved to it
+func (s *PostgresStore) RenameTeacher(email string, newEmail string, audit ...*AuditEntry) error {
+	return s.changeEmail("Teacher", email, newEmail, audit,
+		`UPDATE Notification SET teacher_email = $2 WHERE teacher_email = $1`)
+}
+
+// columns read by scanIntoTeacher, in scan order
+const teacherColumns = `id, email, name, contact_preference, created_at, updated_at`
+
+func teacherCursorKey(teacher *Teacher) (string, time.Time) {
+	return teacher.Email, teacher.CreatedAt
+}
+
 func scanIntoTeacher(rows pgx.Rows) (*Teacher, error) {
 	teacher := new(Teacher)
 	err := rows.Scan(
+		&teacher.ID,
 		&teacher.Email,
-		&teacher.CreatedAt)
+		&teacher.Name,
+		&teacher.ContactPreference,
+		&teacher.CreatedAt,
+		&teacher.UpdatedAt)
 
 	return teacher, err
 }
 
+func scanTeachers(rows pgx.Rows) ([]*Teacher, error) {
+	teachers := []*Teacher{}
+	for rows.Next() {
+		teacher, err := scanIntoTeacher(rows)
+		if err != nil {
+			return nil, err
+		}
+		teachers = append(teachers, teacher)
+	}
+	return teachers, rows.Err()
+}
+
 // Student Queries
 func (s *PostgresStore) createStudentTable() error {
 	conn, err := s.dbPool.Acquire(context.Background())
@@ -214,32 +523,91 @@ func (s *PostgresStore) createStudentTable() error {
 	defer conn.Release()
 
 	query := `create table if not exists Student (
+		id BIGSERIAL UNIQUE,
 		email VARCHAR(255) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		grade_level INTEGER,
+		contact_preference VARCHAR(32) NOT NULL DEFAULT 'email',
 		is_suspended bool,
-		created_at timestamp
+		created_at timestamp,
+		updated_at timestamp,
+		deleted_at timestamp
 	)`
 
 	_, err = conn.Exec(context.Background(), query)
 	return err
 }
 
-func (s *PostgresStore) CreateStudent(student *Student) error {
+// same as migrateTeacherTable but for Student
+func (s *PostgresStore) migrateStudentTable() error {
 	conn, err := s.dbPool.Acquire(context.Background())
 	if err != nil {
 		return err
 	}
 	defer conn.Release()
+
 	query := `
-		INSERT INTO Student (email, is_suspended, created_at)
-		VALUES ($1, $2, $3);`
+		DO $$ BEGIN
+			-- a serial column is only added once, ADD COLUMN IF NOT EXISTS would still create its sequence
+			IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = 'student' AND column_name = 'id') THEN
+				ALTER TABLE Student ADD COLUMN id BIGSERIAL UNIQUE;
+			END IF;
+		END $$;
+		ALTER TABLE Student ADD COLUMN IF NOT EXISTS name VARCHAR(255) NOT NULL DEFAULT '';
+		ALTER TABLE Student ADD COLUMN IF NOT EXISTS grade_level INTEGER;
+		ALTER TABLE Student ADD COLUMN IF NOT EXISTS contact_preference VARCHAR(32) NOT NULL DEFAULT 'email';
+		ALTER TABLE Student ADD COLUMN IF NOT EXISTS updated_at timestamp;
+		ALTER TABLE Student ADD COLUMN IF NOT EXISTS deleted_at timestamp;
+		UPDATE Student SET updated_at = created_at WHERE updated_at IS NULL;
+		CREATE INDEX IF NOT EXISTS student_created_at_idx ON Student (created_at, email);`
+
+	_, err = conn.Exec(context.Background(), query)
+	return err
+}
 
-	_, err = conn.Query(context.Background(), query, student.Email, student.IsSuspended, student.CreatedAt)
+func (s *PostgresStore) CreateStudent(student *Student) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	query := `
+		INSERT INTO Student (email, name, grade_level, contact_preference, is_suspended, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id;`
+
+	err = conn.QueryRow(context.Background(), query,
+		student.Email,
+		student.Name,
+		student.GradeLevel,
+		student.ContactPreference,
+		student.IsSuspended,
+		student.CreatedAt,
+		student.UpdatedAt).Scan(&student.ID)
+	return deletedEntryError(conn, "Student", student.Email, err)
+}
 
+func (s *PostgresStore) UpdateStudent(student *Student) error {
+	conn, err := s.dbPool.Acquire(context.Background())
 	if err != nil {
 		return err
 	}
+	defer conn.Release()
 
-	//fmt.Printf("%+v\n", res)
+	query := `UPDATE Student SET name = $1, grade_level = $2, contact_preference = $3, updated_at = $4 WHERE email = $5 AND deleted_at IS NULL;`
+	tag, err := conn.Exec(context.Background(), query,
+		student.Name,
+		student.GradeLevel,
+		student.ContactPreference,
+		student.UpdatedAt,
+		student.Email)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
 
 	return nil
 }
@@ -251,7 +619,7 @@ func (s *PostgresStore) GetStudentByEmail(email string) (*Student, error) {
 	}
 	defer conn.Release()
 
-	query := `SELECT * FROM Student WHERE email = $1`
+	query := `SELECT ` + studentColumns + ` FROM Student WHERE email = $1 AND deleted_at IS NULL`
 	rows, err := conn.Query(context.Background(), query, email)
 	if err != nil {
 		return nil, err
@@ -268,46 +636,56 @@ func (s *PostgresStore) GetStudentByEmail(email string) (*Student, error) {
 	return nil, fmt.Errorf("entry does not exist")
 }
 
-func (s *PostgresStore) GetStudents() ([]*Student, error) {
-	conn, err := s.dbPool.Acquire(context.Background())
+func (s *PostgresStore) GetStudents(opts ListOptions) ([]*Student, string, error) {
+	clauses, args, err := buildListClauses("Student", []string{"Student.deleted_at IS NULL"}, nil, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	defer conn.Release()
 
-	query := `SELECT * FROM Student`
-	rows, err := conn.Query(context.Background(), query)
+	query := `SELECT ` + studentColumns + ` FROM Student` + clauses
+	var students []*Student
+	err = s.queryRead(query, args, func(rows pgx.Rows) (err error) {
+		students, err = scanStudents(rows)
+		return err
+	})
 	if err != nil {
-		return nil, err
-	}
-
-	students := []*Student{}
-	for rows.Next() {
-		student, err := scanIntoStudent(rows)
-
-		if err != nil {
-			return nil, err
-		}
-
-		students = append(students, student)
+		return nil, "", err
 	}
 
-	return students, nil
+	students, cursor := paginate(opts, students, studentCursorKey)
+	return students, cursor, nil
 }
 
-func (s *PostgresStore) UpdateStudentSuspendedState(email string, is_suspended bool) error {
-	conn, err := s.dbPool.Acquire(context.Background())
+// the audit entries are only written when the student exists
+func (s *PostgresStore) UpdateStudentSuspendedState(email string, is_suspended bool, audit ...*AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.dbPool.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	defer conn.Release()
+	defer tx.Rollback(ctx)
 
-	query := `UPDATE Student SET is_suspended = $1 WHERE email = $2;`
-	_, err = conn.Exec(context.Background(), query, is_suspended, email)
+	query := `UPDATE Student SET is_suspended = $1, updated_at = $2 WHERE email = $3 AND deleted_at IS NULL;`
+	tag, err := tx.Exec(ctx, query, is_suspended, time.Now().UTC(), email)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() > 0 {
+		if err := insertAuditEntries(ctx, tx, audit); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
 
+	if tag.RowsAffected() > 0 {
+		eventType := EventStudentSuspended
+		if !is_suspended {
+			eventType = EventStudentUnsuspended
+		}
+		s.events.Publish(NewEvent(eventType, SuspensionEvent{StudentEmail: email}))
+	}
 	return nil
 }
 
@@ -318,7 +696,7 @@ func (s *PostgresStore) StudentExists(studentEmail string) (bool, error) {
 	}
 	defer conn.Release()
 
-	query := `SELECT COUNT(1) FROM Student WHERE email = $1`
+	query := `SELECT COUNT(1) FROM Student WHERE email = $1 AND deleted_at IS NULL`
 
 	var count int
 	err = conn.QueryRow(context.Background(), query, studentEmail).Scan(&count) // queries the count of rows selected
@@ -337,32 +715,170 @@ func (s *PostgresStore) IsStudentSuspended(studentEmail string) (bool, error) {
 	}
 	defer conn.Release()
 
-	query := `SELECT * FROM Student WHERE email = $1`
+	query := `SELECT is_suspended FROM Student WHERE email = $1 AND deleted_at IS NULL`
 	rows, err := conn.Query(context.Background(), query, studentEmail)
 	if err != nil {
 		return false, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		student, err := scanIntoStudent(rows)
-		if err != nil {
+		var isSuspended *bool
+		if err := rows.Scan(&isSuspended); err != nil {
 			return false, err
 		}
-		return student.IsSuspended, nil
+		return isSuspended != nil && *isSuspended, nil
 	}
 	return false, nil
 }
 
+// marks a student as deleted, which hides them, their registrations and
+// their class memberships until they are restored
+func (s *PostgresStore) DeleteStudent(email string, audit ...*AuditEntry) error {
+	return s.setDeletedAt("Student", email, true, audit)
+}
+
+// erased students are deleted for good
+func (s *PostgresStore) RestoreStudent(email string, audit ...*AuditEntry) error {
+	if strings.HasSuffix(email, "@"+erasedEmailDomain) {
+		return fmt.Errorf("erased students cannot be restored")
+	}
+	return s.setDeletedAt("Student", email, false, audit)
+}
+
+// changes the email of a student, their registrations and class memberships
+// follow it and the notifications they received are moved to it
+func (s *PostgresStore) RenameStudent(email string, newEmail string, audit ...*AuditEntry) error {
+	return s.changeEmail("Student", email, newEmail, audit,
+		`UPDATE Notification SET recipients = array_replace(recipients, $1::text, $2::text) WHERE $1::text = ANY(recipients)`)
+}
+
+// the error of changing an email to one that another teacher or student,
+// maybe a deleted one, already has
+var ErrEmailTaken = errors.New("email is already in use")
+
+// changes the email of a row of Teacher or Student that is not deleted, in
+// one transaction with the updates of the other rows referring to it. the
+// foreign keys on the email cascade the change, references is run with the
+// old and the new email for the columns that are not foreign keys. the
+// audit log is history and keeps the old email, GetStudentData and
+// EraseStudent follow the rename entries back to it
+func (s *PostgresStore) changeEmail(table string, email string, newEmail string, audit []*AuditEntry, references ...string) error {
+	ctx := context.Background()
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE ` + table + ` SET email = $2, updated_at = $3 WHERE email = $1 AND deleted_at IS NULL`
+	tag, err := tx.Exec(ctx, query, email, newEmail, time.Now().UTC())
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
+
+	for _, query := range references {
+		if _, err := tx.Exec(ctx, query, email, newEmail); err != nil {
+			return err
+		}
+	}
+	if err := insertAuditEntries(ctx, tx, audit); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// soft deletes or restores a row of Teacher or Student. deleting a row that
+// is already deleted, or restoring one that is not, is an entry that does
+// not exist
+func (s *PostgresStore) setDeletedAt(table string, email string, deleted bool, audit []*AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now().UTC()
+	query := `UPDATE ` + table + ` SET deleted_at = $1, updated_at = $1 WHERE email = $2 AND deleted_at IS NULL`
+	if !deleted {
+		query = `UPDATE ` + table + ` SET deleted_at = NULL, updated_at = $1 WHERE email = $2 AND deleted_at IS NOT NULL`
+	}
+
+	tag, err := tx.Exec(ctx, query, now, email)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
+	if err := insertAuditEntries(ctx, tx, audit); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// turns the duplicate key error of creating a teacher or student whose
+// email belongs to a deleted one into an error saying so, other errors are
+// returned as they are
+func deletedEntryError(conn *pgxpool.Conn, table string, email string, err error) error {
+	if !isUniqueViolation(err) {
+		return err
+	}
+
+	var deleted bool
+	query := `SELECT deleted_at IS NOT NULL FROM ` + table + ` WHERE lower(email) = lower($1)`
+	if conn.QueryRow(context.Background(), query, email).Scan(&deleted) != nil || !deleted {
+		return err
+	}
+	return fmt.Errorf("%s %s was deleted, restore it to use the email again", strings.ToLower(table), email)
+}
+
+// columns read by scanIntoStudent, in scan order
+const studentColumns = `id, email, name, grade_level, contact_preference, is_suspended, created_at, updated_at`
+
+func studentCursorKey(student *Student) (string, time.Time) {
+	return student.Email, student.CreatedAt
+}
+
 func scanIntoStudent(rows pgx.Rows) (*Student, error) {
 	student := new(Student)
 	err := rows.Scan(
+		&student.ID,
 		&student.Email,
+		&student.Name,
+		&student.GradeLevel,
+		&student.ContactPreference,
 		&student.IsSuspended,
-		&student.CreatedAt)
+		&student.CreatedAt,
+		&student.UpdatedAt)
 
 	return student, err
 }
 
+func scanStudents(rows pgx.Rows) ([]*Student, error) {
+	students := []*Student{}
+	for rows.Next() {
+		student, err := scanIntoStudent(rows)
+		if err != nil {
+			return nil, err
+		}
+		students = append(students, student)
+	}
+	return students, rows.Err()
+}
+
 // TeacherStudent queries
 func (s *PostgresStore) createTeacherStudentTable() error {
 	conn, err := s.dbPool.Acquire(context.Background())
@@ -375,31 +891,62 @@ func (s *PostgresStore) createTeacherStudentTable() error {
 		teacher_email VARCHAR(255),
     	student_email VARCHAR(255),
 		created_at timestamp,
-    	FOREIGN KEY (teacher_email) REFERENCES Teacher(email),
-    	FOREIGN KEY (student_email) REFERENCES Student(email),
+    	FOREIGN KEY (teacher_email) REFERENCES Teacher(email) ON UPDATE CASCADE,
+    	FOREIGN KEY (student_email) REFERENCES Student(email) ON UPDATE CASCADE,
     	PRIMARY KEY (teacher_email, student_email)
-	)`
+	);
+	CREATE INDEX IF NOT EXISTS teacherstudent_student_email_idx ON TeacherStudent (student_email);`
 
 	_, err = conn.Exec(context.Background(), query)
 	return err
 }
 
-func (s *PostgresStore) CreateTeacherStudent(teacherstudent *TeacherStudent) error {
+// the registrations whose teacher and student are both not deleted. the
+// rows of deleted teachers and students are kept in TeacherStudent so
+// restoring them brings their registrations back, and every read of
+// registrations goes through this view so they are hidden until then
+func (s *PostgresStore) createActiveTeacherStudentView() error {
 	conn, err := s.dbPool.Acquire(context.Background())
 	if err != nil {
 		return err
 	}
 	defer conn.Release()
 
-	query := `INSERT INTO TeacherStudent (teacher_email, student_email, created_at)
-	VALUES ($1, $2, $3);`
+	query := `create or replace view ActiveTeacherStudent AS
+	SELECT ts.teacher_email, ts.student_email, ts.created_at
+	FROM TeacherStudent ts
+	JOIN Teacher ON Teacher.email = ts.teacher_email
+	JOIN Student ON Student.email = ts.student_email
+	WHERE Teacher.deleted_at IS NULL AND Student.deleted_at IS NULL`
 
-	_, err = conn.Query(context.Background(), query, teacherstudent.TeacherEmail, teacherstudent.StudentEmail, teacherstudent.CreatedAt)
+	_, err = conn.Exec(context.Background(), query)
+	return err
+}
+
+func (s *PostgresStore) CreateTeacherStudent(teacherstudent *TeacherStudent, audit ...*AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO TeacherStudent (teacher_email, student_email, created_at)
+	VALUES ($1, $2, $3);`
+
+	_, err = tx.Exec(ctx, query, teacherstudent.TeacherEmail, teacherstudent.StudentEmail, teacherstudent.CreatedAt)
 
 	if err != nil {
 		return err
 	}
+	if err := insertAuditEntries(ctx, tx, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
 
+	s.events.Publish(NewEvent(EventStudentRegistered, RegistrationEvent{TeacherEmail: teacherstudent.TeacherEmail, StudentEmail: teacherstudent.StudentEmail}))
 	return nil
 }
 
@@ -410,13 +957,14 @@ func (s *PostgresStore) GetTeacherStudentByEmail(teacherEmail string, studentEma
 	}
 	defer conn.Release()
 
-	query := `SELECT * FROM TeacherStudent WHERE teacher_email = $1 AND student_email = $2`
+	query := `SELECT teacher_email, student_email, created_at FROM ActiveTeacherStudent WHERE teacher_email = $1 AND student_email = $2`
 
 	rows, err := conn.Query(context.Background(), query, teacherEmail, studentEmail)
 
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		teacherstudent, err := scanIntoTeacherStudent(rows)
@@ -429,29 +977,88 @@ func (s *PostgresStore) GetTeacherStudentByEmail(teacherEmail string, studentEma
 	return nil, fmt.Errorf("entry does not exist")
 }
 
-func (s *PostgresStore) GetStudentsAssignedToTeacher(teacherEmail string) ([]string, error) {
+func (s *PostgresStore) GetStudentsAssignedToTeacher(teacherEmail string, opts ListOptions) ([]string, string, error) {
 	conn, err := s.dbPool.Acquire(context.Background())
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer conn.Release()
 
-	query := `SELECT * FROM TeacherStudent WHERE teacher_email = $1`
-	rows, err := conn.Query(context.Background(), query, teacherEmail)
+	clauses, args, err := buildListClauses("Student", []string{"ts.teacher_email = $1"}, []any{teacherEmail}, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	query := `SELECT Student.email, Student.created_at FROM Student
+	JOIN ActiveTeacherStudent ts ON Student.email = ts.student_email` + clauses
+	rows, err := conn.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, "", err
 	}
+	defer rows.Close()
 
-	studentEmails := []string{}
+	students := []*Student{}
 	for rows.Next() {
-		teacherstudent, err := scanIntoTeacherStudent(rows)
-		if err != nil {
-			return nil, err
+		student := new(Student)
+		if err := rows.Scan(&student.Email, &student.CreatedAt); err != nil {
+			return nil, "", err
 		}
-		studentEmails = append(studentEmails, teacherstudent.StudentEmail)
+		students = append(students, student)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	students, cursor := paginate(opts, students, studentCursorKey)
+
+	studentEmails := make([]string, len(students))
+	for i, student := range students {
+		studentEmails[i] = student.Email
+	}
+	return studentEmails, cursor, nil
+}
+
+func (s *PostgresStore) GetStudentsOfTeacher(teacherEmail string, opts ListOptions) ([]*Student, string, error) {
+	clauses, args, err := buildListClauses("Student", []string{"ts.teacher_email = $1"}, []any{teacherEmail}, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := `SELECT ` + prefixColumns("Student", studentColumns) + ` FROM Student
+	JOIN ActiveTeacherStudent ts ON Student.email = ts.student_email` + clauses
+	var students []*Student
+	err = s.queryRead(query, args, func(rows pgx.Rows) (err error) {
+		students, err = scanStudents(rows)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	students, cursor := paginate(opts, students, studentCursorKey)
+	return students, cursor, nil
+}
+
+func (s *PostgresStore) GetTeachersOfStudent(studentEmail string, opts ListOptions) ([]*Teacher, string, error) {
+	opts.Suspended = nil // teachers cannot be suspended
+	clauses, args, err := buildListClauses("Teacher", []string{"ts.student_email = $1"}, []any{studentEmail}, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := `SELECT ` + prefixColumns("Teacher", teacherColumns) + ` FROM Teacher
+	JOIN ActiveTeacherStudent ts ON Teacher.email = ts.teacher_email` + clauses
+	var teachers []*Teacher
+	err = s.queryRead(query, args, func(rows pgx.Rows) (err error) {
+		teachers, err = scanTeachers(rows)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
 	}
 
-	return studentEmails, nil
+	teachers, cursor := paginate(opts, teachers, teacherCursorKey)
+	return teachers, cursor, nil
 }
 
 func (s *PostgresStore) TeacherStudentExists(teacherEmail string, studentEmail string) (bool, error) {
@@ -461,7 +1068,7 @@ func (s *PostgresStore) TeacherStudentExists(teacherEmail string, studentEmail s
 	}
 	defer conn.Release()
 
-	query := `SELECT COUNT(1) FROM TeacherStudent WHERE teacher_email = $1 AND student_email = $2`
+	query := `SELECT COUNT(1) FROM ActiveTeacherStudent WHERE teacher_email = $1 AND student_email = $2`
 
 	var count int
 	err = conn.QueryRow(context.Background(), query, teacherEmail, studentEmail).Scan(&count) // queries the count of rows selected
@@ -473,6 +1080,33 @@ func (s *PostgresStore) TeacherStudentExists(teacherEmail string, studentEmail s
 	return exists, nil
 }
 
+func (s *PostgresStore) DeleteTeacherStudent(teacherEmail string, studentEmail string, audit ...*AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `DELETE FROM TeacherStudent WHERE teacher_email = $1 AND student_email = $2;`
+	tag, err := tx.Exec(ctx, query, teacherEmail, studentEmail)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
+	if err := insertAuditEntries(ctx, tx, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	s.events.Publish(NewEvent(EventStudentDeregistered, RegistrationEvent{TeacherEmail: teacherEmail, StudentEmail: studentEmail}))
+	return nil
+}
+
 func scanIntoTeacherStudent(rows pgx.Rows) (*TeacherStudent, error) {
 	teacherstudent := new(TeacherStudent)
 	err := rows.Scan(
@@ -483,41 +1117,1600 @@ func scanIntoTeacherStudent(rows pgx.Rows) (*TeacherStudent, error) {
 	return teacherstudent, err
 }
 
+// qualifies every column of a column list with the table name so it can be used in joins
+func prefixColumns(table string, columns string) string {
+	prefixed := strings.Split(columns, ", ")
+	for i, column := range prefixed {
+		prefixed[i] = table + "." + column
+	}
+	return strings.Join(prefixed, ", ")
+}
+
 // Specific queries
 func (s *PostgresStore) GetCommonStudentsOfTeachers(teacherEmails []string) ([]string, error) {
-	conn, err := s.dbPool.Acquire(context.Background())
+	if len(teacherEmails) == 0 {
+		return nil, fmt.Errorf("no teacher emails provided")
+	}
+
+	// the emails are bound as one array parameter, never spliced into the
+	// query, and a teacher listed twice is only counted once
+	query := `SELECT ts.student_email AS common_student
+	FROM ActiveTeacherStudent ts
+	WHERE ts.teacher_email = ANY($1)
+	GROUP BY ts.student_email
+	HAVING COUNT(DISTINCT ts.teacher_email) = (SELECT COUNT(DISTINCT email) FROM unnest($1::text[]) AS email)
+	ORDER BY ts.student_email;`
+
+	var commonStudents []string
+	err := s.queryRead(query, []any{teacherEmails}, func(rows pgx.Rows) error {
+		commonStudents = []string{}
+		for rows.Next() {
+			var studentEmail string
+			if err := rows.Scan(&studentEmail); err != nil {
+				return err
+			}
+			commonStudents = append(commonStudents, studentEmail)
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
+
+	return commonStudents, nil
+}
+
+// Class queries
+func (s *PostgresStore) createClassTable() error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
 	defer conn.Release()
 
-	if len(teacherEmails) == 0 {
-		return nil, fmt.Errorf("no teacher emails provided")
+	query := `create table if not exists Class (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) UNIQUE NOT NULL,
+		kind VARCHAR(32) NOT NULL,
+		created_at timestamp
+	)`
+
+	_, err = conn.Exec(context.Background(), query)
+	return err
+}
+
+func (s *PostgresStore) CreateClass(class *Class) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
 	}
+	defer conn.Release()
 
-	emailsToQueryString := "'" + strings.Join(teacherEmails, "', '") + "'"
+	query := `
+		INSERT INTO Class (name, kind, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING id;`
 
-	query := fmt.Sprintf(`SELECT Student.email AS common_student
-	FROM Student
-	JOIN TeacherStudent ts ON Student.email = ts.student_email
-	JOIN Teacher ON ts.teacher_email = Teacher.email
-	WHERE Teacher.email IN (%s)
-	GROUP BY Student.email
-	HAVING COUNT(DISTINCT ts.teacher_email) = %d;`, emailsToQueryString, len(teacherEmails))
+	return conn.QueryRow(context.Background(), query, class.Name, class.Kind, class.CreatedAt).Scan(&class.ID)
+}
+
+func (s *PostgresStore) UpdateClass(name string, class *Class) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `UPDATE Class SET name = $1, kind = $2 WHERE name = $3;`
+	tag, err := conn.Exec(context.Background(), query, class.Name, class.Kind, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) DeleteClass(name string) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	// memberships are removed by the ON DELETE CASCADE on ClassStudent
+	query := `DELETE FROM Class WHERE name = $1;`
+	tag, err := conn.Exec(context.Background(), query, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
+
+	return nil
+}
+
+// classes are sorted and filtered by name where the other lists use email
+func (s *PostgresStore) GetClasses(opts ListOptions) ([]*Class, string, error) {
+	opts.Suspended = nil // classes cannot be suspended
+	clauses, args, err := buildKeyedListClauses("Class", "name", nil, nil, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := `SELECT id, name, kind, created_at FROM Class` + clauses
+	classes := []*Class{}
+	err = s.queryRead(query, args, func(rows pgx.Rows) error {
+		classes = []*Class{}
+		for rows.Next() {
+			class, err := scanIntoClass(rows)
+			if err != nil {
+				return err
+			}
+			classes = append(classes, class)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	classes, cursor := paginate(opts, classes, classCursorKey)
+	return classes, cursor, nil
+}
+
+func (s *PostgresStore) GetClassByName(name string) (*Class, error) {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
 
-	rows, err := conn.Query(context.Background(), query)
+	query := `SELECT id, name, kind, created_at FROM Class WHERE name = $1`
+	rows, err := conn.Query(context.Background(), query, name)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	commonStudents := []string{}
 	for rows.Next() {
-		studentEmail := new(string)
-		if err := rows.Scan(&studentEmail); err != nil {
+		class, err := scanIntoClass(rows)
+		if err != nil {
 			return nil, err
 		}
-		commonStudents = append(commonStudents, *studentEmail)
+		return class, nil
 	}
 
-	return commonStudents, nil
+	return nil, fmt.Errorf("entry does not exist")
+}
+
+func (s *PostgresStore) ClassExists(name string) (bool, error) {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	query := `SELECT COUNT(1) FROM Class WHERE name = $1`
+
+	var count int
+	err = conn.QueryRow(context.Background(), query, name).Scan(&count) // queries the count of rows selected
+	if err != nil {
+		return false, err
+	}
+
+	exists := count > 0
+	return exists, nil
+}
+
+func classCursorKey(class *Class) (string, time.Time) {
+	return class.Name, class.CreatedAt
+}
+
+func scanIntoClass(rows pgx.Rows) (*Class, error) {
+	class := new(Class)
+	err := rows.Scan(
+		&class.ID,
+		&class.Name,
+		&class.Kind,
+		&class.CreatedAt)
+
+	return class, err
+}
+
+// ClassStudent queries
+func (s *PostgresStore) createClassStudentTable() error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `create table if not exists ClassStudent (
+		class_id INTEGER,
+		student_email VARCHAR(255),
+		created_at timestamp,
+		FOREIGN KEY (class_id) REFERENCES Class(id) ON DELETE CASCADE,
+		FOREIGN KEY (student_email) REFERENCES Student(email) ON UPDATE CASCADE,
+		PRIMARY KEY (class_id, student_email)
+	)`
+
+	_, err = conn.Exec(context.Background(), query)
+	return err
+}
+
+func (s *PostgresStore) CreateClassStudent(classstudent *ClassStudent) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `INSERT INTO ClassStudent (class_id, student_email, created_at)
+	VALUES ($1, $2, $3);`
+
+	_, err = conn.Exec(context.Background(), query, classstudent.ClassID, classstudent.StudentEmail, classstudent.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) DeleteClassStudent(classID int, studentEmail string) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `DELETE FROM ClassStudent WHERE class_id = $1 AND student_email = $2;`
+	tag, err := conn.Exec(context.Background(), query, classID, studentEmail)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetStudentsInClass(classID int, opts ListOptions) ([]string, string, error) {
+	clauses, args, err := buildListClauses("Student",
+		[]string{"ClassStudent.class_id = $1", "Student.deleted_at IS NULL"}, []any{classID}, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := `SELECT Student.email, Student.created_at FROM Student
+	JOIN ClassStudent ON Student.email = ClassStudent.student_email` + clauses
+	students := []*Student{}
+	err = s.queryRead(query, args, func(rows pgx.Rows) error {
+		students = []*Student{}
+		for rows.Next() {
+			student := new(Student)
+			if err := rows.Scan(&student.Email, &student.CreatedAt); err != nil {
+				return err
+			}
+			students = append(students, student)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	students, cursor := paginate(opts, students, studentCursorKey)
+
+	studentEmails := make([]string, len(students))
+	for i, student := range students {
+		studentEmails[i] = student.Email
+	}
+	return studentEmails, cursor, nil
+}
+
+func (s *PostgresStore) ClassStudentExists(classID int, studentEmail string) (bool, error) {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	query := `SELECT COUNT(1) FROM ClassStudent WHERE class_id = $1 AND student_email = $2`
+
+	var count int
+	err = conn.QueryRow(context.Background(), query, classID, studentEmail).Scan(&count) // queries the count of rows selected
+	if err != nil {
+		return false, err
+	}
+
+	exists := count > 0
+	return exists, nil
+}
+
+// email foreign keys created before emails could change do not follow the
+// change, they are recreated once with ON UPDATE CASCADE. see changeEmail
+var emailForeignKeys = []struct {
+	table, constraint, column, references string
+}{
+	{"TeacherStudent", "teacherstudent_teacher_email_fkey", "teacher_email", "Teacher(email)"},
+	{"TeacherStudent", "teacherstudent_student_email_fkey", "student_email", "Student(email)"},
+	{"ClassStudent", "classstudent_student_email_fkey", "student_email", "Student(email)"},
+}
+
+func (s *PostgresStore) migrateEmailForeignKeys() error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	for _, fk := range emailForeignKeys {
+		query := fmt.Sprintf(`
+			DO $$ BEGIN
+				IF EXISTS (SELECT 1 FROM pg_constraint
+					WHERE conrelid = '%[1]s'::regclass AND conname = '%[2]s' AND confupdtype <> 'c') THEN
+					ALTER TABLE %[1]s DROP CONSTRAINT %[2]s,
+						ADD CONSTRAINT %[2]s FOREIGN KEY (%[3]s) REFERENCES %[4]s ON UPDATE CASCADE;
+				END IF;
+			END $$;`, fk.table, fk.constraint, fk.column, fk.references)
+		if _, err := conn.Exec(context.Background(), query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emails stored before they were normalized may differ only in case, e.g.
+// StudentJon@gmail.com and studentjon@gmail.com, and are the same person.
+// such teachers and students are merged into one, in one transaction: the
+// row that is not deleted, or else the oldest, is kept with its profile and
+// gets the registrations and class memberships of the others, and a student
+// stays suspended if any of the rows was. every email is then lower-cased
+// and a unique index on lower(email) keeps new duplicates out. the audit
+// log is history and is not changed, its lookups ignore case instead. once
+// the indexes exist there can be no new duplicates, so the tables are only
+// scanned by the first Init after an upgrade
+func (s *PostgresStore) mergeEmailCaseDuplicates() error {
+	ctx := context.Background()
+	var indexes int
+	err := s.dbPool.QueryRow(ctx, `SELECT count(*) FROM pg_indexes WHERE schemaname = current_schema()
+		AND indexname IN ('teacher_lower_email_idx', 'student_lower_email_idx')`).Scan(&indexes)
+	if err != nil {
+		return err
+	}
+	if indexes == 2 {
+		return nil
+	}
+
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// the duplicates of each table and the email they are merged into
+	mergeQuery := `CREATE TEMP TABLE email_merge ON COMMIT DROP AS
+		SELECT email, survivor FROM (
+			SELECT email, first_value(email) OVER (PARTITION BY lower(email)
+				ORDER BY deleted_at IS NOT NULL, created_at NULLS LAST, id) AS survivor
+			FROM %s
+		) emails WHERE email <> survivor`
+
+	merged := map[string]int64{}
+	for _, table := range []string{"Teacher", "Student"} {
+		queries := []string{
+			`DROP TABLE IF EXISTS pg_temp.email_merge`,
+			fmt.Sprintf(mergeQuery, table),
+		}
+		if table == "Teacher" {
+			queries = append(queries,
+				`INSERT INTO TeacherStudent (teacher_email, student_email, created_at)
+				SELECT m.survivor, ts.student_email, ts.created_at FROM TeacherStudent ts
+				JOIN email_merge m ON ts.teacher_email = m.email
+				ON CONFLICT DO NOTHING`,
+				`DELETE FROM TeacherStudent ts USING email_merge m WHERE ts.teacher_email = m.email`,
+				`UPDATE Notification n SET teacher_email = m.survivor FROM email_merge m WHERE n.teacher_email = m.email`,
+			)
+		} else {
+			queries = append(queries,
+				`INSERT INTO TeacherStudent (teacher_email, student_email, created_at)
+				SELECT ts.teacher_email, m.survivor, ts.created_at FROM TeacherStudent ts
+				JOIN email_merge m ON ts.student_email = m.email
+				ON CONFLICT DO NOTHING`,
+				`DELETE FROM TeacherStudent ts USING email_merge m WHERE ts.student_email = m.email`,
+				`INSERT INTO ClassStudent (class_id, student_email, created_at)
+				SELECT cs.class_id, m.survivor, cs.created_at FROM ClassStudent cs
+				JOIN email_merge m ON cs.student_email = m.email
+				ON CONFLICT DO NOTHING`,
+				`DELETE FROM ClassStudent cs USING email_merge m WHERE cs.student_email = m.email`,
+				`UPDATE Student s SET is_suspended = true FROM email_merge m
+				JOIN Student d ON d.email = m.email
+				WHERE s.email = m.survivor AND d.is_suspended`,
+			)
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(ctx, query); err != nil {
+				return err
+			}
+		}
+
+		tag, err := tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s t USING email_merge m WHERE t.email = m.email`, table))
+		if err != nil {
+			return err
+		}
+		merged[table] = tag.RowsAffected()
+
+		// registrations and class memberships follow through ON UPDATE CASCADE
+		queries = []string{
+			fmt.Sprintf(`UPDATE %s SET email = lower(email) WHERE email <> lower(email)`, table),
+			fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s_lower_email_idx ON %s (lower(email))`, strings.ToLower(table), table),
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(ctx, query); err != nil {
+				return err
+			}
+		}
+	}
+
+	// recipients that were duplicates of one another are listed once, where
+	// the first of them was
+	_, err = tx.Exec(ctx, `UPDATE Notification SET teacher_email = lower(teacher_email),
+		recipients = ARRAY(
+			SELECT lower(r.email) FROM unnest(recipients) WITH ORDINALITY AS r(email, ord)
+			GROUP BY lower(r.email) ORDER BY min(r.ord))
+		WHERE teacher_email <> lower(teacher_email) OR recipients::text <> lower(recipients::text)`)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	if merged["Teacher"] > 0 || merged["Student"] > 0 {
+		log.Printf("merged %d teachers and %d students whose emails differed only in case", merged["Teacher"], merged["Student"])
+	}
+	return nil
+}
+
+// Roster queries
+
+// writes roster rows with COPY into a temporary table, one batch per
+// transaction, and inserts the teachers, students, classes and links that
+// do not exist yet. a dry run does all of this in a single transaction that
+// is rolled back so the report shows what would have changed. audit, when
+// not nil, makes the audit entry of each new registration, which is written
+// in the transaction of its batch. when a batch fails the report of the
+// batches committed before it is returned with the error
+func (s *PostgresStore) ImportRoster(rows []RosterRow, dryRun bool, audit func(TeacherStudentPair) *AuditEntry) (*ImportReport, error) {
+	ctx := context.Background()
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	report := NewImportReport(dryRun)
+	report.Rows = len(rows)
+
+	var tx pgx.Tx
+	if dryRun {
+		tx, err = conn.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback(ctx)
+	}
+
+	for start := 0; start < len(rows); start += rosterBatchSize {
+		end := min(start+rosterBatchSize, len(rows))
+
+		if !dryRun {
+			tx, err = conn.Begin(ctx)
+			if err != nil {
+				return partialImportReport(report, start, err)
+			}
+		}
+
+		// the report of the committed batches, the slices keep their length
+		// while the batch appends to them
+		committed := *report
+		registered := len(report.NewRegistrations)
+		err := importRosterBatch(ctx, tx, rows[start:end], report)
+		if !dryRun {
+			if err == nil && audit != nil {
+				entries := make([]*AuditEntry, 0, len(report.NewRegistrations)-registered)
+				for _, registration := range report.NewRegistrations[registered:] {
+					entries = append(entries, audit(registration))
+				}
+				err = insertAuditEntries(ctx, tx, entries)
+			}
+			if err == nil {
+				err = tx.Commit(ctx)
+			}
+			if err != nil {
+				tx.Rollback(ctx)
+				*report = committed
+				return partialImportReport(report, start, fmt.Errorf("rows %d to %d were not imported: %w", rows[start].Line, rows[end-1].Line, err))
+			}
+			for _, registration := range report.NewRegistrations[registered:] {
+				s.events.Publish(NewEvent(EventStudentRegistered, RegistrationEvent(registration)))
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// the report of the batches imported before the one starting at row start
+// failed, or nil when it was the first
+func partialImportReport(report *ImportReport, start int, err error) (*ImportReport, error) {
+	if start == 0 {
+		return nil, err
+	}
+	report.Rows = start
+	return report, err
+}
+
+func importRosterBatch(ctx context.Context, tx pgx.Tx, rows []RosterRow, report *ImportReport) error {
+	_, err := tx.Exec(ctx, `
+		CREATE TEMP TABLE IF NOT EXISTS roster_import (
+			teacher_email VARCHAR(255),
+			student_email VARCHAR(255),
+			class_name VARCHAR(255)
+		) ON COMMIT DROP;
+		TRUNCATE roster_import;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"roster_import"},
+		[]string{"teacher_email", "student_email", "class_name"},
+		pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+			return []any{rows[i].TeacherEmail, rows[i].StudentEmail, rows[i].ClassName}, nil
+		}))
+	if err != nil {
+		return err
+	}
+
+	// rows naming a deleted teacher or student are left out rather than
+	// registering them while they are hidden
+	deleted, err := queryStrings(ctx, tx, `
+		WITH skipped AS (
+			DELETE FROM roster_import
+			WHERE teacher_email IN (SELECT email FROM Teacher WHERE deleted_at IS NOT NULL)
+			OR student_email IN (SELECT email FROM Student WHERE deleted_at IS NOT NULL)
+			RETURNING teacher_email, student_email
+		)
+		SELECT email FROM Teacher WHERE deleted_at IS NOT NULL AND email IN (SELECT teacher_email FROM skipped)
+		UNION
+		SELECT email FROM Student WHERE deleted_at IS NOT NULL AND email IN (SELECT student_email FROM skipped)
+		ORDER BY 1`)
+	if err != nil {
+		return err
+	}
+	for _, email := range deleted {
+		// the same email can be skipped in several batches
+		if !StringExistsInArray(email, report.Deleted) {
+			report.Deleted = append(report.Deleted, email)
+		}
+	}
+
+	now := time.Now().UTC()
+
+	newTeachers, err := queryStrings(ctx, tx, `
+		INSERT INTO Teacher (email, created_at, updated_at)
+		SELECT DISTINCT teacher_email, $1::timestamp, $1::timestamp FROM roster_import
+		ON CONFLICT (email) DO NOTHING
+		RETURNING email`, now)
+	if err != nil {
+		return err
+	}
+	report.NewTeachers = append(report.NewTeachers, newTeachers...)
+
+	newStudents, err := queryStrings(ctx, tx, `
+		INSERT INTO Student (email, is_suspended, created_at, updated_at)
+		SELECT DISTINCT student_email, false, $1::timestamp, $1::timestamp FROM roster_import
+		ON CONFLICT (email) DO NOTHING
+		RETURNING email`, now)
+	if err != nil {
+		return err
+	}
+	report.NewStudents = append(report.NewStudents, newStudents...)
+
+	newClasses, err := queryStrings(ctx, tx, `
+		INSERT INTO Class (name, kind, created_at)
+		SELECT DISTINCT class_name, $2, $1::timestamp FROM roster_import WHERE class_name <> ''
+		ON CONFLICT (name) DO NOTHING
+		RETURNING name`, now, ClassKindClass)
+	if err != nil {
+		return err
+	}
+	report.NewClasses = append(report.NewClasses, newClasses...)
+
+	pairs, err := tx.Query(ctx, `
+		INSERT INTO TeacherStudent (teacher_email, student_email, created_at)
+		SELECT DISTINCT teacher_email, student_email, $1::timestamp FROM roster_import
+		ON CONFLICT (teacher_email, student_email) DO NOTHING
+		RETURNING teacher_email, student_email`, now)
+	if err != nil {
+		return err
+	}
+	for pairs.Next() {
+		var pair TeacherStudentPair
+		if err := pairs.Scan(&pair.TeacherEmail, &pair.StudentEmail); err != nil {
+			pairs.Close()
+			return err
+		}
+		report.NewRegistrations = append(report.NewRegistrations, pair)
+	}
+	if err := pairs.Err(); err != nil {
+		return err
+	}
+
+	pairs, err = tx.Query(ctx, `
+		WITH inserted AS (
+			INSERT INTO ClassStudent (class_id, student_email, created_at)
+			SELECT DISTINCT Class.id, roster_import.student_email, $1::timestamp FROM roster_import
+			JOIN Class ON Class.name = roster_import.class_name
+			ON CONFLICT (class_id, student_email) DO NOTHING
+			RETURNING class_id, student_email
+		)
+		SELECT Class.name, inserted.student_email FROM inserted
+		JOIN Class ON Class.id = inserted.class_id`, now)
+	if err != nil {
+		return err
+	}
+	for pairs.Next() {
+		var pair ClassStudentPair
+		if err := pairs.Scan(&pair.ClassName, &pair.StudentEmail); err != nil {
+			pairs.Close()
+			return err
+		}
+		report.NewClassMemberships = append(report.NewClassMemberships, pair)
+	}
+	return pairs.Err()
+}
+
+// calls fn with every registration, every student without a teacher and
+// every teacher without a student, ordered by teacher then student. rows are
+// read one at a time as fn consumes them and the record passed to fn is reused
+func (s *PostgresStore) ExportRoster(fn func(*RosterRecord) error) error {
+	query := `SELECT COALESCE(ts.teacher_email, ''), Student.email, COALESCE(Student.is_suspended, false)
+	FROM Student
+	LEFT JOIN ActiveTeacherStudent ts ON Student.email = ts.student_email
+	WHERE Student.deleted_at IS NULL
+	UNION ALL
+	SELECT Teacher.email, '', false
+	FROM Teacher
+	WHERE Teacher.deleted_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM ActiveTeacherStudent ts WHERE ts.teacher_email = Teacher.email)
+	ORDER BY 1, 2`
+
+	return s.queryRead(query, nil, func(rows pgx.Rows) error {
+		// the records already handed to fn cannot be taken back, so a
+		// failure after the first one is not retried on the primary
+		exported := false
+		record := new(RosterRecord)
+		for rows.Next() {
+			if err := rows.Scan(&record.TeacherEmail, &record.StudentEmail, &record.StudentSuspended); err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return finalReadError{err}
+			}
+			exported = true
+		}
+		if err := rows.Err(); err != nil && exported {
+			return finalReadError{err}
+		}
+		return rows.Err()
+	})
+}
+
+// runs a query returning a single text column and collects the values
+func queryStrings(ctx context.Context, tx pgx.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+// idempotency keys
+func (s *PostgresStore) createIdempotencyKeyTable() error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `create table if not exists IdempotencyKey (
+		key VARCHAR(255) PRIMARY KEY,
+		request_hash VARCHAR(64) NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		content_type VARCHAR(255) NOT NULL DEFAULT '',
+		body BYTEA,
+		created_at timestamp NOT NULL
+	)`
+
+	_, err = conn.Exec(context.Background(), query)
+	return err
+}
+
+// claims the key for a new request. a key that was created before
+// expiredBefore is reused. returns false if the key is already taken
+func (s *PostgresStore) ReserveIdempotencyKey(record *IdempotencyRecord, expiredBefore time.Time, abandonedBefore time.Time) (bool, error) {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	query := `
+		INSERT INTO IdempotencyKey (key, request_hash, status_code, content_type, body, created_at)
+		VALUES ($1, $2, 0, '', NULL, $3)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = 0, content_type = '', body = NULL, created_at = EXCLUDED.created_at
+		WHERE IdempotencyKey.created_at < $4 OR (IdempotencyKey.status_code = 0 AND IdempotencyKey.created_at < $5)
+		RETURNING key;`
+
+	var key string
+	err = conn.QueryRow(context.Background(), query, record.Key, record.RequestHash, record.CreatedAt, expiredBefore, abandonedBefore).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *PostgresStore) GetIdempotencyRecord(key string) (*IdempotencyRecord, error) {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	query := `SELECT key, request_hash, status_code, content_type, body, created_at FROM IdempotencyKey WHERE key = $1`
+
+	record := new(IdempotencyRecord)
+	err = conn.QueryRow(context.Background(), query, key).Scan(
+		&record.Key,
+		&record.RequestHash,
+		&record.StatusCode,
+		&record.ContentType,
+		&record.Body,
+		&record.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("entry does not exist")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (s *PostgresStore) SaveIdempotencyResponse(record *IdempotencyRecord) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `UPDATE IdempotencyKey SET status_code = $1, content_type = $2, body = $3 WHERE key = $4;`
+	tag, err := conn.Exec(context.Background(), query, record.StatusCode, record.ContentType, record.Body, record.Key)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) DeleteIdempotencyKey(key string) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(context.Background(), `DELETE FROM IdempotencyKey WHERE key = $1;`, key)
+	return err
+}
+
+// audit log
+func (s *PostgresStore) createAuditLogTable() error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	queries := []string{
+		`create table if not exists AuditLog (
+			id BIGSERIAL PRIMARY KEY,
+			actor VARCHAR(255) NOT NULL,
+			action VARCHAR(32) NOT NULL,
+			target VARCHAR(255) NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}',
+			request_id VARCHAR(255) NOT NULL DEFAULT '',
+			created_at timestamp NOT NULL
+		)`,
+		// the actor a client claimed to act for, next to the actor taken from
+		// its connection, see AuditInfo
+		`ALTER TABLE AuditLog ADD COLUMN IF NOT EXISTS claimed_actor VARCHAR(255) NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS auditlog_actor_idx ON AuditLog (actor, id)`,
+		// targets are looked up in any case, see GetAuditEntries
+		`DROP INDEX IF EXISTS auditlog_target_idx`,
+		`CREATE INDEX IF NOT EXISTS auditlog_lower_target_idx ON AuditLog (lower(target), id)`,
+		`CREATE INDEX IF NOT EXISTS auditlog_created_at_idx ON AuditLog (created_at)`,
+		// the log is append-only, entries can never be changed or removed.
+		// the one exception is EraseStudent replacing the email of a
+		// student, which it allows for its own transaction only
+		`CREATE OR REPLACE FUNCTION auditlog_append_only() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'UPDATE' AND current_setting('auditlog.erasure', true) = 'on' THEN
+				RETURN NEW;
+			END IF;
+			RAISE EXCEPTION 'AuditLog is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS auditlog_append_only ON AuditLog`,
+		`CREATE TRIGGER auditlog_append_only BEFORE UPDATE OR DELETE ON AuditLog
+			FOR EACH ROW EXECUTE FUNCTION auditlog_append_only()`,
+	}
+
+	for _, query := range queries {
+		if _, err := conn.Exec(context.Background(), query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appends the entries to the audit log in one round trip
+func (s *PostgresStore) CreateAuditEntries(entries []*AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertAuditEntries(ctx, tx, entries); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// appends the entries to the audit log in the transaction of the change they
+// record. a failure aborts the transaction, so the change is not made either
+func insertAuditEntries(ctx context.Context, tx pgx.Tx, entries []*AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO AuditLog (actor, claimed_actor, action, target, payload, request_id, created_at)
+		VALUES ($1, $2, $3, $4, $5::jsonb, $6, $7)
+		RETURNING id;`
+
+	batch := &pgx.Batch{}
+	for _, entry := range entries {
+		entry := entry
+		payload := string(entry.Payload)
+		if payload == "" {
+			payload = "{}"
+		}
+		batch.Queue(query, entry.Actor, entry.ClaimedActor, entry.Action, entry.Target, payload, entry.RequestID, entry.CreatedAt).
+			QueryRow(func(row pgx.Row) error {
+				return row.Scan(&entry.ID)
+			})
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		log.Printf("recording %d audit entries, starting with %s of %s: %v", len(entries), entries[0].Action, entries[0].Target, err)
+		return fmt.Errorf("%w: %w", ErrAuditNotRecorded, err)
+	}
+	return nil
+}
+
+// the newest entries matching the filter first
+func (s *PostgresStore) GetAuditEntries(filter AuditFilter) ([]*AuditEntry, string, error) {
+	conditions, args := []string{}, []any{}
+	addCondition := func(condition string, v any) {
+		args = append(args, v)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Actor != "" {
+		addCondition("actor = $%d", filter.Actor)
+	}
+	if filter.Target != "" {
+		// entries recorded before emails were normalized may differ in case
+		addCondition("lower(target) = $%d", filter.Target)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.CreatedAfter != nil {
+		addCondition("created_at >= $%d", filter.CreatedAfter.UTC())
+	}
+	if filter.CreatedBefore != nil {
+		addCondition("created_at < $%d", filter.CreatedBefore.UTC())
+	}
+	if filter.Cursor != "" {
+		id, err := decodeAuditCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		addCondition("id < $%d", id)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM AuditLog`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	// one extra row is read to know whether there is a next page
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit+1)
+	}
+
+	var entries []*AuditEntry
+	err := s.queryRead(query, args, func(rows pgx.Rows) (err error) {
+		entries, err = scanAuditEntries(rows)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	cursor := ""
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+		cursor = encodeAuditCursor(entries[len(entries)-1].ID)
+	}
+	return entries, cursor, nil
+}
+
+// columns read by scanAuditEntries, in scan order
+const auditColumns = `id, actor, claimed_actor, action, target, payload::text, request_id, created_at`
+
+func scanAuditEntries(rows pgx.Rows) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		entry := new(AuditEntry)
+		var payload string
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Actor,
+			&entry.ClaimedActor,
+			&entry.Action,
+			&entry.Target,
+			&payload,
+			&entry.RequestID,
+			&entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.Payload = json.RawMessage(payload)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// notifications
+func (s *PostgresStore) createNotificationTable() error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `create table if not exists Notification (
+		id BIGSERIAL PRIMARY KEY,
+		teacher_email VARCHAR(255) NOT NULL,
+		text TEXT NOT NULL,
+		recipients TEXT[] NOT NULL,
+		created_at timestamp NOT NULL
+	)`
+
+	_, err = conn.Exec(context.Background(), query)
+	return err
+}
+
+// the id of the notification is added to the payload of the audit entries
+func (s *PostgresStore) CreateNotification(notification *Notification, audit ...*AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO Notification (teacher_email, text, recipients, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;`
+
+	err = tx.QueryRow(ctx, query, notification.TeacherEmail, notification.Text, notification.Recipients, notification.CreatedAt).Scan(&notification.ID)
+	if err != nil {
+		return err
+	}
+	if err := setNotificationID(audit, notification.ID); err != nil {
+		return err
+	}
+	if err := insertAuditEntries(ctx, tx, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	s.events.Publish(NewEvent(EventNotificationCreated, notification))
+	return nil
+}
+
+// sets notification_id in the payload of each entry, the notification only
+// has an id once it is stored
+func setNotificationID(entries []*AuditEntry, id int64) error {
+	for _, entry := range entries {
+		payload := map[string]any{}
+		if len(entry.Payload) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(entry.Payload))
+			// ids stay integers
+			decoder.UseNumber()
+			if err := decoder.Decode(&payload); err != nil {
+				return err
+			}
+		}
+		payload["notification_id"] = id
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		entry.Payload = raw
+	}
+	return nil
+}
+
+// data subject requests
+
+// everything stored about a student, including a deleted one, read from one
+// snapshot of the database. the suspensions are left for the caller to pick
+// out of the audit entries
+func (s *PostgresStore) GetStudentData(email string) (*StudentDataExport, error) {
+	ctx := context.Background()
+	tx, err := s.dbPool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	export := &StudentDataExport{Student: new(Student)}
+	err = tx.QueryRow(ctx, `SELECT `+studentColumns+`, deleted_at FROM Student WHERE email = $1`, email).Scan(
+		&export.Student.ID,
+		&export.Student.Email,
+		&export.Student.Name,
+		&export.Student.GradeLevel,
+		&export.Student.ContactPreference,
+		&export.Student.IsSuspended,
+		&export.Student.CreatedAt,
+		&export.Student.UpdatedAt,
+		&export.DeletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("entry does not exist")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// registrations and class memberships hidden by a deletion are still
+	// stored, so they are included
+	export.Teachers, err = queryStrings(ctx, tx, `SELECT teacher_email FROM TeacherStudent WHERE student_email = $1 ORDER BY teacher_email`, email)
+	if err != nil {
+		return nil, err
+	}
+	export.Classes, err = queryStrings(ctx, tx, `SELECT Class.name FROM ClassStudent
+		JOIN Class ON Class.id = ClassStudent.class_id
+		WHERE ClassStudent.student_email = $1 ORDER BY Class.name`, email)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id, teacher_email, text, recipients, created_at FROM Notification
+		WHERE $1 = ANY(recipients) ORDER BY id`, email)
+	if err != nil {
+		return nil, err
+	}
+	export.Notifications = []*Notification{}
+	for rows.Next() {
+		notification := new(Notification)
+		if err := rows.Scan(&notification.ID, &notification.TeacherEmail, &notification.Text, &notification.Recipients, &notification.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Notifications = append(export.Notifications, notification)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// entries about the student, made by them or claiming to be, or listing
+	// them as a recipient of a notification, under any email they had while
+	// they had it. entries recorded before emails were normalized may differ
+	// in case
+	rows, err = tx.Query(ctx, studentEmailHistory+` SELECT `+auditColumns+` FROM AuditLog
+		WHERE EXISTS (SELECT 1 FROM history
+			WHERE (history.renamed_at IS NULL OR AuditLog.created_at <= history.renamed_at)
+			AND (lower(AuditLog.target) = history.email OR lower(AuditLog.actor) = history.email
+				OR lower(AuditLog.claimed_actor) = history.email OR lower(AuditLog.payload->>'recipients')::jsonb ? history.email))
+		ORDER BY id`, email)
+	if err != nil {
+		return nil, err
+	}
+	export.AuditEntries, err = scanAuditEntries(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// the emails a student had, found by following the previous_email of their
+// rename entries back from $1. each row is an email and when the student was
+// renamed from it, NULL for the current one. an email can be taken by
+// someone else after a rename, so only rows from before renamed_at are theirs
+const studentEmailHistory = `WITH RECURSIVE history(email, renamed_at) AS (
+		SELECT $1::text, NULL::timestamp
+		UNION
+		SELECT lower(AuditLog.payload->>'previous_email'), AuditLog.created_at
+		FROM AuditLog JOIN history ON lower(AuditLog.target) = history.email
+		WHERE AuditLog.action = '` + AuditActionRename + `' AND AuditLog.payload->>'kind' = 'student'
+		AND (history.renamed_at IS NULL OR AuditLog.created_at <= history.renamed_at)
+	)`
+
+// an email a student had, see studentEmailHistory
+type pastEmail struct {
+	email string
+	// nil for the current email
+	renamedAt *time.Time
+}
+
+func queryStudentEmailHistory(ctx context.Context, tx pgx.Tx, email string) ([]pastEmail, error) {
+	rows, err := tx.Query(ctx, studentEmailHistory+` SELECT email, renamed_at FROM history`, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []pastEmail
+	for rows.Next() {
+		var past pastEmail
+		if err := rows.Scan(&past.email, &past.renamedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, past)
+	}
+	return history, rows.Err()
+}
+
+// the domain of the anonymous emails of erased students. .invalid is
+// reserved, so no real email has it
+const erasedEmailDomain = "erased.invalid"
+
+// replaces the email of a student with an anonymous one everywhere it is
+// stored and clears their profile, in one transaction. the student is
+// deleted for good, their rows are kept, so counts of registrations,
+// notifications and audit entries do not change. stored responses of
+// idempotent requests that contain the email are dropped. emails the student
+// had before a rename are replaced in the rows from before it. the audit
+// entries are written before the email is replaced, so they hold the
+// anonymous one. returns the anonymous email
+func (s *PostgresStore) EraseStudent(email string, audit ...*AuditEntry) (string, error) {
+	ctx := context.Background()
+	tx, err := s.dbPool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `SELECT id FROM Student WHERE email = $1 FOR UPDATE`, email).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("entry does not exist")
+	}
+	if err != nil {
+		return "", err
+	}
+	erased := fmt.Sprintf("erased-%d@%s", id, erasedEmailDomain)
+	now := time.Now().UTC()
+
+	// read before the rename entries it follows are rewritten
+	history, err := queryStudentEmailHistory(ctx, tx, email)
+	if err != nil {
+		return "", err
+	}
+
+	if err := insertAuditEntries(ctx, tx, audit); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `SET LOCAL auditlog.erasure = 'on'`); err != nil {
+		return "", err
+	}
+	// registrations and class memberships follow through ON UPDATE CASCADE
+	_, err = tx.Exec(ctx, `UPDATE Student SET email = $2, name = '', grade_level = NULL, updated_at = $3, deleted_at = COALESCE(deleted_at, $3)
+		WHERE email = $1`, email, erased, now)
+	if err != nil {
+		return "", err
+	}
+
+	for _, past := range history {
+		if err := eraseEmail(ctx, tx, past, erased); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+	return erased, nil
+}
+
+// replaces one email a student had with the anonymous one in the rows from
+// while they had it
+func eraseEmail(ctx context.Context, tx pgx.Tx, past pastEmail, erased string) error {
+	email, renamedAt := past.email, past.renamedAt
+	pattern := emailPattern(email)
+	// the rows that may hold the email are found in SQL and rewritten here,
+	// see replaceEmail. rows written before emails were normalized may hold
+	// it in another case. no index can find an email inside a text, so
+	// Notification, AuditLog and IdempotencyKey are scanned in full, which
+	// is accepted for a rare admin request
+	var notifications []*Notification
+	rows, err := tx.Query(ctx, `SELECT id, text, recipients FROM Notification
+		WHERE ($1 = ANY(recipients) OR strpos(lower(text), $1) > 0)
+		AND ($2::timestamp IS NULL OR created_at <= $2) FOR UPDATE`, email, renamedAt)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		notification := new(Notification)
+		if err := rows.Scan(&notification.ID, &notification.Text, &notification.Recipients); err != nil {
+			rows.Close()
+			return err
+		}
+		notifications = append(notifications, notification)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, notification := range notifications {
+		for i, recipient := range notification.Recipients {
+			notification.Recipients[i] = replaceEmail(recipient, pattern, erased)
+		}
+		_, err := tx.Exec(ctx, `UPDATE Notification SET text = $2, recipients = $3 WHERE id = $1`,
+			notification.ID, replaceEmail(notification.Text, pattern, erased), notification.Recipients)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err = tx.Query(ctx, `SELECT `+auditColumns+` FROM AuditLog
+		WHERE (lower(actor) = $1 OR lower(claimed_actor) = $1 OR lower(target) = $1 OR strpos(lower(payload::text), $1) > 0)
+		AND ($2::timestamp IS NULL OR created_at <= $2) FOR UPDATE`, email, renamedAt)
+	if err != nil {
+		return err
+	}
+	entries, err := scanAuditEntries(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		payload, err := replaceEmailInJSON(entry.Payload, pattern, erased)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE AuditLog SET actor = $2, claimed_actor = $3, target = $4, payload = $5 WHERE id = $1`,
+			entry.ID, replaceEmail(entry.Actor, pattern, erased), replaceEmail(entry.ClaimedActor, pattern, erased),
+			replaceEmail(entry.Target, pattern, erased), payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	// stored responses are JSON, which is UTF-8
+	_, err = tx.Exec(ctx, `DELETE FROM IdempotencyKey WHERE strpos(lower(convert_from(body, 'UTF8')), $1) > 0
+		AND ($2::timestamp IS NULL OR created_at <= $2)`, email, renamedAt)
+	return err
+}
+
+var (
+	emailLocalCharacter  = regexp.MustCompile(`[\w.%+-]`)
+	emailDomainCharacter = regexp.MustCompile(`[\w-]`)
+)
+
+// matches a normalized email in any case, see replaceEmail
+func emailPattern(email string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)` + regexp.QuoteMeta(email))
+}
+
+// replaces every occurrence of the email matched by pattern in text with
+// replacement, unless it is part of a longer email: jon@gmail.com is
+// replaced in "@jon@gmail.com," but not in "@jon@gmail.community" or
+// "tjon@gmail.com". pattern is made by emailPattern once for all the texts
+func replaceEmail(text string, pattern *regexp.Regexp, replacement string) string {
+	var replaced strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		// a longer local part before it, or a longer domain after it. a
+		// period ends a sentence unless an email character follows it
+		if start > 0 && emailLocalCharacter.MatchString(text[start-1:start]) {
+			continue
+		}
+		if end < len(text) && emailDomainCharacter.MatchString(text[end:end+1]) {
+			continue
+		}
+		if end+1 < len(text) && text[end] == '.' && emailDomainCharacter.MatchString(text[end+1:end+2]) {
+			continue
+		}
+		replaced.WriteString(text[last:start])
+		replaced.WriteString(replacement)
+		last = end
+	}
+	replaced.WriteString(text[last:])
+	return replaced.String()
+}
+
+// replaces email in every string of a JSON document, see replaceEmail
+func replaceEmailInJSON(document json.RawMessage, pattern *regexp.Regexp, replacement string) (json.RawMessage, error) {
+	if len(document) == 0 {
+		return document, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	// ids stay integers
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var replace func(value any) any
+	replace = func(value any) any {
+		switch value := value.(type) {
+		case string:
+			return replaceEmail(value, pattern, replacement)
+		case []any:
+			for i := range value {
+				value[i] = replace(value[i])
+			}
+		case map[string]any:
+			for key := range value {
+				value[key] = replace(value[key])
+			}
+		}
+		return value
+	}
+	return json.Marshal(replace(value))
+}
+
+// webhooks
+func (s *PostgresStore) createWebhookTables() error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	queries := []string{
+		`create table if not exists Webhook (
+			id BIGSERIAL PRIMARY KEY,
+			url TEXT NOT NULL,
+			event_types TEXT[] NOT NULL,
+			secret VARCHAR(255) NOT NULL,
+			created_at timestamp NOT NULL
+		)`,
+		`create table if not exists WebhookDelivery (
+			id BIGSERIAL PRIMARY KEY,
+			webhook_id BIGINT NOT NULL REFERENCES Webhook(id) ON DELETE CASCADE,
+			event_id VARCHAR(64) NOT NULL,
+			event_type VARCHAR(64) NOT NULL,
+			attempt INTEGER NOT NULL,
+			status_code INTEGER NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			success BOOLEAN NOT NULL,
+			created_at timestamp NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS webhookdelivery_webhook_id_idx ON WebhookDelivery (webhook_id, id)`,
+	}
+
+	for _, query := range queries {
+		if _, err := conn.Exec(context.Background(), query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateWebhook(webhook *WebhookSubscription) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `
+		INSERT INTO Webhook (url, event_types, secret, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;`
+
+	return conn.QueryRow(context.Background(), query, webhook.URL, webhook.EventTypes, webhook.Secret, webhook.CreatedAt).Scan(&webhook.ID)
+}
+
+func (s *PostgresStore) GetWebhooks() ([]*WebhookSubscription, error) {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(context.Background(), `SELECT id, url, event_types, secret, created_at FROM Webhook ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*WebhookSubscription{}
+	for rows.Next() {
+		webhook, err := scanIntoWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (s *PostgresStore) GetWebhook(id int64) (*WebhookSubscription, error) {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(context.Background(), `SELECT id, url, event_types, secret, created_at FROM Webhook WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoWebhook(rows)
+	}
+
+	return nil, fmt.Errorf("entry does not exist")
+}
+
+func (s *PostgresStore) DeleteWebhook(id int64) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(context.Background(), `DELETE FROM Webhook WHERE id = $1;`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("entry does not exist")
+	}
+
+	return nil
+}
+
+func scanIntoWebhook(rows pgx.Rows) (*WebhookSubscription, error) {
+	webhook := new(WebhookSubscription)
+	err := rows.Scan(
+		&webhook.ID,
+		&webhook.URL,
+		&webhook.EventTypes,
+		&webhook.Secret,
+		&webhook.CreatedAt)
+
+	return webhook, err
+}
+
+func (s *PostgresStore) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	query := `
+		INSERT INTO WebhookDelivery (webhook_id, event_id, event_type, attempt, status_code, error, success, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id;`
+
+	return conn.QueryRow(context.Background(), query,
+		delivery.WebhookID,
+		delivery.EventID,
+		delivery.EventType,
+		delivery.Attempt,
+		delivery.StatusCode,
+		delivery.Error,
+		delivery.Success,
+		delivery.CreatedAt).Scan(&delivery.ID)
+}
+
+// the latest deliveries to a webhook, newest first
+func (s *PostgresStore) GetWebhookDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	conn, err := s.dbPool.Acquire(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	query := `
+		SELECT id, webhook_id, event_id, event_type, attempt, status_code, error, success, created_at
+		FROM WebhookDelivery WHERE webhook_id = $1
+		ORDER BY id DESC LIMIT $2`
+	rows, err := conn.Query(context.Background(), query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery := new(WebhookDelivery)
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.EventID,
+			&delivery.EventType,
+			&delivery.Attempt,
+			&delivery.StatusCode,
+			&delivery.Error,
+			&delivery.Success,
+			&delivery.CreatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
 }
@@ -0,0 +1,1134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RunStorageTests checks that a Storage implementation behaves like the
+// others. newStorage must return an empty store every time it is called,
+// each subtest gets a store of its own
+func RunStorageTests(t *testing.T, newStorage func(t *testing.T) Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store Storage)
+	}{
+		{"Teachers", testStorageTeachers},
+		{"TeacherPages", testStorageTeacherPages},
+		{"Students", testStorageStudents},
+		{"Registrations", testStorageRegistrations},
+		{"CommonStudents", testStorageCommonStudents},
+		{"Classes", testStorageClasses},
+		{"ClassStudents", testStorageClassStudents},
+		{"Roster", testStorageRoster},
+		{"RosterBatches", testStorageRosterBatches},
+		{"SoftDelete", testStorageSoftDelete},
+		{"Rename", testStorageRename},
+		{"EmailCase", testStorageEmailCase},
+		{"IdempotencyKeys", testStorageIdempotencyKeys},
+		{"AuditLog", testStorageAuditLog},
+		{"Notifications", testStorageNotifications},
+		{"StudentData", testStorageStudentData},
+		{"RenamedStudentData", testStorageRenamedStudentData},
+		{"Webhooks", testStorageWebhooks},
+		{"ConcurrentInserts", testStorageConcurrentInserts},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newStorage(t))
+		})
+	}
+}
+
+func TestPostgresStoreConformance(t *testing.T) {
+	RunStorageTests(t, func(t *testing.T) Storage {
+		return newTestStore(t)
+	})
+}
+
+// teachers and students stored before emails were normalized are merged by
+// the migration run in Init
+func TestPostgresStoreMergesEmailCaseDuplicates(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	// duplicates could only be stored before the indexes existed
+	if _, err := store.dbPool.Exec(ctx, `DROP INDEX teacher_lower_email_idx, student_lower_email_idx`); err != nil {
+		t.Fatal(err)
+	}
+
+	createStorageRoster(t, store, map[string][]string{"TeacherKen@gmail.com": {"StudentJon@gmail.com"}})
+	mustStorage(t, store.UpdateStudentSuspendedState("StudentJon@gmail.com", true))
+	createStorageRoster(t, store, map[string][]string{"teacherken@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com"}})
+	mustStorage(t, store.CreateNotification(NewNotification("TeacherKen@gmail.com", "Hello",
+		[]string{"StudentJon@gmail.com", "studenthon@gmail.com", "studentjon@gmail.com"})))
+
+	mustStorage(t, store.mergeEmailCaseDuplicates())
+
+	teachers, _, err := store.GetTeachers(ListOptions{})
+	mustStorage(t, err)
+	if len(teachers) != 1 || teachers[0].Email != "teacherken@gmail.com" {
+		t.Errorf("GetTeachers after merging: got %v, want only teacherken@gmail.com", teachers)
+	}
+	students, _, err := store.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	mustStorage(t, err)
+	sort.Strings(students)
+	if !reflect.DeepEqual(students, []string{"studenthon@gmail.com", "studentjon@gmail.com"}) {
+		t.Errorf("GetStudentsAssignedToTeacher after merging: got %v", students)
+	}
+	suspended, err := store.IsStudentSuspended("studentjon@gmail.com")
+	mustStorage(t, err)
+	if !suspended {
+		t.Errorf("the merged student is not suspended though one of the duplicates was")
+	}
+	export, err := store.GetStudentData("studentjon@gmail.com")
+	mustStorage(t, err)
+	if len(export.Notifications) != 1 || !reflect.DeepEqual(export.Notifications[0].Recipients, []string{"studentjon@gmail.com", "studenthon@gmail.com"}) {
+		t.Errorf("notifications after merging: got %+v", export.Notifications)
+	}
+
+	// once the indexes exist the tables are not scanned again, so a
+	// recipient in another case stored by hand is left as it is
+	mustStorage(t, store.CreateNotification(NewNotification("teacherken@gmail.com", "Hi", []string{"StudentHon@gmail.com"})))
+	mustStorage(t, store.mergeEmailCaseDuplicates())
+	if err := store.CreateTeacher(NewTeacher("TEACHERKEN@gmail.com")); err == nil {
+		t.Errorf("CreateTeacher: created a duplicate in another case after merging")
+	}
+	export, err = store.GetStudentData("studenthon@gmail.com")
+	mustStorage(t, err)
+	if len(export.Notifications) != 1 {
+		t.Errorf("a second merge rewrote notifications: got %+v", export.Notifications)
+	}
+}
+
+func mustStorage(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fails unless err is the error every Storage returns for a missing entry
+func expectMissingEntry(t *testing.T, what string, err error) {
+	t.Helper()
+	if err == nil || err.Error() != "entry does not exist" {
+		t.Errorf("%s: got error %v, want entry does not exist", what, err)
+	}
+}
+
+func createStorageRoster(t *testing.T, store Storage, roster map[string][]string) {
+	t.Helper()
+	created := map[string]bool{}
+	for teacherEmail, studentEmails := range roster {
+		mustStorage(t, store.CreateTeacher(NewTeacher(teacherEmail)))
+		for _, studentEmail := range studentEmails {
+			if !created[studentEmail] {
+				mustStorage(t, store.CreateStudent(NewStudent(studentEmail)))
+				created[studentEmail] = true
+			}
+			mustStorage(t, store.CreateTeacherStudent(NewTeacherStudent(teacherEmail, studentEmail)))
+		}
+	}
+}
+
+func testStorageTeachers(t *testing.T, store Storage) {
+	teacher := NewTeacher("teacherken@gmail.com")
+	teacher.Name = "Ken"
+	mustStorage(t, store.CreateTeacher(teacher))
+	if teacher.ID == 0 {
+		t.Errorf("CreateTeacher did not set the id")
+	}
+	if err := store.CreateTeacher(NewTeacher("teacherken@gmail.com")); err == nil {
+		t.Errorf("expected an error for a duplicate teacher")
+	}
+
+	exists, err := store.TeacherExists("teacherken@gmail.com")
+	mustStorage(t, err)
+	if !exists {
+		t.Errorf("TeacherExists: got false for an existing teacher")
+	}
+	exists, err = store.TeacherExists("nobody@gmail.com")
+	mustStorage(t, err)
+	if exists {
+		t.Errorf("TeacherExists: got true for a missing teacher")
+	}
+
+	got, err := store.GetTeacherByEmail("teacherken@gmail.com")
+	mustStorage(t, err)
+	if got.Email != teacher.Email || got.Name != "Ken" || got.ContactPreference != ContactByEmail {
+		t.Errorf("GetTeacherByEmail: got %+v", got)
+	}
+	_, err = store.GetTeacherByEmail("nobody@gmail.com")
+	expectMissingEntry(t, "GetTeacherByEmail", err)
+
+	got.Name = "Kenneth"
+	got.ContactPreference = ContactBySMS
+	got.UpdatedAt = time.Now().UTC()
+	mustStorage(t, store.UpdateTeacher(got))
+	updated, err := store.GetTeacherByEmail("teacherken@gmail.com")
+	mustStorage(t, err)
+	if updated.Name != "Kenneth" || updated.ContactPreference != ContactBySMS {
+		t.Errorf("UpdateTeacher did not update the profile: got %+v", updated)
+	}
+	expectMissingEntry(t, "UpdateTeacher", store.UpdateTeacher(NewTeacher("nobody@gmail.com")))
+}
+
+func testStorageTeacherPages(t *testing.T, store Storage) {
+	emails := []string{"a@gmail.com", "b@gmail.com", "c@gmail.com", "d@gmail.com", "e@gmail.com"}
+	for _, email := range emails {
+		mustStorage(t, store.CreateTeacher(NewTeacher(email)))
+	}
+
+	listed := []string{}
+	opts := ListOptions{Limit: 2}
+	for pages := 1; ; pages++ {
+		teachers, cursor, err := store.GetTeachers(opts)
+		mustStorage(t, err)
+		for _, teacher := range teachers {
+			listed = append(listed, teacher.Email)
+		}
+		if cursor == "" {
+			if pages != 3 {
+				t.Errorf("got %d pages, want 3", pages)
+			}
+			break
+		}
+		if pages > len(emails) {
+			t.Fatalf("pagination does not end")
+		}
+		opts.Cursor = cursor
+	}
+	if !reflect.DeepEqual(listed, emails) {
+		t.Errorf("GetTeachers: got %v, want %v", listed, emails)
+	}
+
+	teachers, _, err := store.GetTeachers(ListOptions{EmailPrefix: "c", Descending: true})
+	mustStorage(t, err)
+	if len(teachers) != 1 || teachers[0].Email != "c@gmail.com" {
+		t.Errorf("GetTeachers with a prefix: got %v", teachers)
+	}
+
+	// a prefix is matched literally, not as a LIKE pattern
+	teachers, _, err = store.GetTeachers(ListOptions{EmailPrefix: "_"})
+	mustStorage(t, err)
+	if len(teachers) != 0 {
+		t.Errorf("GetTeachers with prefix _: got %d teachers, want 0", len(teachers))
+	}
+}
+
+func testStorageStudents(t *testing.T, store Storage) {
+	student := NewStudent("studentjon@gmail.com")
+	mustStorage(t, store.CreateStudent(student))
+	if err := store.CreateStudent(NewStudent("studentjon@gmail.com")); err == nil {
+		t.Errorf("expected an error for a duplicate student")
+	}
+
+	exists, err := store.StudentExists("studentjon@gmail.com")
+	mustStorage(t, err)
+	if !exists {
+		t.Errorf("StudentExists: got false for an existing student")
+	}
+	exists, err = store.StudentExists("nobody@gmail.com")
+	mustStorage(t, err)
+	if exists {
+		t.Errorf("StudentExists: got true for a missing student")
+	}
+
+	_, err = store.GetStudentByEmail("nobody@gmail.com")
+	expectMissingEntry(t, "GetStudentByEmail", err)
+
+	gradeLevel := 3
+	student.Name = "Jon"
+	student.GradeLevel = &gradeLevel
+	student.UpdatedAt = time.Now().UTC()
+	mustStorage(t, store.UpdateStudent(student))
+	got, err := store.GetStudentByEmail("studentjon@gmail.com")
+	mustStorage(t, err)
+	if got.Name != "Jon" || got.GradeLevel == nil || *got.GradeLevel != 3 || got.IsSuspended {
+		t.Errorf("UpdateStudent did not update the profile: got %+v", got)
+	}
+	expectMissingEntry(t, "UpdateStudent", store.UpdateStudent(NewStudent("nobody@gmail.com")))
+
+	mustStorage(t, store.UpdateStudentSuspendedState("studentjon@gmail.com", true))
+	suspended, err := store.IsStudentSuspended("studentjon@gmail.com")
+	mustStorage(t, err)
+	if !suspended {
+		t.Errorf("IsStudentSuspended: got false after suspending")
+	}
+	// a missing student is not suspended, and suspending it changes nothing
+	mustStorage(t, store.UpdateStudentSuspendedState("nobody@gmail.com", true))
+	suspended, err = store.IsStudentSuspended("nobody@gmail.com")
+	mustStorage(t, err)
+	if suspended {
+		t.Errorf("IsStudentSuspended: got true for a missing student")
+	}
+
+	mustStorage(t, store.CreateStudent(NewStudent("studenthon@gmail.com")))
+	notSuspended := false
+	students, _, err := store.GetStudents(ListOptions{Suspended: &notSuspended})
+	mustStorage(t, err)
+	if len(students) != 1 || students[0].Email != "studenthon@gmail.com" {
+		t.Errorf("GetStudents of students not suspended: got %v", students)
+	}
+}
+
+func testStorageRegistrations(t *testing.T, store Storage) {
+	createStorageRoster(t, store, map[string][]string{
+		"teacherken@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com"},
+		"teacherjoe@gmail.com": {"studentjon@gmail.com"},
+	})
+
+	if err := store.CreateTeacherStudent(NewTeacherStudent("teacherken@gmail.com", "studentjon@gmail.com")); err == nil {
+		t.Errorf("expected an error for a duplicate registration")
+	}
+	if err := store.CreateTeacherStudent(NewTeacherStudent("nobody@gmail.com", "studentjon@gmail.com")); err == nil {
+		t.Errorf("expected an error when registering to a missing teacher")
+	}
+
+	exists, err := store.TeacherStudentExists("teacherken@gmail.com", "studenthon@gmail.com")
+	mustStorage(t, err)
+	if !exists {
+		t.Errorf("TeacherStudentExists: got false for a registration")
+	}
+
+	registration, err := store.GetTeacherStudentByEmail("teacherjoe@gmail.com", "studentjon@gmail.com")
+	mustStorage(t, err)
+	if registration.TeacherEmail != "teacherjoe@gmail.com" || registration.StudentEmail != "studentjon@gmail.com" {
+		t.Errorf("GetTeacherStudentByEmail: got %+v", registration)
+	}
+	_, err = store.GetTeacherStudentByEmail("teacherjoe@gmail.com", "studenthon@gmail.com")
+	expectMissingEntry(t, "GetTeacherStudentByEmail", err)
+
+	assigned, _, err := store.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(assigned, []string{"studenthon@gmail.com", "studentjon@gmail.com"}) {
+		t.Errorf("GetStudentsAssignedToTeacher: got %v", assigned)
+	}
+	assigned, _, err = store.GetStudentsAssignedToTeacher("nobody@gmail.com", ListOptions{})
+	mustStorage(t, err)
+	if len(assigned) != 0 {
+		t.Errorf("GetStudentsAssignedToTeacher of a missing teacher: got %v", assigned)
+	}
+
+	students, _, err := store.GetStudentsOfTeacher("teacherjoe@gmail.com", ListOptions{})
+	mustStorage(t, err)
+	if len(students) != 1 || students[0].Email != "studentjon@gmail.com" {
+		t.Errorf("GetStudentsOfTeacher: got %v", students)
+	}
+
+	teachers, _, err := store.GetTeachersOfStudent("studentjon@gmail.com", ListOptions{})
+	mustStorage(t, err)
+	if len(teachers) != 2 || teachers[0].Email != "teacherjoe@gmail.com" || teachers[1].Email != "teacherken@gmail.com" {
+		t.Errorf("GetTeachersOfStudent: got %v", teachers)
+	}
+
+	mustStorage(t, store.DeleteTeacherStudent("teacherken@gmail.com", "studentjon@gmail.com"))
+	exists, err = store.TeacherStudentExists("teacherken@gmail.com", "studentjon@gmail.com")
+	mustStorage(t, err)
+	if exists {
+		t.Errorf("TeacherStudentExists: got true after DeleteTeacherStudent")
+	}
+	expectMissingEntry(t, "DeleteTeacherStudent", store.DeleteTeacherStudent("teacherken@gmail.com", "studentjon@gmail.com"))
+}
+
+func testStorageCommonStudents(t *testing.T, store Storage) {
+	createStorageRoster(t, store, map[string][]string{
+		"teacherken@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com", "student_only_under_teacher_ken@gmail.com"},
+		"teacherjoe@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com"},
+		"teacher'o@gmail.com":  {"studentjon@gmail.com"},
+	})
+
+	if _, err := store.GetCommonStudentsOfTeachers(nil); err == nil {
+		t.Errorf("expected an error for an empty list of teachers")
+	}
+	if _, err := store.GetCommonStudentsOfTeachers([]string{}); err == nil {
+		t.Errorf("expected an error for an empty list of teachers")
+	}
+
+	for _, test := range []struct {
+		teachers []string
+		expected []string
+	}{
+		{[]string{"teacherken@gmail.com"}, []string{"student_only_under_teacher_ken@gmail.com", "studenthon@gmail.com", "studentjon@gmail.com"}},
+		{[]string{"teacherken@gmail.com", "teacherjoe@gmail.com"}, []string{"studenthon@gmail.com", "studentjon@gmail.com"}},
+		// a teacher listed twice counts once
+		{[]string{"teacherjoe@gmail.com", "teacherjoe@gmail.com"}, []string{"studenthon@gmail.com", "studentjon@gmail.com"}},
+		// emails are values, not SQL
+		{[]string{"teacher'o@gmail.com", "teacherken@gmail.com"}, []string{"studentjon@gmail.com"}},
+		{[]string{"teacherken@gmail.com", "x') OR 1=1 --"}, []string{}},
+		{[]string{"teacherken@gmail.com", "nobody@gmail.com"}, []string{}},
+	} {
+		common, err := store.GetCommonStudentsOfTeachers(test.teachers)
+		mustStorage(t, err)
+		sort.Strings(common)
+		if !reflect.DeepEqual(common, test.expected) {
+			t.Errorf("GetCommonStudentsOfTeachers(%v): got %v, want %v", test.teachers, common, test.expected)
+		}
+	}
+}
+
+func testStorageClasses(t *testing.T, store Storage) {
+	class := NewClass("1A", ClassKindClass)
+	mustStorage(t, store.CreateClass(class))
+	if class.ID == 0 {
+		t.Errorf("CreateClass did not set the id")
+	}
+	if err := store.CreateClass(NewClass("1A", ClassKindGroup)); err == nil {
+		t.Errorf("expected an error for a duplicate class")
+	}
+	mustStorage(t, store.CreateClass(NewClass("Chess", ClassKindGroup)))
+
+	exists, err := store.ClassExists("1A")
+	mustStorage(t, err)
+	if !exists {
+		t.Errorf("ClassExists: got false for an existing class")
+	}
+
+	class.Name, class.Kind = "1B", ClassKindSubject
+	mustStorage(t, store.UpdateClass("1A", class))
+	got, err := store.GetClassByName("1B")
+	mustStorage(t, err)
+	if got.ID != class.ID || got.Kind != ClassKindSubject {
+		t.Errorf("UpdateClass: got %+v", got)
+	}
+	_, err = store.GetClassByName("1A")
+	expectMissingEntry(t, "GetClassByName", err)
+	expectMissingEntry(t, "UpdateClass", store.UpdateClass("1A", class))
+
+	classes, cursor, err := store.GetClasses(ListOptions{})
+	mustStorage(t, err)
+	if len(classes) != 2 || classes[0].Name != "1B" || classes[1].Name != "Chess" || cursor != "" {
+		t.Errorf("GetClasses: got %v, cursor %q", classes, cursor)
+	}
+
+	// classes are paged by name
+	classes, cursor, err = store.GetClasses(ListOptions{Limit: 1})
+	mustStorage(t, err)
+	if len(classes) != 1 || classes[0].Name != "1B" || cursor == "" {
+		t.Fatalf("GetClasses first page: got %v, cursor %q", classes, cursor)
+	}
+	classes, cursor, err = store.GetClasses(ListOptions{Limit: 1, Cursor: cursor})
+	mustStorage(t, err)
+	if len(classes) != 1 || classes[0].Name != "Chess" || cursor != "" {
+		t.Errorf("GetClasses second page: got %v, cursor %q", classes, cursor)
+	}
+	classes, _, err = store.GetClasses(ListOptions{EmailPrefix: "Ch"})
+	mustStorage(t, err)
+	if len(classes) != 1 || classes[0].Name != "Chess" {
+		t.Errorf("GetClasses with a prefix: got %v", classes)
+	}
+
+	mustStorage(t, store.DeleteClass("Chess"))
+	expectMissingEntry(t, "DeleteClass", store.DeleteClass("Chess"))
+}
+
+func testStorageClassStudents(t *testing.T, store Storage) {
+	class := NewClass("1A", ClassKindClass)
+	mustStorage(t, store.CreateClass(class))
+	mustStorage(t, store.CreateStudent(NewStudent("studentjon@gmail.com")))
+	mustStorage(t, store.CreateStudent(NewStudent("studenthon@gmail.com")))
+
+	mustStorage(t, store.CreateClassStudent(NewClassStudent(class.ID, "studentjon@gmail.com")))
+	mustStorage(t, store.CreateClassStudent(NewClassStudent(class.ID, "studenthon@gmail.com")))
+	if err := store.CreateClassStudent(NewClassStudent(class.ID, "studentjon@gmail.com")); err == nil {
+		t.Errorf("expected an error for a duplicate class membership")
+	}
+
+	exists, err := store.ClassStudentExists(class.ID, "studentjon@gmail.com")
+	mustStorage(t, err)
+	if !exists {
+		t.Errorf("ClassStudentExists: got false for a member")
+	}
+
+	students, cursor, err := store.GetStudentsInClass(class.ID, ListOptions{})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(students, []string{"studenthon@gmail.com", "studentjon@gmail.com"}) || cursor != "" {
+		t.Errorf("GetStudentsInClass: got %v, cursor %q", students, cursor)
+	}
+
+	students, cursor, err = store.GetStudentsInClass(class.ID, ListOptions{Limit: 1})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(students, []string{"studenthon@gmail.com"}) || cursor == "" {
+		t.Fatalf("GetStudentsInClass first page: got %v, cursor %q", students, cursor)
+	}
+	students, cursor, err = store.GetStudentsInClass(class.ID, ListOptions{Limit: 1, Cursor: cursor})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(students, []string{"studentjon@gmail.com"}) || cursor != "" {
+		t.Errorf("GetStudentsInClass second page: got %v, cursor %q", students, cursor)
+	}
+
+	mustStorage(t, store.DeleteClassStudent(class.ID, "studentjon@gmail.com"))
+	expectMissingEntry(t, "DeleteClassStudent", store.DeleteClassStudent(class.ID, "studentjon@gmail.com"))
+
+	// deleting the class removes its memberships
+	mustStorage(t, store.DeleteClass("1A"))
+	students, _, err = store.GetStudentsInClass(class.ID, ListOptions{})
+	mustStorage(t, err)
+	if len(students) != 0 {
+		t.Errorf("GetStudentsInClass of a deleted class: got %v", students)
+	}
+}
+
+func testStorageRoster(t *testing.T, store Storage) {
+	mustStorage(t, store.CreateTeacher(NewTeacher("teacherken@gmail.com")))
+	mustStorage(t, store.CreateTeacher(NewTeacher("teacherzed@gmail.com")))
+
+	rows := []RosterRow{
+		{Line: 1, TeacherEmail: "teacherken@gmail.com", StudentEmail: "studentjon@gmail.com", ClassName: "1A"},
+		{Line: 2, TeacherEmail: "teacherjoe@gmail.com", StudentEmail: "studentjon@gmail.com"},
+	}
+
+	audit := func(registration TeacherStudentPair) *AuditEntry {
+		return NewAuditEntry("admin", AuditActionRegister, registration.StudentEmail, nil, "req-1")
+	}
+
+	report, err := store.ImportRoster(rows, true, audit)
+	mustStorage(t, err)
+	if !report.DryRun || len(report.NewRegistrations) != 2 {
+		t.Errorf("dry run report: got %+v", report)
+	}
+	exists, err := store.StudentExists("studentjon@gmail.com")
+	mustStorage(t, err)
+	if exists {
+		t.Fatalf("a dry run wrote to the store")
+	}
+
+	report, err = store.ImportRoster(rows, false, audit)
+	mustStorage(t, err)
+	if !reflect.DeepEqual(report.NewTeachers, []string{"teacherjoe@gmail.com"}) ||
+		!reflect.DeepEqual(report.NewStudents, []string{"studentjon@gmail.com"}) ||
+		!reflect.DeepEqual(report.NewClasses, []string{"1A"}) ||
+		len(report.NewRegistrations) != 2 || len(report.NewClassMemberships) != 1 {
+		t.Errorf("import report: got %+v", report)
+	}
+
+	// the registrations are audited, those of the dry run are not
+	entries, _, err := store.GetAuditEntries(AuditFilter{Action: AuditActionRegister})
+	mustStorage(t, err)
+	if len(entries) != 2 {
+		t.Errorf("audit entries of the import: got %v", entries)
+	}
+
+	// importing the same rows again creates nothing
+	report, err = store.ImportRoster(rows, false, audit)
+	mustStorage(t, err)
+	if len(report.NewTeachers)+len(report.NewStudents)+len(report.NewClasses)+len(report.NewRegistrations)+len(report.NewClassMemberships) != 0 {
+		t.Errorf("second import report: got %+v", report)
+	}
+
+	mustStorage(t, store.UpdateStudentSuspendedState("studentjon@gmail.com", true))
+
+	records := []RosterRecord{}
+	mustStorage(t, store.ExportRoster(func(record *RosterRecord) error {
+		records = append(records, *record)
+		return nil
+	}))
+	expected := []RosterRecord{
+		{TeacherEmail: "teacherzed@gmail.com"},
+		{TeacherEmail: "teacherjoe@gmail.com", StudentEmail: "studentjon@gmail.com", StudentSuspended: true},
+		{TeacherEmail: "teacherken@gmail.com", StudentEmail: "studentjon@gmail.com", StudentSuspended: true},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("ExportRoster: got %+v, want %+v", records, expected)
+	}
+}
+
+// a batch that fails leaves the batches before it imported and reported
+func testStorageRosterBatches(t *testing.T, store Storage) {
+	rows := make([]RosterRow, rosterBatchSize+1)
+	for i := range rows {
+		rows[i] = RosterRow{Line: i + 1, TeacherEmail: "teacherken@gmail.com", StudentEmail: fmt.Sprintf("student%d@gmail.com", i)}
+	}
+	// the entry of the last row cannot be written, actions are at most 32
+	// characters
+	last := rows[len(rows)-1].StudentEmail
+	audit := func(registration TeacherStudentPair) *AuditEntry {
+		action := AuditActionRegister
+		if registration.StudentEmail == last {
+			action = strings.Repeat("x", 40)
+		}
+		return NewAuditEntry("admin", action, registration.StudentEmail, nil, "req-1")
+	}
+
+	report, err := store.ImportRoster(rows, false, audit)
+	if !errors.Is(err, ErrAuditNotRecorded) {
+		t.Errorf("ImportRoster with an unwritable audit entry: got %v, want ErrAuditNotRecorded", err)
+	}
+	if report == nil || report.Rows != rosterBatchSize || len(report.NewRegistrations) != rosterBatchSize {
+		t.Fatalf("report of the committed batch: got %+v", report)
+	}
+	exists, err := store.StudentExists(last)
+	mustStorage(t, err)
+	if exists {
+		t.Errorf("the failed batch was imported")
+	}
+	entries, _, err := store.GetAuditEntries(AuditFilter{Action: AuditActionRegister})
+	mustStorage(t, err)
+	if len(entries) != rosterBatchSize {
+		t.Errorf("got %d audit entries, want one per registration of the committed batch", len(entries))
+	}
+}
+
+func testStorageSoftDelete(t *testing.T, store Storage) {
+	createStorageRoster(t, store, map[string][]string{
+		"teacherken@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com"},
+		"teacherjoe@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com"},
+	})
+	class := NewClass("1A", ClassKindClass)
+	mustStorage(t, store.CreateClass(class))
+	mustStorage(t, store.CreateClassStudent(NewClassStudent(class.ID, "studentjon@gmail.com")))
+
+	mustStorage(t, store.DeleteStudent("studentjon@gmail.com"))
+	expectMissingEntry(t, "DeleteStudent of a deleted student", store.DeleteStudent("studentjon@gmail.com"))
+	expectMissingEntry(t, "RestoreStudent of a student that is not deleted", store.RestoreStudent("studenthon@gmail.com"))
+
+	exists, err := store.StudentExists("studentjon@gmail.com")
+	mustStorage(t, err)
+	if exists {
+		t.Errorf("StudentExists: got true for a deleted student")
+	}
+	_, err = store.GetStudentByEmail("studentjon@gmail.com")
+	expectMissingEntry(t, "GetStudentByEmail of a deleted student", err)
+	if err := store.CreateStudent(NewStudent("studentjon@gmail.com")); err == nil {
+		t.Errorf("expected an error for the email of a deleted student")
+	}
+
+	students, _, err := store.GetStudents(ListOptions{})
+	mustStorage(t, err)
+	if len(students) != 1 || students[0].Email != "studenthon@gmail.com" {
+		t.Errorf("GetStudents: got %v, want only studenthon@gmail.com", students)
+	}
+	assigned, _, err := store.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(assigned, []string{"studenthon@gmail.com"}) {
+		t.Errorf("GetStudentsAssignedToTeacher: got %v, want [studenthon@gmail.com]", assigned)
+	}
+	common, err := store.GetCommonStudentsOfTeachers([]string{"teacherken@gmail.com", "teacherjoe@gmail.com"})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(common, []string{"studenthon@gmail.com"}) {
+		t.Errorf("GetCommonStudentsOfTeachers: got %v, want [studenthon@gmail.com]", common)
+	}
+	inClass, _, err := store.GetStudentsInClass(class.ID, ListOptions{})
+	mustStorage(t, err)
+	if len(inClass) != 0 {
+		t.Errorf("GetStudentsInClass: got %v, want no students", inClass)
+	}
+
+	mustStorage(t, store.DeleteTeacher("teacherjoe@gmail.com"))
+	exists, err = store.TeacherExists("teacherjoe@gmail.com")
+	mustStorage(t, err)
+	if exists {
+		t.Errorf("TeacherExists: got true for a deleted teacher")
+	}
+	teachers, _, err := store.GetTeachersOfStudent("studenthon@gmail.com", ListOptions{})
+	mustStorage(t, err)
+	if len(teachers) != 1 || teachers[0].Email != "teacherken@gmail.com" {
+		t.Errorf("GetTeachersOfStudent: got %v, want only teacherken@gmail.com", teachers)
+	}
+	common, err = store.GetCommonStudentsOfTeachers([]string{"teacherken@gmail.com", "teacherjoe@gmail.com"})
+	mustStorage(t, err)
+	if len(common) != 0 {
+		t.Errorf("GetCommonStudentsOfTeachers with a deleted teacher: got %v", common)
+	}
+
+	// rows naming a deleted teacher or student are skipped by imports
+	report, err := store.ImportRoster([]RosterRow{
+		{Line: 1, TeacherEmail: "teacherjoe@gmail.com", StudentEmail: "studentmay@gmail.com"},
+		{Line: 2, TeacherEmail: "teacherken@gmail.com", StudentEmail: "studentjon@gmail.com"},
+	}, false, nil)
+	mustStorage(t, err)
+	if !reflect.DeepEqual(report.Deleted, []string{"studentjon@gmail.com", "teacherjoe@gmail.com"}) || len(report.NewStudents) != 0 {
+		t.Errorf("import report: got %+v", report)
+	}
+
+	records := []RosterRecord{}
+	mustStorage(t, store.ExportRoster(func(record *RosterRecord) error {
+		records = append(records, *record)
+		return nil
+	}))
+	expected := []RosterRecord{{TeacherEmail: "teacherken@gmail.com", StudentEmail: "studenthon@gmail.com"}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("ExportRoster: got %+v, want %+v", records, expected)
+	}
+
+	// restoring brings the registrations and class memberships back
+	mustStorage(t, store.RestoreStudent("studentjon@gmail.com"))
+	mustStorage(t, store.RestoreTeacher("teacherjoe@gmail.com"))
+	common, err = store.GetCommonStudentsOfTeachers([]string{"teacherken@gmail.com", "teacherjoe@gmail.com"})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(common, []string{"studenthon@gmail.com", "studentjon@gmail.com"}) {
+		t.Errorf("GetCommonStudentsOfTeachers after restoring: got %v", common)
+	}
+	inClass, _, err = store.GetStudentsInClass(class.ID, ListOptions{})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(inClass, []string{"studentjon@gmail.com"}) {
+		t.Errorf("GetStudentsInClass after restoring: got %v", inClass)
+	}
+}
+
+// emails are stored as given, but two of them that differ only in case
+// cannot both be stored
+func testStorageEmailCase(t *testing.T, store Storage) {
+	createStorageRoster(t, store, map[string][]string{
+		"teacherken@gmail.com": {"studentjon@gmail.com"},
+	})
+	mustStorage(t, store.CreateStudent(NewStudent("studenthon@gmail.com")))
+
+	if err := store.CreateTeacher(NewTeacher("TeacherKen@gmail.com")); err == nil {
+		t.Errorf("CreateTeacher: created a teacher differing only in case")
+	}
+	if err := store.CreateStudent(NewStudent("StudentJon@gmail.com")); err == nil {
+		t.Errorf("CreateStudent: created a student differing only in case")
+	}
+	if err := store.RenameStudent("studenthon@gmail.com", "StudentJon@gmail.com"); err != ErrEmailTaken {
+		t.Errorf("renaming to a taken email in another case: got error %v, want %v", err, ErrEmailTaken)
+	}
+}
+
+func testStorageRename(t *testing.T, store Storage) {
+	createStorageRoster(t, store, map[string][]string{
+		"teacherken@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com"},
+		"teacherjoe@gmail.com": {"studentjon@gmail.com"},
+	})
+	class := NewClass("1A", ClassKindClass)
+	mustStorage(t, store.CreateClass(class))
+	mustStorage(t, store.CreateClassStudent(NewClassStudent(class.ID, "studentjon@gmail.com")))
+	mustStorage(t, store.CreateNotification(NewNotification("teacherken@gmail.com", "Hello", []string{"studentjon@gmail.com"})))
+
+	mustStorage(t, store.RenameStudent("studentjon@gmail.com", "jon@school.edu"))
+	exists, err := store.StudentExists("studentjon@gmail.com")
+	mustStorage(t, err)
+	if exists {
+		t.Errorf("StudentExists: got true for the old email")
+	}
+	teachers, _, err := store.GetTeachersOfStudent("jon@school.edu", ListOptions{})
+	mustStorage(t, err)
+	if len(teachers) != 2 {
+		t.Errorf("GetTeachersOfStudent after renaming: got %v, want 2 teachers", teachers)
+	}
+	inClass, _, err := store.GetStudentsInClass(class.ID, ListOptions{})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(inClass, []string{"jon@school.edu"}) {
+		t.Errorf("GetStudentsInClass after renaming: got %v", inClass)
+	}
+	export, err := store.GetStudentData("jon@school.edu")
+	mustStorage(t, err)
+	if len(export.Notifications) != 1 {
+		t.Errorf("notifications did not follow the rename: got %+v", export.Notifications)
+	}
+
+	mustStorage(t, store.RenameTeacher("teacherken@gmail.com", "ken@school.edu"))
+	common, err := store.GetCommonStudentsOfTeachers([]string{"ken@school.edu", "teacherjoe@gmail.com"})
+	mustStorage(t, err)
+	if !reflect.DeepEqual(common, []string{"jon@school.edu"}) {
+		t.Errorf("GetCommonStudentsOfTeachers after renaming: got %v", common)
+	}
+
+	if err := store.RenameStudent("jon@school.edu", "studenthon@gmail.com"); err != ErrEmailTaken {
+		t.Errorf("renaming to a taken email: got error %v, want %v", err, ErrEmailTaken)
+	}
+	if err := store.RenameTeacher("ken@school.edu", "teacherjoe@gmail.com"); err != ErrEmailTaken {
+		t.Errorf("renaming to a taken email: got error %v, want %v", err, ErrEmailTaken)
+	}
+	expectMissingEntry(t, "RenameStudent of a missing student", store.RenameStudent("nobody@gmail.com", "somebody@gmail.com"))
+
+	// deleted students cannot be renamed, and keep their email
+	mustStorage(t, store.DeleteStudent("studenthon@gmail.com"))
+	expectMissingEntry(t, "RenameStudent of a deleted student", store.RenameStudent("studenthon@gmail.com", "hon@school.edu"))
+	if err := store.RenameStudent("jon@school.edu", "studenthon@gmail.com"); err != ErrEmailTaken {
+		t.Errorf("renaming to the email of a deleted student: got error %v, want %v", err, ErrEmailTaken)
+	}
+}
+
+func testStorageIdempotencyKeys(t *testing.T, store Storage) {
+	record := NewIdempotencyRecord("key-1", "hash-1")
+	reserved, err := store.ReserveIdempotencyKey(record, record.CreatedAt.Add(-time.Hour), record.CreatedAt.Add(-time.Minute))
+	mustStorage(t, err)
+	if !reserved {
+		t.Fatalf("ReserveIdempotencyKey: got false for a new key")
+	}
+	reserved, err = store.ReserveIdempotencyKey(NewIdempotencyRecord("key-1", "hash-2"), record.CreatedAt.Add(-time.Hour), record.CreatedAt.Add(-time.Minute))
+	mustStorage(t, err)
+	if reserved {
+		t.Errorf("ReserveIdempotencyKey: got true for a key in use")
+	}
+
+	// a reservation without a response is abandoned after its lease
+	abandoned := NewIdempotencyRecord("key-abandoned", "hash-1")
+	_, err = store.ReserveIdempotencyKey(abandoned, abandoned.CreatedAt.Add(-time.Hour), abandoned.CreatedAt.Add(-time.Minute))
+	mustStorage(t, err)
+	reserved, err = store.ReserveIdempotencyKey(NewIdempotencyRecord("key-abandoned", "hash-1"), abandoned.CreatedAt.Add(-time.Hour), time.Now().UTC().Add(time.Minute))
+	mustStorage(t, err)
+	if !reserved {
+		t.Errorf("ReserveIdempotencyKey: got false for an abandoned key")
+	}
+
+	got, err := store.GetIdempotencyRecord("key-1")
+	mustStorage(t, err)
+	if got.RequestHash != "hash-1" || got.StatusCode != 0 {
+		t.Errorf("GetIdempotencyRecord of a reserved key: got %+v", got)
+	}
+	_, err = store.GetIdempotencyRecord("key-2")
+	expectMissingEntry(t, "GetIdempotencyRecord", err)
+
+	record.StatusCode, record.ContentType, record.Body = 200, "application/json", []byte(`{"recipients":[]}`)
+	mustStorage(t, store.SaveIdempotencyResponse(record))
+	got, err = store.GetIdempotencyRecord("key-1")
+	mustStorage(t, err)
+	if got.StatusCode != 200 || got.ContentType != "application/json" || !bytes.Equal(got.Body, record.Body) {
+		t.Errorf("GetIdempotencyRecord of a completed key: got %+v", got)
+	}
+	expectMissingEntry(t, "SaveIdempotencyResponse", store.SaveIdempotencyResponse(NewIdempotencyRecord("key-2", "")))
+
+	// a key with a response is kept past the lease, until it expires
+	reserved, err = store.ReserveIdempotencyKey(NewIdempotencyRecord("key-1", "hash-3"), record.CreatedAt.Add(-time.Hour), time.Now().UTC().Add(time.Minute))
+	mustStorage(t, err)
+	if reserved {
+		t.Errorf("ReserveIdempotencyKey: got true for a completed key past the lease")
+	}
+	reserved, err = store.ReserveIdempotencyKey(NewIdempotencyRecord("key-1", "hash-3"), time.Now().UTC().Add(time.Hour), time.Now().UTC().Add(time.Hour))
+	mustStorage(t, err)
+	if !reserved {
+		t.Errorf("ReserveIdempotencyKey: got false for an expired key")
+	}
+
+	mustStorage(t, store.DeleteIdempotencyKey("key-1"))
+	_, err = store.GetIdempotencyRecord("key-1")
+	expectMissingEntry(t, "GetIdempotencyRecord after DeleteIdempotencyKey", err)
+}
+
+func testStorageAuditLog(t *testing.T, store Storage) {
+	entries := []*AuditEntry{
+		NewAuditEntry("teacherken@gmail.com", AuditActionRegister, "studentjon@gmail.com", []byte(`{"teacher":"teacherken@gmail.com"}`), "req-1"),
+		NewAuditEntry("admin", AuditActionSuspend, "studentjon@gmail.com", nil, "req-2"),
+		NewAuditEntry("admin", AuditActionSuspend, "studenthon@gmail.com", nil, "req-3"),
+	}
+	mustStorage(t, store.CreateAuditEntries(entries))
+	for _, entry := range entries {
+		if entry.ID == 0 {
+			t.Errorf("CreateAuditEntries did not set the id")
+		}
+	}
+
+	got, cursor, err := store.GetAuditEntries(AuditFilter{Target: "studentjon@gmail.com", Limit: 1})
+	mustStorage(t, err)
+	if len(got) != 1 || got[0].RequestID != "req-2" || cursor == "" {
+		t.Fatalf("first page of the audit log of a target: got %v, cursor %q", got, cursor)
+	}
+	got, cursor, err = store.GetAuditEntries(AuditFilter{Target: "studentjon@gmail.com", Limit: 1, Cursor: cursor})
+	mustStorage(t, err)
+	if len(got) != 1 || got[0].RequestID != "req-1" || cursor != "" {
+		t.Errorf("last page of the audit log of a target: got %v, cursor %q", got, cursor)
+	}
+	if len(got) == 1 && !strings.Contains(string(got[0].Payload), "teacherken@gmail.com") {
+		t.Errorf("audit payload was not kept: got %s", got[0].Payload)
+	}
+
+	got, _, err = store.GetAuditEntries(AuditFilter{Actor: "admin", Action: AuditActionSuspend})
+	mustStorage(t, err)
+	if len(got) != 2 || got[0].Target != "studenthon@gmail.com" {
+		t.Errorf("audit log of an actor: got %v", got)
+	}
+
+	future := time.Now().Add(time.Hour)
+	got, _, err = store.GetAuditEntries(AuditFilter{CreatedAfter: &future})
+	mustStorage(t, err)
+	if len(got) != 0 {
+		t.Errorf("audit log after now: got %v", got)
+	}
+
+	if _, _, err := store.GetAuditEntries(AuditFilter{Cursor: "not a cursor"}); err == nil {
+		t.Errorf("expected an error for an invalid cursor")
+	}
+
+	// a change writes its entries in its own transaction
+	mustStorage(t, store.CreateStudent(NewStudent("studentmay@gmail.com")))
+	entry := NewAuditEntry("http:192.0.2.1", AuditActionDelete, "studentmay@gmail.com", nil, "req-4")
+	entry.ClaimedActor = "admin"
+	mustStorage(t, store.DeleteStudent("studentmay@gmail.com", entry))
+	got, _, err = store.GetAuditEntries(AuditFilter{Target: "studentmay@gmail.com"})
+	mustStorage(t, err)
+	if len(got) != 1 || got[0].Actor != "http:192.0.2.1" || got[0].ClaimedActor != "admin" {
+		t.Errorf("audit entry of a deletion: got %v", got)
+	}
+
+	// nothing is recorded for a change that was not made, and a change whose
+	// entry cannot be written is not made. actions are at most 32 characters
+	expectMissingEntry(t, "DeleteStudent of a deleted student", store.DeleteStudent("studentmay@gmail.com",
+		NewAuditEntry("admin", AuditActionDelete, "studentmay@gmail.com", nil, "req-5")))
+	unwritable := NewAuditEntry("admin", strings.Repeat("x", 40), "studentmay@gmail.com", nil, "req-6")
+	if err := store.RestoreStudent("studentmay@gmail.com", unwritable); !errors.Is(err, ErrAuditNotRecorded) {
+		t.Errorf("RestoreStudent with an unwritable audit entry: got %v, want ErrAuditNotRecorded", err)
+	}
+	got, _, err = store.GetAuditEntries(AuditFilter{Target: "studentmay@gmail.com"})
+	mustStorage(t, err)
+	exists, err := store.StudentExists("studentmay@gmail.com")
+	mustStorage(t, err)
+	if len(got) != 1 || exists {
+		t.Errorf("after failed changes: got audit entries %v and student exists %v", got, exists)
+	}
+}
+
+func testStorageNotifications(t *testing.T, store Storage) {
+	notification := NewNotification("teacherken@gmail.com", "Hello", []string{"studentjon@gmail.com"})
+	entry := NewAuditEntry("teacherken@gmail.com", AuditActionNotify, "teacherken@gmail.com", []byte(`{"notification":"Hello"}`), "req-1")
+	mustStorage(t, store.CreateNotification(notification, entry))
+	if notification.ID == 0 {
+		t.Errorf("CreateNotification did not set the id")
+	}
+
+	entries, _, err := store.GetAuditEntries(AuditFilter{Action: AuditActionNotify})
+	mustStorage(t, err)
+	if len(entries) != 1 || !strings.Contains(string(entries[0].Payload), fmt.Sprintf(`"notification_id": %d`, notification.ID)) {
+		t.Errorf("audit entries of the notification: got %v", entries)
+	}
+}
+
+func testStorageStudentData(t *testing.T, store Storage) {
+	createStorageRoster(t, store, map[string][]string{
+		"teacherken@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com"},
+	})
+	class := NewClass("1A", ClassKindClass)
+	mustStorage(t, store.CreateClass(class))
+	mustStorage(t, store.CreateClassStudent(NewClassStudent(class.ID, "studentjon@gmail.com")))
+	mustStorage(t, store.CreateNotification(NewNotification("teacherken@gmail.com",
+		"Hello @studentjon@gmail.com and @studentjon@gmail.community, write to StudentJon@gmail.com.",
+		[]string{"studentjon@gmail.com", "studenthon@gmail.com"})))
+	mustStorage(t, store.CreateAuditEntries([]*AuditEntry{
+		NewAuditEntry("admin", AuditActionSuspend, "studentjon@gmail.com", nil, "req-1"),
+		NewAuditEntry("teacherken@gmail.com", AuditActionNotify, "teacherken@gmail.com",
+			[]byte(`{"notification":"Hello @studentjon@gmail.com, write to studentjon@gmail.com","recipients":["studentjon@gmail.com","studenthon@gmail.com"]}`), "req-2"),
+		NewAuditEntry("admin", AuditActionSuspend, "studenthon@gmail.com", nil, "req-3"),
+	}))
+	// stored responses naming the student are dropped, in any case
+	for key, body := range map[string]string{"key-jon": `{"recipients":["StudentJon@gmail.com"]}`, "key-hon": `{"recipients":["studenthon@gmail.com"]}`} {
+		record := NewIdempotencyRecord(key, "hash")
+		_, err := store.ReserveIdempotencyKey(record, record.CreatedAt.Add(-time.Hour), record.CreatedAt.Add(-time.Minute))
+		mustStorage(t, err)
+		record.StatusCode, record.ContentType, record.Body = 200, "application/json", []byte(body)
+		mustStorage(t, store.SaveIdempotencyResponse(record))
+	}
+	// deleted students are exported too
+	mustStorage(t, store.DeleteStudent("studentjon@gmail.com"))
+
+	export, err := store.GetStudentData("studentjon@gmail.com")
+	mustStorage(t, err)
+	if export.Student.Email != "studentjon@gmail.com" || export.DeletedAt == nil ||
+		!reflect.DeepEqual(export.Teachers, []string{"teacherken@gmail.com"}) ||
+		!reflect.DeepEqual(export.Classes, []string{"1A"}) ||
+		len(export.Notifications) != 1 || len(export.AuditEntries) != 2 {
+		t.Errorf("GetStudentData: got %+v", export)
+	}
+	_, err = store.GetStudentData("nobody@gmail.com")
+	expectMissingEntry(t, "GetStudentData", err)
+
+	erased, err := store.EraseStudent("studentjon@gmail.com")
+	mustStorage(t, err)
+	_, err = store.GetStudentData("studentjon@gmail.com")
+	expectMissingEntry(t, "GetStudentData of an erased student", err)
+	_, err = store.EraseStudent("studentjon@gmail.com")
+	expectMissingEntry(t, "EraseStudent of an erased student", err)
+	if err := store.RestoreStudent(erased); err == nil {
+		t.Errorf("RestoreStudent: restored an erased student")
+	}
+	_, err = store.GetIdempotencyRecord("key-jon")
+	expectMissingEntry(t, "GetIdempotencyRecord of a response naming the erased student", err)
+	_, err = store.GetIdempotencyRecord("key-hon")
+	mustStorage(t, err)
+
+	// the rows are kept under the anonymous email
+	export, err = store.GetStudentData(erased)
+	mustStorage(t, err)
+	if export.Student.Name != "" || len(export.Teachers) != 1 || len(export.Classes) != 1 ||
+		len(export.Notifications) != 1 || len(export.AuditEntries) != 2 {
+		t.Errorf("GetStudentData of the anonymous email: got %+v", export)
+	}
+	for _, entry := range export.AuditEntries {
+		if strings.Contains(string(entry.Payload), "studentjon@gmail.com") || entry.Target == "studentjon@gmail.com" {
+			t.Errorf("audit entry still holds the erased email: %+v", entry)
+		}
+	}
+	expectedText := "Hello @" + erased + " and @studentjon@gmail.community, write to " + erased + "."
+	if notification := export.Notifications[0]; notification.Text != expectedText ||
+		!reflect.DeepEqual(notification.Recipients, []string{erased, "studenthon@gmail.com"}) {
+		t.Errorf("notification still holds the erased email: %+v", notification)
+	}
+
+	// other students are left alone
+	export, err = store.GetStudentData("studenthon@gmail.com")
+	mustStorage(t, err)
+	if len(export.Notifications) != 1 || len(export.AuditEntries) != 2 {
+		t.Errorf("GetStudentData of another student: got %+v", export)
+	}
+}
+
+// exports and erasure follow a student back through their renames, to the
+// rows from while they had each email
+func testStorageRenamedStudentData(t *testing.T, store Storage) {
+	createStorageRoster(t, store, map[string][]string{"teacherken@gmail.com": {"studentjon@gmail.com"}})
+	mustStorage(t, store.CreateNotification(NewNotification("teacherken@gmail.com", "Hello @studentjon@gmail.com", []string{"studentjon@gmail.com"}),
+		NewAuditEntry("teacherken@gmail.com", AuditActionNotify, "teacherken@gmail.com",
+			[]byte(`{"notification":"Hello @studentjon@gmail.com","recipients":["studentjon@gmail.com"]}`), "req-1")))
+	mustStorage(t, store.UpdateStudentSuspendedState("studentjon@gmail.com", true,
+		NewAuditEntry("admin", AuditActionSuspend, "studentjon@gmail.com", nil, "req-2")))
+
+	rename := func(email string, newEmail string, requestID string) {
+		t.Helper()
+		payload := []byte(`{"kind":"student","previous_email":"` + email + `"}`)
+		mustStorage(t, store.RenameStudent(email, newEmail, NewAuditEntry("admin", AuditActionRename, newEmail, payload, requestID)))
+	}
+	rename("studentjon@gmail.com", "jon@school.edu", "req-3")
+	// someone else takes the old email, their rows are not the student's
+	mustStorage(t, store.CreateStudent(NewStudent("studentjon@gmail.com")))
+	mustStorage(t, store.UpdateStudentSuspendedState("studentjon@gmail.com", true,
+		NewAuditEntry("admin", AuditActionSuspend, "studentjon@gmail.com", nil, "req-4")))
+	rename("jon@school.edu", "jon@college.edu", "req-5")
+
+	export, err := store.GetStudentData("jon@college.edu")
+	mustStorage(t, err)
+	requestIDs := []string{}
+	for _, entry := range export.AuditEntries {
+		requestIDs = append(requestIDs, entry.RequestID)
+	}
+	if !reflect.DeepEqual(requestIDs, []string{"req-1", "req-2", "req-3", "req-5"}) {
+		t.Errorf("GetStudentData after renames: got the audit entries of requests %v", requestIDs)
+	}
+
+	erased, err := store.EraseStudent("jon@college.edu",
+		NewAuditEntry("admin", AuditActionErase, "jon@college.edu", nil, "req-6"))
+	mustStorage(t, err)
+
+	entries, _, err := store.GetAuditEntries(AuditFilter{})
+	mustStorage(t, err)
+	for _, entry := range entries {
+		if entry.RequestID == "req-4" {
+			if entry.Target != "studentjon@gmail.com" {
+				t.Errorf("the entry of the new owner of the old email was erased: %+v", entry)
+			}
+			continue
+		}
+		for _, email := range []string{"studentjon@gmail.com", "jon@school.edu", "jon@college.edu"} {
+			if entry.Actor == email || entry.Target == email || strings.Contains(string(entry.Payload), email) {
+				t.Errorf("audit entry still holds %s after the erasure: %+v", email, entry)
+			}
+		}
+	}
+
+	export, err = store.GetStudentData(erased)
+	mustStorage(t, err)
+	if len(export.Notifications) != 1 || export.Notifications[0].Text != "Hello @"+erased {
+		t.Errorf("notifications after the erasure: got %+v", export.Notifications)
+	}
+	export, err = store.GetStudentData("studentjon@gmail.com")
+	mustStorage(t, err)
+	if len(export.AuditEntries) != 1 || export.AuditEntries[0].RequestID != "req-4" {
+		t.Errorf("GetStudentData of the new owner of the old email: got %+v", export.AuditEntries)
+	}
+}
+
+func testStorageWebhooks(t *testing.T, store Storage) {
+	webhook := NewWebhookSubscription("https://example.com/hook", []string{EventStudentSuspended}, "s3cret")
+	mustStorage(t, store.CreateWebhook(webhook))
+	mustStorage(t, store.CreateWebhook(NewWebhookSubscription("https://example.com/all", nil, "s3cret")))
+
+	webhooks, err := store.GetWebhooks()
+	mustStorage(t, err)
+	if len(webhooks) != 2 || webhooks[0].ID != webhook.ID || webhooks[0].Secret != "s3cret" ||
+		!reflect.DeepEqual(webhooks[0].EventTypes, []string{EventStudentSuspended}) || len(webhooks[1].EventTypes) != 0 {
+		t.Errorf("GetWebhooks: got %+v", webhooks)
+	}
+
+	got, err := store.GetWebhook(webhook.ID)
+	mustStorage(t, err)
+	if got.URL != webhook.URL {
+		t.Errorf("GetWebhook: got %+v", got)
+	}
+	_, err = store.GetWebhook(webhook.ID + 100)
+	expectMissingEntry(t, "GetWebhook", err)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		mustStorage(t, store.CreateWebhookDelivery(&WebhookDelivery{
+			WebhookID: webhook.ID,
+			EventID:   "event-1",
+			EventType: EventStudentSuspended,
+			Attempt:   attempt,
+			Success:   attempt == 3,
+			CreatedAt: time.Now().UTC(),
+		}))
+	}
+	deliveries, err := store.GetWebhookDeliveries(webhook.ID, 2)
+	mustStorage(t, err)
+	if len(deliveries) != 2 || deliveries[0].Attempt != 3 || !deliveries[0].Success || deliveries[1].Attempt != 2 {
+		t.Errorf("GetWebhookDeliveries: got %+v", deliveries)
+	}
+
+	mustStorage(t, store.DeleteWebhook(webhook.ID))
+	expectMissingEntry(t, "DeleteWebhook", store.DeleteWebhook(webhook.ID))
+	deliveries, err = store.GetWebhookDeliveries(webhook.ID, 10)
+	mustStorage(t, err)
+	if len(deliveries) != 0 {
+		t.Errorf("deliveries of a deleted webhook were kept: got %v", deliveries)
+	}
+}
+
+func testStorageConcurrentInserts(t *testing.T, store Storage) {
+	const workers = 10
+	mustStorage(t, store.CreateTeacher(NewTeacher("teacherken@gmail.com")))
+
+	// the same student created at once by every worker exists only once
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.CreateStudent(NewStudent("studentjon@gmail.com"))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("concurrent CreateStudent of one student: %d succeeded, want 1", succeeded)
+	}
+
+	// different students registered at once are all kept
+	for i := 0; i < workers; i++ {
+		mustStorage(t, store.CreateStudent(NewStudent(string(rune('a'+i))+"@gmail.com")))
+	}
+	errs = make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(studentEmail string) {
+			defer wg.Done()
+			errs <- store.CreateTeacherStudent(NewTeacherStudent("teacherken@gmail.com", studentEmail))
+		}(string(rune('a'+i)) + "@gmail.com")
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent CreateTeacherStudent: %v", err)
+		}
+	}
+	students, _, err := store.GetStudentsAssignedToTeacher("teacherken@gmail.com", ListOptions{})
+	mustStorage(t, err)
+	if len(students) != workers {
+		t.Errorf("got %d students registered, want %d", len(students), workers)
+	}
+}
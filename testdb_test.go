@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// the tests that need a database get an empty schema of their own on a
+// Postgres server launched for the test run, so they neither depend on each
+// other nor touch the database .env points at. set
+// TEST_POSTGRESQL_CONNECTION_STRING to use an existing server instead (the
+// schemas are dropped afterwards). without either the tests are skipped,
+// each with the reason (see go test -v), unless TEST_REQUIRE_DATABASE is
+// set: CI sets it so the run fails instead of passing without them
+
+// the connection string of the test server, empty when there is none
+var testDBConnString string
+
+// why there is no test server
+var testDBSkipReason string
+
+func TestMain(m *testing.M) {
+	os.Exit(runTestsWithDatabase(m))
+}
+
+func runTestsWithDatabase(m *testing.M) int {
+	if connStr := os.Getenv("TEST_POSTGRESQL_CONNECTION_STRING"); connStr != "" {
+		testDBConnString = connStr
+		return m.Run()
+	}
+
+	stop, err := startTestPostgres()
+	if err != nil {
+		testDBSkipReason = "no test database: " + err.Error()
+		if os.Getenv("TEST_REQUIRE_DATABASE") != "" {
+			fmt.Fprintf(os.Stderr, "TEST_REQUIRE_DATABASE is set but there is %s\n", testDBSkipReason)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "skipping the tests that need a database, %s\n", testDBSkipReason)
+		return m.Run()
+	}
+	defer stop()
+
+	return m.Run()
+}
+
+// directories Postgres binaries are usually installed in when they are not
+// on the PATH
+var postgresBinGlobs = []string{
+	"/usr/lib/postgresql/*/bin",
+	"/usr/local/pgsql/bin",
+	"/usr/pgsql-*/bin",
+	"/opt/homebrew/opt/postgresql*/bin",
+	"/usr/local/opt/postgresql*/bin",
+}
+
+func findPostgresBin() (string, error) {
+	if path, err := exec.LookPath("pg_ctl"); err == nil {
+		return filepath.Dir(path), nil
+	}
+	for _, glob := range postgresBinGlobs {
+		matches, _ := filepath.Glob(glob)
+		for i := len(matches) - 1; i >= 0; i-- {
+			if _, err := os.Stat(filepath.Join(matches[i], "pg_ctl")); err == nil {
+				return matches[i], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("postgres is not installed, set TEST_POSTGRESQL_CONNECTION_STRING to use an existing server")
+}
+
+// launches a throwaway Postgres server that only listens on a unix socket
+// in a temporary directory, and returns the function that stops it
+func startTestPostgres() (func(), error) {
+	bin, err := findPostgresBin()
+	if err != nil {
+		return nil, err
+	}
+	if os.Geteuid() == 0 {
+		return nil, fmt.Errorf("postgres cannot run as root, set TEST_POSTGRESQL_CONNECTION_STRING to use an existing server")
+	}
+
+	dir, err := os.MkdirTemp("", "school-test-postgres")
+	if err != nil {
+		return nil, err
+	}
+	dataDir := filepath.Join(dir, "data")
+
+	// the port only names the socket, but must not clash with another server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	initdb := exec.Command(filepath.Join(bin, "initdb"), "-D", dataDir, "-U", "postgres", "-A", "trust", "--no-sync")
+	if out, err := initdb.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("initdb: %v: %s", err, out)
+	}
+
+	options := fmt.Sprintf("-k %s -p %d -c listen_addresses='' -F", dir, port)
+	start := exec.Command(filepath.Join(bin, "pg_ctl"), "-D", dataDir, "-o", options, "-l", filepath.Join(dir, "postgres.log"), "-w", "start")
+	if out, err := start.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+
+	testDBConnString = fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port)
+
+	return func() {
+		exec.Command(filepath.Join(bin, "pg_ctl"), "-D", dataDir, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+var testSchemaCount atomic.Int64
+
+var nonIdentifierChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// a store using a new schema with every table created by Init. the schema
+// is dropped when the test ends. skips the test when there is no database
+func newTestStore(t testing.TB) *PostgresStore {
+	t.Helper()
+	if testDBConnString == "" {
+		t.Skip(testDBSkipReason)
+	}
+
+	ctx := context.Background()
+	admin, err := pgxpool.New(ctx, testDBConnString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(admin.Close)
+
+	// schema names are lowercase identifiers of at most 63 bytes
+	name := nonIdentifierChars.ReplaceAllString(strings.ToLower(t.Name()), "_")
+	schema := "test_" + strconv.FormatInt(testSchemaCount.Add(1), 10) + "_" + name
+	if len(schema) > 63 {
+		schema = schema[:63]
+	}
+
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("dropping schema %s: %v", schema, err)
+		}
+	})
+
+	poolConfig, err := pgxpool.ParseConfig(testDBConnString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolConfig.MaxConns = 3
+	poolConfig.ConnConfig.RuntimeParams["search_path"] = schema
+
+	store, err := NewPostgresStoreWithConfig(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// registered after the schema cleanup, so it runs before it
+	t.Cleanup(store.dbPool.Close)
+
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -14,6 +15,11 @@ type RegisterStudentsToTeacherRequest struct {
 	StudentEmails []string `json:"students"`
 }
 
+type DeregisterStudentsRequest struct {
+	TeacherEmail  string   `json:"teacher"`
+	StudentEmails []string `json:"students"`
+}
+
 type CreateTeacherRequest struct {
 	Email string `json:"email"`
 }
@@ -27,6 +33,36 @@ type StudentsToGetNotificationRequest struct {
 	NotificationString string `json:"notification"`
 }
 
+type CreateClassRequest struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type UpdateClassRequest struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type ClassStudentsRequest struct {
+	StudentEmails []string `json:"students"`
+}
+
+type RegisterClassToTeacherRequest struct {
+	TeacherEmail string `json:"teacher"`
+}
+
+// fields left out of a profile update request are not changed
+type UpdateTeacherRequest struct {
+	Name              *string `json:"name"`
+	ContactPreference *string `json:"contact_preference"`
+}
+
+type UpdateStudentRequest struct {
+	Name              *string `json:"name"`
+	GradeLevel        *int    `json:"grade_level"`
+	ContactPreference *string `json:"contact_preference"`
+}
+
 // response types
 type CommonStudentsResponse struct {
 	StudentEmails []string `json:"students"`
@@ -34,43 +70,95 @@ type CommonStudentsResponse struct {
 type NotifiedStudentsResponse struct {
 	StudentEmails []string `json:"recipients"`
 }
+type TeachersResponse struct {
+	Teachers   []*Teacher `json:"teachers"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+type StudentsResponse struct {
+	Students   []*Student `json:"students"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+type ClassesResponse struct {
+	Classes    []*Class `json:"classes"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+type ClassStudentsResponse struct {
+	StudentEmails []string `json:"students"`
+	NextCursor    string   `json:"next_cursor,omitempty"`
+}
+
+// contact preferences of a teacher or student
+const (
+	ContactByEmail = "email"
+	ContactBySMS   = "sms"
+	ContactByNone  = "none"
+)
+
+func IsValidContactPreference(preference string) bool {
+	return preference == ContactByEmail || preference == ContactBySMS || preference == ContactByNone
+}
 
 // teacher
 type Teacher struct {
-	// ID        int       `json:id`
-	Email     string    `json:email`
-	CreatedAt time.Time `json:created_at`
+	ID                int64     `json:"id"`
+	Email             string    `json:"email"`
+	Name              string    `json:"name"`
+	ContactPreference string    `json:"contact_preference"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
 func NewTeacher(email string) *Teacher {
+	now := time.Now().UTC()
 	return &Teacher{
-		Email:     email,
-		CreatedAt: time.Now().UTC(),
+		Email:             email,
+		ContactPreference: ContactByEmail,
+		CreatedAt:         now,
+		UpdatedAt:         now,
 	}
 }
 
 // student
 type Student struct {
-	// ID        int       `json:id`
-	Email       string    `json:email`
-	IsSuspended bool      `json:is_suspended`
-	CreatedAt   time.Time `json:created_at`
+	ID                int64     `json:"id"`
+	Email             string    `json:"email"`
+	Name              string    `json:"name"`
+	GradeLevel        *int      `json:"grade_level"`
+	ContactPreference string    `json:"contact_preference"`
+	IsSuspended       bool      `json:"is_suspended"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
 func NewStudent(email string) *Student {
+	now := time.Now().UTC()
 	return &Student{
-		Email:       email,
-		IsSuspended: false,
-		CreatedAt:   time.Now().UTC(),
+		Email:             email,
+		ContactPreference: ContactByEmail,
+		IsSuspended:       false,
+		CreatedAt:         now,
+		UpdatedAt:         now,
 	}
 }
 
+// options of the list queries. a zero value lists every row ordered by email
+type ListOptions struct {
+	Limit         int    // maximum number of rows returned, no limit if 0
+	Cursor        string // next_cursor of the previous page
+	Sort          string // SortByEmail or SortByCreatedAt
+	Descending    bool
+	EmailPrefix   string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Suspended     *bool // only applies to students
+}
+
 // teacher-student
 type TeacherStudent struct {
-	// ID        int       `json:id`
-	TeacherEmail string    `json:teacher_email`
-	StudentEmail string    `json:student_email`
-	CreatedAt    time.Time `json:created_at`
+	// ID        int       `json:"id"`
+	TeacherEmail string    `json:"teacher_email"`
+	StudentEmail string    `json:"student_email"`
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 func NewTeacherStudent(teacher_email, student_email string) *TeacherStudent {
@@ -80,3 +168,223 @@ func NewTeacherStudent(teacher_email, student_email string) *TeacherStudent {
 		CreatedAt:    time.Now().UTC(),
 	}
 }
+
+// class
+// a class, subject or extracurricular group that students can belong to
+const (
+	ClassKindClass   = "class"
+	ClassKindSubject = "subject"
+	ClassKindGroup   = "group"
+)
+
+type Class struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewClass(name, kind string) *Class {
+	if kind == "" {
+		kind = ClassKindClass
+	}
+	return &Class{
+		Name:      name,
+		Kind:      kind,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func IsValidClassKind(kind string) bool {
+	return kind == ClassKindClass || kind == ClassKindSubject || kind == ClassKindGroup
+}
+
+// class-student
+type ClassStudent struct {
+	ClassID      int       `json:"class_id"`
+	StudentEmail string    `json:"student_email"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func NewClassStudent(class_id int, student_email string) *ClassStudent {
+	return &ClassStudent{
+		ClassID:      class_id,
+		StudentEmail: student_email,
+		CreatedAt:    time.Now().UTC(),
+	}
+}
+
+// idempotency key
+// the response of a POST request sent with an Idempotency-Key header, kept
+// so a retry of the same request gets the same response. StatusCode is 0
+// while the first request is still being handled
+type IdempotencyRecord struct {
+	Key         string    `json:"key"`
+	RequestHash string    `json:"request_hash"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func NewIdempotencyRecord(key, requestHash string) *IdempotencyRecord {
+	return &IdempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+// audit
+// the actions recorded in the audit log
+const (
+	AuditActionRegister   = "register"
+	AuditActionDeregister = "deregister"
+	AuditActionSuspend    = "suspend"
+	AuditActionUnsuspend  = "unsuspend"
+	AuditActionNotify     = "notify"
+	AuditActionDelete     = "delete"
+	AuditActionRestore    = "restore"
+	AuditActionErase      = "erase"
+	AuditActionRename     = "rename"
+)
+
+type AuditEntry struct {
+	ID    int64  `json:"id"`
+	Actor string `json:"actor"`
+	// unverified, see AuditInfo
+	ClaimedActor string          `json:"claimed_actor,omitempty"`
+	Action       string          `json:"action"`
+	Target       string          `json:"target"`
+	Payload      json.RawMessage `json:"payload"`
+	RequestID    string          `json:"request_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+func NewAuditEntry(actor, action, target string, payload json.RawMessage, requestID string) *AuditEntry {
+	return &AuditEntry{
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		Payload:   payload,
+		RequestID: requestID,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// filters of an audit log query, empty fields match every entry. entries
+// are listed newest first
+type AuditFilter struct {
+	Actor         string
+	Target        string
+	Action        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Limit         int
+	Cursor        string
+}
+
+type AuditResponse struct {
+	Entries    []*AuditEntry `json:"entries"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// everything stored about a student, for a parent asking for the data held
+// about their child
+type StudentDataExport struct {
+	Student *Student `json:"student"`
+	// set if the student is deleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Teachers  []string   `json:"teachers"`
+	Classes   []string   `json:"classes"`
+	// the suspend and unsuspend entries of the audit log, oldest first
+	Suspensions   []*AuditEntry   `json:"suspensions"`
+	Notifications []*Notification `json:"notifications"`
+	AuditEntries  []*AuditEntry   `json:"audit_entries"`
+	ExportedAt    time.Time       `json:"exported_at"`
+}
+
+type RenameRequest struct {
+	Email string `json:"email"`
+}
+
+type EraseStudentResponse struct {
+	ErasedEmail string `json:"erased_email"`
+}
+
+// notification
+type Notification struct {
+	ID           int64     `json:"id"`
+	TeacherEmail string    `json:"teacher"`
+	Text         string    `json:"notification"`
+	Recipients   []string  `json:"recipients"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func NewNotification(teacherEmail, text string, recipients []string) *Notification {
+	if recipients == nil {
+		recipients = []string{}
+	}
+	return &Notification{
+		TeacherEmail: teacherEmail,
+		Text:         text,
+		Recipients:   recipients,
+		CreatedAt:    time.Now().UTC(),
+	}
+}
+
+// webhook
+type CreateWebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"`
+}
+
+// an endpoint that events are pushed to. an empty EventTypes subscribes to
+// every event type. the secret signs the deliveries and is only shown when
+// the subscription is created
+type WebhookSubscription struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func NewWebhookSubscription(url string, eventTypes []string, secret string) *WebhookSubscription {
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	return &WebhookSubscription{
+		URL:        url,
+		EventTypes: eventTypes,
+		Secret:     secret,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+func (w *WebhookSubscription) Wants(eventType string) bool {
+	return len(w.EventTypes) == 0 || StringExistsInArray(eventType, w.EventTypes)
+}
+
+// one attempt to deliver an event to a webhook. StatusCode is 0 when no
+// response was received
+type WebhookDelivery struct {
+	ID         int64     `json:"id"`
+	WebhookID  int64     `json:"webhook_id"`
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []*WebhookSubscription `json:"webhooks"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+}
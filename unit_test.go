@@ -2,43 +2,39 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/gorilla/mux"
 )
 
-func init() {
-	// loads values from .env into the system
-	if err := godotenv.Load(); err != nil {
-		log.Print("No .env file found")
-	}
-}
+// the roster most handler tests start from: teacherken and teacherjoe
+// share studentjon and studenthon, and each has a student of their own
+func seedTestRoster(t *testing.T, server *APIServer) {
+	t.Helper()
 
-func TestRegister(t *testing.T) {
-	store, err := NewPostgresStore()
-	if err != nil {
-		t.Fatal(err)
-		return
+	roster := map[string][]string{
+		"teacherken@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com", "student_only_under_teacher_ken@gmail.com"},
+		"teacherjoe@gmail.com": {"studentjon@gmail.com", "studenthon@gmail.com", "student_only_under_teacher_joe@gmail.com"},
 	}
-
-	if err := store.Init(); err != nil {
-		t.Fatal(err)
-		return
+	for teacher, students := range roster {
+		if err := server.service.RegisterStudents(context.Background(), teacher, students); err != nil {
+			t.Fatal(err)
+		}
 	}
+}
 
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		port = "3000"
-	}
-	server := NewAPIServer(":"+port, store)
+func TestRegister(t *testing.T) {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
 
 	requestBody := []byte(`{
         "teacher": "teacherken@gmail.com",
@@ -103,22 +99,9 @@ func TestRegister(t *testing.T) {
 }
 
 func TestCommonStudents1(t *testing.T) {
-	store, err := NewPostgresStore()
-	if err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	if err := store.Init(); err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		port = "3000"
-	}
-	server := NewAPIServer(":"+port, store)
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
 
 	// Define the query parameter
 	queryParam := "teacher=teacherken%40gmail.com"
@@ -185,22 +168,9 @@ func TestCommonStudents1(t *testing.T) {
 }
 
 func TestCommonStudents2(t *testing.T) {
-	store, err := NewPostgresStore()
-	if err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	if err := store.Init(); err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		port = "3000"
-	}
-	server := NewAPIServer(":"+port, store)
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
 
 	// Define the query parameter
 	queryParam := "teacher=teacherken%40gmail.com&teacher=teacherjoe%40gmail.com"
@@ -253,23 +223,28 @@ func TestCommonStudents2(t *testing.T) {
 	fmt.Println("--- Passed CommonStudent2 Test")
 }
 
-func TestSuspend(t *testing.T) {
-	store, err := NewPostgresStore()
-	if err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	if err := store.Init(); err != nil {
-		t.Fatal(err)
-		return
+// the teacher emails are bound as values, so quotes in them cannot change
+// the query
+func TestCommonStudentsQuotedEmails(t *testing.T) {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
+
+	for _, teachers := range [][]string{
+		{"teacherken@gmail.com", "x') OR 1=1 --"},
+		{"teacherken@gmail.com", "teacher'o@gmail.com"},
+	} {
+		common, err := store.GetCommonStudentsOfTeachers(teachers)
+		if err != nil || len(common) != 0 {
+			t.Errorf("GetCommonStudentsOfTeachers(%q): got %v, %v", teachers, common, err)
+		}
 	}
+}
 
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		port = "3000"
-	}
-	server := NewAPIServer(":"+port, store)
+func TestSuspend(t *testing.T) {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
 
 	requestBody := []byte(`{
         "student": "studentjon@gmail.com"
@@ -302,23 +277,14 @@ func TestSuspend(t *testing.T) {
 }
 
 func TestNotification1(t *testing.T) {
-	store, err := NewPostgresStore()
-	if err != nil {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
+	if err := server.service.SuspendStudent(context.Background(), "studentjon@gmail.com"); err != nil {
 		t.Fatal(err)
 		return
 	}
 
-	if err := store.Init(); err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		port = "3000"
-	}
-	server := NewAPIServer(":"+port, store)
-
 	requestBody := []byte(`{
 		"teacher":  "teacherken@gmail.com",
 		"notification": "Hello students! @student_only_under_teacher_joe@gmail.com"
@@ -374,23 +340,14 @@ func TestNotification1(t *testing.T) {
 }
 
 func TestNotification2(t *testing.T) {
-	store, err := NewPostgresStore()
-	if err != nil {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
+	if err := server.service.SuspendStudent(context.Background(), "studentjon@gmail.com"); err != nil {
 		t.Fatal(err)
 		return
 	}
 
-	if err := store.Init(); err != nil {
-		t.Fatal(err)
-		return
-	}
-
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		port = "3000"
-	}
-	server := NewAPIServer(":"+port, store)
-
 	requestBody := []byte(`{
 		"teacher":  "teacherken@gmail.com",
 		"notification": "Hey everybody"
@@ -444,6 +401,329 @@ func TestNotification2(t *testing.T) {
 	fmt.Println("--- Passed Notification2 Test")
 }
 
+func TestGetTeachersOfStudent(t *testing.T) {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
+
+	req, err := http.NewRequest("GET", "/api/students/studentjon%40gmail.com/teachers", nil)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	req = mux.SetURLVars(req, map[string]string{"email": "studentjon@gmail.com"})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(makeHTTPHandlerFunc(server.getTeachersOfStudent))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusOK)
+		return
+	}
+
+	var responseBody TeachersResponse
+	if err := json.NewDecoder(rr.Body).Decode(&responseBody); err != nil {
+		t.Errorf("failed to decode response body: %v", err)
+		return
+	}
+
+	teachers := []string{}
+	for _, teacher := range responseBody.Teachers {
+		teachers = append(teachers, teacher.Email)
+	}
+	expected := []string{"teacherjoe@gmail.com", "teacherken@gmail.com"}
+
+	if !reflect.DeepEqual(teachers, expected) {
+		t.Errorf("handler returned unexpected teachers: got %v, want %v", teachers, expected)
+		return
+	}
+
+	fmt.Println("--- Passed GetTeachersOfStudent Test")
+}
+
+func TestExportRoster(t *testing.T) {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
+	if err := server.service.SuspendStudent(context.Background(), "studentjon@gmail.com"); err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	req, err := http.NewRequest("GET", "/api/export?format=csv", nil)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(makeHTTPHandlerFunc(server.exportRoster))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusOK)
+		return
+	}
+
+	lines := strings.Split(rr.Body.String(), "\n")
+	for _, expected := range []string{
+		"teacher,student,suspended",
+		"teacherken@gmail.com,studentjon@gmail.com,true",
+		"teacherken@gmail.com,studenthon@gmail.com,false",
+	} {
+		if !StringExistsInArray(expected, lines) {
+			t.Errorf("export is missing line %q", expected)
+		}
+	}
+
+	fmt.Println("--- Passed ExportRoster Test")
+}
+
+func TestUpdateStudentProfile(t *testing.T) {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+	seedTestRoster(t, server)
+
+	requestBody := []byte(`{
+		"name": "Hon",
+		"grade_level": 7,
+		"contact_preference": "sms"
+	}`)
+
+	req, err := http.NewRequest("PATCH", "/api/students/studenthon%40gmail.com", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	req = mux.SetURLVars(req, map[string]string{"email": "studenthon@gmail.com"})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(makeHTTPHandlerFunc(server.updateStudent))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusOK)
+		return
+	}
+
+	student, err := store.GetStudentByEmail("studenthon@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	if student.Name != "Hon" || student.GradeLevel == nil || *student.GradeLevel != 7 || student.ContactPreference != "sms" {
+		t.Errorf("profile was not updated: got %+v", student)
+		return
+	}
+
+	fmt.Println("--- Passed UpdateStudentProfile Test")
+}
+
+func TestRegisterClass(t *testing.T) {
+	store := newTestStore(t)
+	server := NewAPIServer(":0", store)
+
+	requests := []struct {
+		method string
+		path   string
+		vars   map[string]string
+		body   string
+		fn     apiFunc
+		status int
+	}{
+		{"POST", "/api/classes", nil, `{"name": "class_test_1a", "kind": "class"}`, server.createClass, http.StatusCreated},
+		{"POST", "/api/classes/class_test_1a/students", map[string]string{"name": "class_test_1a"},
+			`{"students": ["student_in_class_1a@gmail.com", "studenthon@gmail.com"]}`, server.addClassStudents, http.StatusNoContent},
+		{"POST", "/api/classes/class_test_1a/register", map[string]string{"name": "class_test_1a"},
+			`{"teacher": "teacher_of_class_1a@gmail.com"}`, server.registerClassToTeacher, http.StatusNoContent},
+	}
+
+	for _, request := range requests {
+		req, err := http.NewRequest(request.method, request.path, bytes.NewBufferString(request.body))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		req = mux.SetURLVars(req, request.vars)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(makeHTTPHandlerFunc(request.fn)).ServeHTTP(rr, req)
+
+		if rr.Code != request.status {
+			t.Errorf("%s %s returned wrong status code: got %v want %v",
+				request.method, request.path, rr.Code, request.status)
+			return
+		}
+	}
+
+	// every student of the class is now registered to the teacher
+	students, _, err := store.GetStudentsAssignedToTeacher("teacher_of_class_1a@gmail.com", ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	expected := []string{"student_in_class_1a@gmail.com", "studenthon@gmail.com"}
+	sort.Strings(students)
+
+	if !reflect.DeepEqual(students, expected) {
+		t.Errorf("unexpected students registered to teacher: got %v, want %v", students, expected)
+		return
+	}
+
+	fmt.Println("--- Passed RegisterClass Test")
+}
+
+func TestListClauses(t *testing.T) {
+	created := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+	suspended := false
+	cursor := encodeCursor(SortByCreatedAt, "studentjon@gmail.com", created)
+
+	clauses, args, err := buildListClauses("Student", []string{"ts.teacher_email = $1"}, []any{"teacherken@gmail.com"}, ListOptions{
+		Limit:       10,
+		Cursor:      cursor,
+		Sort:        SortByCreatedAt,
+		Descending:  true,
+		EmailPrefix: "student_",
+		Suspended:   &suspended,
+	})
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	expected := ` WHERE ts.teacher_email = $1 AND Student.email LIKE $2 AND COALESCE(Student.is_suspended, false) = $3` +
+		` AND (Student.created_at, Student.email) < ($4, $5) ORDER BY Student.created_at DESC, Student.email DESC LIMIT $6`
+	if clauses != expected {
+		t.Errorf("unexpected clauses: got %q, want %q", clauses, expected)
+	}
+
+	expectedArgs := []any{"teacherken@gmail.com", `student\_%`, false, created, "studentjon@gmail.com", 11}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("unexpected args: got %v, want %v", args, expectedArgs)
+	}
+
+	// a cursor can only be used with the sort it was made for
+	if _, _, err := buildListClauses("Student", nil, nil, ListOptions{Cursor: cursor}); err == nil {
+		t.Errorf("expected an error for a cursor of another sort")
+	}
+
+	// classes are keyed by name
+	clauses, args, err = buildKeyedListClauses("Class", "name", nil, nil, ListOptions{
+		Limit:       10,
+		Cursor:      encodeCursor(SortByEmail, "1A", time.Time{}),
+		EmailPrefix: "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	expected = ` WHERE Class.name LIKE $1 AND Class.name > $2 ORDER BY Class.name ASC LIMIT $3`
+	if clauses != expected {
+		t.Errorf("unexpected class clauses: got %q, want %q", clauses, expected)
+	}
+	if expectedArgs := []any{"1%", "1A", 11}; !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("unexpected class args: got %v, want %v", args, expectedArgs)
+	}
+
+	fmt.Println("--- Passed ListClauses Test")
+}
+
+func TestReplaceEmail(t *testing.T) {
+	pattern := emailPattern("jon@gmail.com")
+	for _, test := range []struct {
+		text, expected string
+	}{
+		{"Hello @jon@gmail.com, see you", "Hello @erased, see you"},
+		{"Write to Jon@Gmail.com.", "Write to erased."},
+		{`["jon@gmail.com","hon@gmail.com"]`, `["erased","hon@gmail.com"]`},
+		{"jon@gmail.com\njon@gmail.com", "erased\nerased"},
+		// longer emails are left alone
+		{"@jon@gmail.community @tjon@gmail.com jon@gmail.com.sg jon@gmail.com-x", "@jon@gmail.community @tjon@gmail.com jon@gmail.com.sg jon@gmail.com-x"},
+	} {
+		if got := replaceEmail(test.text, pattern, "erased"); got != test.expected {
+			t.Errorf("replaceEmail(%q): got %q, want %q", test.text, got, test.expected)
+		}
+	}
+
+	payload, err := replaceEmailInJSON(json.RawMessage(`{"notification":"Hi jon@gmail.com","notification_id":12345678901,"recipients":["jon@gmail.com"]}`), pattern, "erased")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"notification":"Hi erased","notification_id":12345678901,"recipients":["erased"]}`; string(payload) != expected {
+		t.Errorf("replaceEmailInJSON: got %s, want %s", payload, expected)
+	}
+}
+
+func TestParseRosterCSV(t *testing.T) {
+	roster := `class,student,teacher
+1A,studentjon@gmail.com,teacherken@gmail.com
+,StudentHon@Gmail.com,TeacherKen@gmail.com
+1A,not-an-email,teacherken@gmail.com
+1B,studentjon@gmail.com
+`
+
+	rows, rowErrors, err := ParseRosterCSV(strings.NewReader(roster))
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	expectedRows := []RosterRow{
+		{Line: 2, TeacherEmail: "teacherken@gmail.com", StudentEmail: "studentjon@gmail.com", ClassName: "1A"},
+		{Line: 3, TeacherEmail: "teacherken@gmail.com", StudentEmail: "studenthon@gmail.com"},
+	}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("unexpected rows: got %+v, want %+v", rows, expectedRows)
+	}
+
+	expectedErrors := []RosterRowError{
+		{Line: 4, Error: "invalid student email not-an-email"},
+		{Line: 5, Error: "teacher email is required"},
+	}
+	if !reflect.DeepEqual(rowErrors, expectedErrors) {
+		t.Errorf("unexpected row errors: got %+v, want %+v", rowErrors, expectedErrors)
+	}
+
+	fmt.Println("--- Passed ParseRosterCSV Test")
+}
+
+func TestPrintImportReport(t *testing.T) {
+	report := NewImportReport(false)
+	report.Rows = 1
+	report.NewTeachers = []string{"teacherken@gmail.com"}
+	report.NewRegistrations = []TeacherStudentPair{{TeacherEmail: "teacherken@gmail.com", StudentEmail: "studentjon@gmail.com"}}
+
+	var table bytes.Buffer
+	if err := printImportReport(&cliOutput{w: &table}, report); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"CHANGE", "new teacher", "new registration", "1 rows imported"} {
+		if !strings.Contains(table.String(), expected) {
+			t.Errorf("table output is missing %q:\n%s", expected, table.String())
+		}
+	}
+
+	var output bytes.Buffer
+	if err := printImportReport(&cliOutput{w: &output, json: true}, report); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ImportReport
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatalf("json output is not valid JSON: %v\n%s", err, output.String())
+	}
+	if !reflect.DeepEqual(&decoded, report) {
+		t.Errorf("unexpected json output: got %+v, want %+v", decoded, report)
+	}
+
+	fmt.Println("--- Passed PrintImportReport Test")
+}
+
 // toStringSlice converts an interface{} slice to a []string slice
 func toStringSlice(slice interface{}) []string {
 	if slice == nil {
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// headers of a webhook delivery. the signature is
+// sha256=HEX(HMAC-SHA256(secret, timestamp + "." + body)) so receivers can
+// check both who sent the event and when
+const (
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookIDHeader        = "X-Webhook-ID"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// address ranges a webhook must not reach on top of the loopback, private,
+// link-local and multicast ones, which net/netip already knows about
+var blockedWebhookPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT, also used for cloud metadata
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// reports whether a webhook may be delivered to ip. anything on the server's
+// own host or network is refused, including the 169.254.169.254 metadata
+// endpoint of cloud providers, so a subscription cannot be used to reach it
+func isPublicWebhookAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedWebhookPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checks that rawURL is an http or https URL whose host only resolves to
+// public addresses. allowPrivate skips the address check
+func validateWebhookURL(ctx context.Context, rawURL string, allowPrivate bool) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("invalid webhook url %s", rawURL)
+	}
+	if allowPrivate {
+		return nil
+	}
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("cannot resolve webhook host %s", parsed.Hostname())
+	}
+	for _, ip := range ips {
+		if !isPublicWebhookAddress(ip) {
+			return fmt.Errorf("webhook url %s points to a private address", rawURL)
+		}
+	}
+	return nil
+}
+
+// how many events can wait to be dispatched before new ones are dropped
+const webhookQueueSize = 1024
+
+// an EventPublisher that pushes events to the webhook subscriptions that
+// want them. a failed delivery is retried with exponential backoff and every
+// attempt is kept in the delivery log of the subscription
+type WebhookDispatcher struct {
+	store  Storage
+	client *http.Client
+	queue  chan *Event
+	wg     sync.WaitGroup
+	// cancelled by Close to cut the waits between retries short
+	closing context.Context
+	cancel  context.CancelFunc
+
+	// a delivery is attempted at most MaxAttempts times, waiting Backoff
+	// before the first retry and twice as long before each next one
+	MaxAttempts int
+	Backoff     time.Duration
+	// lets deliveries reach loopback and private addresses, for tests and
+	// local development
+	AllowPrivateAddresses bool
+}
+
+func NewWebhookDispatcher(store Storage) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		store:       store,
+		queue:       make(chan *Event, webhookQueueSize),
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+	}
+	d.closing, d.cancel = context.WithCancel(context.Background())
+
+	// the address is checked again when connecting, after DNS resolution,
+	// so a host that resolved to a public address when the webhook was
+	// created cannot be pointed at a private one later. deliveries do not go
+	// through a proxy, which would hide the address being connected to
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: d.checkDialAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	d.client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+
+	d.wg.Add(1)
+	go d.run()
+	return d
+}
+
+func (d *WebhookDispatcher) checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if d.AllowPrivateAddresses {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicWebhookAddress(addrPort.Addr()) {
+		return fmt.Errorf("webhook address %s is not public", addrPort.Addr())
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) Publish(event *Event) {
+	select {
+	case d.queue <- event:
+	default:
+		log.Printf("webhook queue is full, dropping event %s", event.ID)
+	}
+}
+
+// stops taking events and waits for the attempts in flight. the queued
+// events are still attempted once, but no retry is waited for: each
+// delivery that has not succeeded is logged as abandoned
+func (d *WebhookDispatcher) Close() {
+	close(d.queue)
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *WebhookDispatcher) run() {
+	defer d.wg.Done()
+
+	for event := range d.queue {
+		webhooks, err := d.store.GetWebhooks()
+		if err != nil {
+			log.Printf("loading webhooks for event %s: %v", event.ID, err)
+			continue
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("encoding event %s: %v", event.ID, err)
+			continue
+		}
+
+		for _, webhook := range webhooks {
+			if webhook.Wants(event.Type) {
+				d.wg.Add(1)
+				go d.deliver(webhook, event, body)
+			}
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliver(webhook *WebhookSubscription, event *Event, body []byte) {
+	defer d.wg.Done()
+
+	backoff := d.Backoff
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-d.closing.Done():
+				timer.Stop()
+				d.logDelivery(webhook, event, attempt, 0, errWebhookAbandoned)
+				return
+			}
+			backoff *= 2
+		}
+
+		statusCode, err := d.send(webhook, event, body)
+		d.logDelivery(webhook, event, attempt, statusCode, err)
+		if err == nil {
+			return
+		}
+	}
+}
+
+// logged in place of the retries that were not made because the dispatcher
+// was closed
+var errWebhookAbandoned = errors.New("abandoned, the server shut down before the retry")
+
+func (d *WebhookDispatcher) logDelivery(webhook *WebhookSubscription, event *Event, attempt int, statusCode int, err error) {
+	delivery := &WebhookDelivery{
+		WebhookID:  webhook.ID,
+		EventID:    event.ID,
+		EventType:  event.Type,
+		Attempt:    attempt,
+		StatusCode: statusCode,
+		Success:    err == nil,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	if err := d.store.CreateWebhookDelivery(delivery); err != nil {
+		log.Printf("logging delivery of event %s to webhook %d: %v", event.ID, webhook.ID, err)
+	}
+}
+
+// posts the event once, a response other than 2xx is an error
+func (d *WebhookDispatcher) send(webhook *WebhookSubscription, event *Event, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event.Type)
+	req.Header.Set(webhookIDHeader, event.ID)
+	req.Header.Set(webhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhookSignatureHeader, SignWebhookPayload(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies [][]byte
+
+	// the receiver fails the first delivery so it is retried
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+		bodies = append(bodies, body)
+		if len(received) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	store := newFakeStore()
+	service := NewSchoolService(store)
+	// the receiver listens on a loopback address
+	service.AllowPrivateWebhooks = true
+	ctx := context.Background()
+
+	created, err := service.CreateWebhook(ctx, receiver.URL, []string{EventStudentSuspended}, "s3cret")
+	if err != nil || created.Secret != "s3cret" {
+		t.Fatalf("CreateWebhook: got %+v, %v", created, err)
+	}
+	// the secret is only shown when the webhook is created
+	webhooks, err := service.ListWebhooks(ctx)
+	if err != nil || len(webhooks) != 1 || webhooks[0].Secret != "" {
+		t.Errorf("ListWebhooks: got %+v, %v", webhooks, err)
+	}
+	if _, err := service.CreateWebhook(ctx, "ftp://example.com", nil, ""); err == nil {
+		t.Errorf("expected an error for a webhook url that is not http")
+	}
+	if _, err := service.CreateWebhook(ctx, receiver.URL, []string{"StudentExpelled"}, ""); err == nil {
+		t.Errorf("expected an error for an unknown event type")
+	}
+
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.Backoff = 10 * time.Millisecond
+	dispatcher.AllowPrivateAddresses = true
+	// not subscribed to, so it is not delivered
+	dispatcher.Publish(NewEvent(EventStudentRegistered, RegistrationEvent{TeacherEmail: "teacherken@gmail.com", StudentEmail: "studentjon@gmail.com"}))
+	event := NewEvent(EventStudentSuspended, SuspensionEvent{StudentEmail: "studentjon@gmail.com"})
+	dispatcher.Publish(event)
+	// Close would abandon the retry
+	waitForDeliveries(t, store, 2)
+	dispatcher.Close()
+
+	if len(received) != 2 {
+		t.Fatalf("got %d deliveries, want 2", len(received))
+	}
+	for i, r := range received {
+		timestamp, err := strconv.ParseInt(r.Header.Get(webhookTimestampHeader), 10, 64)
+		if err != nil {
+			t.Fatalf("invalid timestamp header: %v", err)
+		}
+		if r.Header.Get(webhookSignatureHeader) != SignWebhookPayload("s3cret", timestamp, bodies[i]) {
+			t.Errorf("delivery %d has an invalid signature", i+1)
+		}
+		if r.Header.Get(webhookIDHeader) != event.ID || r.Header.Get(webhookEventHeader) != EventStudentSuspended {
+			t.Errorf("delivery %d has unexpected headers %v", i+1, r.Header)
+		}
+	}
+
+	if len(store.deliveries) != 2 {
+		t.Fatalf("got %d logged deliveries, want 2", len(store.deliveries))
+	}
+	first, second := store.deliveries[0], store.deliveries[1]
+	if first.Success || first.StatusCode != http.StatusServiceUnavailable || first.Attempt != 1 {
+		t.Errorf("unexpected first delivery %+v", first)
+	}
+	if !second.Success || second.StatusCode != http.StatusOK || second.Attempt != 2 {
+		t.Errorf("unexpected second delivery %+v", second)
+	}
+}
+
+func TestWebhookCloseAbandonsRetries(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	store := newFakeStore()
+	if err := store.CreateWebhook(NewWebhookSubscription(receiver.URL, nil, "s3cret")); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.Backoff = time.Hour
+	dispatcher.AllowPrivateAddresses = true
+	dispatcher.Publish(NewEvent(EventStudentSuspended, SuspensionEvent{StudentEmail: "studentjon@gmail.com"}))
+	waitForDeliveries(t, store, 1)
+
+	closed := make(chan struct{})
+	go func() {
+		dispatcher.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the retry")
+	}
+
+	if len(store.deliveries) != 2 {
+		t.Fatalf("got %d logged deliveries, want 2", len(store.deliveries))
+	}
+	if abandoned := store.deliveries[1]; abandoned.Success || abandoned.Attempt != 2 || abandoned.Error != errWebhookAbandoned.Error() {
+		t.Errorf("unexpected abandoned delivery %+v", abandoned)
+	}
+}
+
+// waits until the dispatcher has logged n deliveries
+func waitForDeliveries(t *testing.T, store *fakeStore, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		store.mu.Lock()
+		logged := len(store.deliveries)
+		store.mu.Unlock()
+		if logged >= n {
+			return
+		}
+	}
+	t.Fatalf("got fewer than %d logged deliveries", n)
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.100.100.200": false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00:ec2::254":   false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := isPublicWebhookAddress(netip.MustParseAddr(address)); got != public {
+			t.Errorf("isPublicWebhookAddress(%s): got %v, want %v", address, got, public)
+		}
+	}
+
+	store := newFakeStore()
+	service := NewSchoolService(store)
+	ctx := context.Background()
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/hook",
+		"https://10.0.0.5/hook",
+	} {
+		if _, err := service.CreateWebhook(ctx, url, nil, ""); err == nil {
+			t.Errorf("expected an error for a webhook to %s", url)
+		}
+	}
+
+	// a host that resolved to a public address when the webhook was created
+	// can later resolve to a private one, so the address is checked again
+	// when connecting
+	delivered := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered = true
+	}))
+	defer receiver.Close()
+	if err := store.CreateWebhook(NewWebhookSubscription(receiver.URL, nil, "s3cret")); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.MaxAttempts = 1
+	dispatcher.Publish(NewEvent(EventStudentSuspended, SuspensionEvent{StudentEmail: "studentjon@gmail.com"}))
+	dispatcher.Close()
+
+	if delivered {
+		t.Errorf("the event was delivered to a loopback address")
+	}
+	if len(store.deliveries) != 1 || store.deliveries[0].Success || !strings.Contains(store.deliveries[0].Error, "not public") {
+		t.Errorf("unexpected deliveries %+v", store.deliveries)
+	}
+}